@@ -3,37 +3,65 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
+	"strings"
 
 	// "github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/db"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/controllers"
 	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/db"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
 	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
 	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/routes"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/joho/godotenv"
 )
 
 func main() {
 
-	app := fiber.New()
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	// Rate limits and login lockouts key on c.IP(). Behind a proxy it is read
+	// from PROXY_HEADER, but only on requests from TRUSTED_PROXIES; anyone
+	// else gets their own address. Use a header the proxy overwrites, such
+	// as X-Real-IP.
+	app := fiber.New(fiber.Config{
+		ProxyHeader:             os.Getenv("PROXY_HEADER"),
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          strings.Fields(strings.ReplaceAll(os.Getenv("TRUSTED_PROXIES"), ",", " ")),
+		EnableIPValidation:      true,
+	})
+
+	app.Use(requestid.New())
 
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
 		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders: "Origin, Content-Type, Accept, Authorization",
+		AllowHeaders: "Origin, Content-Type, Accept, Authorization, X-API-Key",
 	}))
 
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-
 	db.DbConnection()
+	middleware.SetupTokenService()
+	middleware.SetupSessionStore(db.DB)
+	middleware.SetupRoleStore(db.DB)
+	middleware.SetupAPIKeyStore(db.DB)
+	middleware.SetupRateLimiter(db.DB)
+	middleware.SetupOIDC(db.DB)
+	controllers.SetupUsers(db.DB)
+	controllers.SetupLossModels()
+	controllers.SetupGridDistribution(db.DB)
+	controllers.SetupBalanceScheduler(db.DB)
 
 	routes.AuthRoutes(app)
 	routes.SetupRoutes(app)
 	routes.SetupGridDistributionRoutes(app, db.DB)
 	routes.SetupPowerRoutes(app, db.DB)
+	routes.SetupHistoryRoutes(app, db.DB)
+	routes.SetupIngestRoutes(app)
 	// db.GetCollection("users")
 	app.Get("/ping", func(c *fiber.Ctx) error {
 		return c.Status(http.StatusOK).JSON(&models.Response{
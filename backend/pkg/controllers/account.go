@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/db"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ChangePassword lets an authenticated user replace their own password after
+// proving they know the current one.
+func ChangePassword(c *fiber.Ctx) error {
+	var userCollection = db.GetCollection("users")
+
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	userID, err := primitive.ObjectIDFromHex(principal.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req models.PasswordChange
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if err := validatePassword(req.NewPassword); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var user models.User
+	err = userCollection.FindOne(context.TODO(), bson.M{"_id": userID}).Decode(&user)
+	if err != nil || user.Disabled {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	if !checkPassword(user.Password, req.CurrentPassword) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
+	}
+
+	if err := setPassword(user.ID, req.NewPassword); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not change password"})
+	}
+
+	// Keep the session that proved the old password; end all others.
+	currentSession, _ := primitive.ObjectIDFromHex(principal.SessionID)
+	if err := middleware.Sessions.RevokeAllForUser(c.Context(), user.ID, currentSession, "password changed"); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not revoke sessions"})
+	}
+
+	recordAudit(c, models.AuditPasswordChange, "user", user.ID.Hex(), nil, nil)
+	return c.JSON(fiber.Map{"message": "Password changed successfully"})
+}
+
+// IssuePasswordReset creates a single-use reset token for a user. The raw
+// token is only returned here; an administrator passes it to the user out of
+// band.
+func IssuePasswordReset(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	count, err := db.GetCollection("users").CountDocuments(context.TODO(), bson.M{"_id": id})
+	if err != nil || count == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	token, tokenHash, err := newOpaqueToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create reset token"})
+	}
+
+	now := time.Now()
+	reset := models.PasswordResetToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    id,
+		TokenHash: tokenHash,
+		ExpiresAt: now.Add(resetTokenTTL),
+		CreatedAt: now,
+	}
+	if _, err := db.GetCollection("password_resets").InsertOne(context.TODO(), reset); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create reset token"})
+	}
+
+	recordAudit(c, models.AuditPasswordResetIssue, "user", id.Hex(), nil, reset)
+	return c.JSON(fiber.Map{"token": token, "expires_at": reset.ExpiresAt})
+}
+
+// ResetPassword redeems a reset token. The token is marked used in the same
+// update that matches it, so it cannot be replayed.
+func ResetPassword(c *fiber.Ctx) error {
+	var req models.PasswordReset
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if err := validatePassword(req.NewPassword); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	now := time.Now()
+	var reset models.PasswordResetToken
+	err := db.GetCollection("password_resets").FindOneAndUpdate(context.TODO(),
+		bson.M{
+			"token_hash": middleware.HashToken(req.Token),
+			"used_at":    nil,
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"used_at": now}},
+	).Decode(&reset)
+	if err == mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired reset token"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not reset password"})
+	}
+
+	if err := setPassword(reset.UserID, req.NewPassword); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not reset password"})
+	}
+	if err := middleware.Sessions.RevokeAllForUser(c.Context(), reset.UserID, primitive.NilObjectID, "password reset"); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not revoke sessions"})
+	}
+
+	recordAudit(c, models.AuditPasswordReset, "user", reset.UserID.Hex(), nil, nil)
+	return c.JSON(fiber.Map{"message": "Password reset successfully"})
+}
+
+// SetUserDisabled enables or disables an account. Disabling also ends all of
+// the account's sessions.
+func SetUserDisabled(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	var req struct {
+		Disabled bool `json:"disabled"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	var previous models.User
+	err = db.GetCollection("users").FindOneAndUpdate(context.TODO(),
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"disabled": req.Disabled}},
+	).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update account"})
+	}
+	recordAudit(c, models.AuditUserDisable, "user", id.Hex(),
+		bson.M{"disabled": previous.Disabled}, bson.M{"disabled": req.Disabled})
+
+	if req.Disabled {
+		if err := middleware.Sessions.RevokeAllForUser(c.Context(), id, primitive.NilObjectID, "account disabled"); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not revoke sessions"})
+		}
+		return c.JSON(fiber.Map{"message": "Account disabled"})
+	}
+	return c.JSON(fiber.Map{"message": "Account enabled"})
+}
+
+func setPassword(userID primitive.ObjectID, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = db.GetCollection("users").UpdateOne(context.TODO(),
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"password": hash, "password_changed_at": time.Now()}},
+	)
+	return err
+}
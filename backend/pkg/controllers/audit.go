@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/db"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Fields that must never be copied into the audit trail.
+var auditRedacted = map[string]bool{
+	"password":   true,
+	"token_hash": true,
+	"key_hash":   true,
+}
+
+// recordAudit appends an audit event for a change the current request has
+// already stored on its own, outside any transaction. Failures are logged,
+// not returned, because the change cannot be taken back. Changes made in a
+// transaction record their event in it with insertAudit instead.
+func recordAudit(c *fiber.Ctx, action, targetType, targetID string, before, after interface{}) {
+	event := newAuditEvent(c, action, targetType, targetID, before, after)
+	if err := insertAudit(context.TODO(), db.GetCollection("audit_events"), event); err != nil {
+		logAuditFailure(event, err)
+	}
+}
+
+// recordUserAudit is recordAudit for requests user makes before they are
+// the request's principal, such as logging in.
+func recordUserAudit(c *fiber.Ctx, user *models.User, action, targetType, targetID string, before, after interface{}) {
+	event := newAuditEvent(c, action, targetType, targetID, before, after)
+	event.ActorID = user.ID.Hex()
+	event.ActorName = user.Username
+	event.ActorKind = middleware.PrincipalUser
+	if err := insertAudit(context.TODO(), db.GetCollection("audit_events"), event); err != nil {
+		logAuditFailure(event, err)
+	}
+}
+
+// newAuditEvent describes an action taken by the current request. before
+// and after may be any BSON-encodable value or nil; only top-level fields
+// that differ end up in the diff.
+func newAuditEvent(c *fiber.Ctx, action, targetType, targetID string, before, after interface{}) models.AuditEvent {
+	event := models.AuditEvent{
+		Timestamp:  time.Now(),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     toAuditDoc(before),
+		After:      toAuditDoc(after),
+		SourceIP:   c.IP(),
+	}
+	event.Diff = diffAuditDocs(event.Before, event.After)
+
+	if rid, ok := c.Locals("requestid").(string); ok {
+		event.RequestID = rid
+	}
+	if principal, ok := middleware.GetPrincipal(c); ok {
+		event.ActorID = principal.UserID
+		event.ActorName = principal.Username
+		event.ActorKind = principal.Kind
+	}
+	return event
+}
+
+// insertAudit stores event in audits. Given a mongo.SessionContext it joins
+// that transaction, so the event commits or rolls back with the change.
+func insertAudit(ctx context.Context, audits *mongo.Collection, event models.AuditEvent) error {
+	_, err := audits.InsertOne(ctx, event)
+	return err
+}
+
+func logAuditFailure(event models.AuditEvent, err error) {
+	log.Printf("audit: could not record %s on %s/%s (request %s): %v", event.Action, event.TargetType, event.TargetID, event.RequestID, err)
+}
+
+func toAuditDoc(v interface{}) bson.M {
+	if v == nil {
+		return nil
+	}
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return bson.M{"unencodable": err.Error()}
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return bson.M{"unencodable": err.Error()}
+	}
+	for field := range auditRedacted {
+		delete(doc, field)
+	}
+	return doc
+}
+
+func diffAuditDocs(before, after bson.M) map[string]models.AuditChange {
+	diff := map[string]models.AuditChange{}
+	for k, b := range before {
+		if a, ok := after[k]; !ok || !reflect.DeepEqual(a, b) {
+			diff[k] = models.AuditChange{Before: b, After: after[k]}
+		}
+	}
+	for k, a := range after {
+		if _, ok := before[k]; !ok {
+			diff[k] = models.AuditChange{Before: nil, After: a}
+		}
+	}
+	if len(diff) == 0 {
+		return nil
+	}
+	return diff
+}
+
+// GetAuditEvents lists audit events, newest first, filtered by actor
+// (ID or name), target, action and an RFC 3339 time range, a page at a
+// time.
+func GetAuditEvents(c *fiber.Ctx) error {
+	filter := bson.M{}
+	if actor := c.Query("actor"); actor != "" {
+		filter["$or"] = []bson.M{{"actor_id": actor}, {"actor_name": actor}}
+	}
+	if target := c.Query("target"); target != "" {
+		filter["target_id"] = target
+	}
+	if targetType := c.Query("target_type"); targetType != "" {
+		filter["target_type"] = targetType
+	}
+	if action := c.Query("action"); action != "" {
+		filter["action"] = action
+	}
+
+	from, to, msg := queryTimeRange(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+	timeRangeFilter(filter, "timestamp", from, to)
+
+	page, limit, msg := pageParams(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
+	}
+
+	opts := options.Find().SetSort(bson.M{"timestamp": -1}).SetSkip((page - 1) * limit).SetLimit(limit)
+	cursor, err := db.GetCollection("audit_events").Find(context.TODO(), filter, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch audit events"})
+	}
+
+	events := []models.AuditEvent{}
+	if err := cursor.All(context.TODO(), &events); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch audit events"})
+	}
+	return c.JSON(events)
+}
@@ -2,18 +2,30 @@ package controllers
 
 import (
 	"context"
-	"os"
+	"log"
 	"time"
 
 	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/db"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
 	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+// SetupUsers creates the unique username index that keeps concurrent
+// registrations from claiming the same name.
+func SetupUsers(database *mongo.Database) {
+	_, err := database.Collection("users").Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("Could not create user indexes:", err)
+	}
+}
 
 func Register(c *fiber.Ctx) error {
 	var userCollection = db.GetCollection("users")
@@ -23,16 +35,48 @@ func Register(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
 	}
 
+	if user.Username == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Username required"})
+	}
+	if err := validatePassword(user.Password); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// Self-registered accounts start without a role; an administrator
+	// assigns one through UpdateRole, which checks for escalation.
+	if user.Role != "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Roles are assigned by an administrator"})
+	}
 	user.ID = primitive.NewObjectID()
-	if user.Role == "enterprise_customer" && user.Enterprise == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Enterprise name required"})
+	user.Enterprise = ""
+
+	count, err := userCollection.CountDocuments(context.TODO(), bson.M{"username": user.Username})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not register user"})
+	}
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Username already taken"})
 	}
 
-	_, err := userCollection.InsertOne(context.TODO(), user)
+	hash, err := hashPassword(user.Password)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not register user"})
 	}
+	user.Password = hash
+	user.Disabled = false
+	user.CreatedAt = time.Now()
+	user.PasswordChangedAt = user.CreatedAt
 
+	_, err = userCollection.InsertOne(context.TODO(), user)
+	if mongo.IsDuplicateKeyError(err) {
+		// Lost a race with another registration for the same name.
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Username already taken"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not register user"})
+	}
+
+	recordAudit(c, models.AuditUserRegister, "user", user.ID.Hex(), nil, user)
 	return c.JSON(fiber.Map{"message": "User registered successfully"})
 }
 
@@ -44,27 +88,163 @@ func Login(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
 	}
 
+	wait, err := middleware.Lockout.Locked(c.Context(), creds.Username)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not verify credentials"})
+	}
+	if wait > 0 {
+		return middleware.RetryAfter(c, wait)
+	}
+
 	var user models.User
-	err := userCollection.FindOne(context.TODO(), bson.M{"username": creds.Username}).Decode(&user)
+	err = userCollection.FindOne(context.TODO(), bson.M{"username": creds.Username}).Decode(&user)
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
+		// Still run bcrypt so unknown usernames are not faster to reject,
+		// and count the failure so they cannot be probed without lockout.
+		checkPassword("", creds.Password)
+		return rejectLogin(c, creds.Username, "Invalid credentials")
 	}
 
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &models.Claims{
-		Username:   creds.Username,
-		Role:       user.Role,
-		Enterprise: user.Enterprise,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-		},
+	if !checkPassword(user.Password, creds.Password) {
+		return rejectLogin(c, creds.Username, "Invalid credentials")
+	}
+	clearLoginFailures(c, creds.Username)
+
+	if user.Disabled {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Account disabled"})
+	}
+
+	return completeLogin(c, &user, false)
+}
+
+// rejectLogin answers a failed login attempt with 401 once it counts towards
+// the account's lockout. An attempt that cannot be counted fails with 500
+// instead, so the lockout cannot be outrun while its store is unavailable.
+func rejectLogin(c *fiber.Ctx, username, msg string) error {
+	if err := middleware.Lockout.RecordFailure(c.Context(), username); err != nil {
+		log.Printf("lockout: could not record failed login for %q: %v", username, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not verify credentials"})
 	}
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": msg})
+}
+
+// clearLoginFailures ends the account's failure streak after a successful
+// login. The login goes ahead if that fails; the streak then expires on its
+// own.
+func clearLoginFailures(c *fiber.Ctx, username string) {
+	if err := middleware.Lockout.RecordSuccess(c.Context(), username); err != nil {
+		log.Printf("lockout: could not clear failed logins for %q: %v", username, err)
+	}
+}
+
+// completeLogin finishes a login whose first factor checked out. An account
+// with MFA enrolled gets a challenge to answer at /login/mfa instead of a
+// session, unless mfa says the first factor was already multi-factor.
+func completeLogin(c *fiber.Ctx, user *models.User, mfa bool) error {
+	if user.MFAEnabled && !mfa {
+		challenge, expiresAt, err := middleware.Tokens.IssueMFAChallenge(user.ID.Hex())
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
+		}
+		return c.JSON(fiber.Map{"mfa_required": true, "mfa_token": challenge, "expires_at": expiresAt})
+	}
+
+	return issueSession(c, user, mfa)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtSecret)
+// Refresh rotates a refresh token: the presented token is spent and a new
+// access/refresh pair from the same session is returned.
+func Refresh(c *fiber.Ctx) error {
+	var req models.RefreshRequest
+	if err := c.BodyParser(&req); err != nil || req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	session, err := middleware.Sessions.Consume(c.Context(), req.RefreshToken)
+	if err == middleware.ErrRefreshTokenReused {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Refresh token reuse detected; session revoked"})
+	}
+	if err == middleware.ErrInvalidRefreshToken {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid refresh token"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not refresh session"})
+	}
+
+	var user models.User
+	err = db.GetCollection("users").FindOne(context.TODO(), bson.M{"_id": session.UserID}).Decode(&user)
+	if err != nil || user.Disabled {
+		middleware.Sessions.Revoke(c.Context(), session.ID, "account unavailable")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid refresh token"})
+	}
+
+	recordUserAudit(c, &user, models.AuditRefresh, "session", session.ID.Hex(), nil, nil)
+	return respondWithTokens(c, &user, session)
+}
+
+// Logout revokes the caller's current session.
+func Logout(c *fiber.Ctx) error {
+	principal, _ := middleware.GetPrincipal(c)
+	sessionID, err := primitive.ObjectIDFromHex(principal.SessionID)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	if err := middleware.Sessions.Revoke(c.Context(), sessionID, "logout"); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not log out"})
+	}
+	recordAudit(c, models.AuditLogout, "session", sessionID.Hex(), nil, nil)
+	return c.JSON(fiber.Map{"message": "Logged out"})
+}
+
+// LogoutAll revokes every session of the caller, including the current one.
+func LogoutAll(c *fiber.Ctx) error {
+	principal, _ := middleware.GetPrincipal(c)
+	userID, err := primitive.ObjectIDFromHex(principal.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	if err := middleware.Sessions.RevokeAllForUser(c.Context(), userID, primitive.NilObjectID, "logout all"); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not log out"})
+	}
+	recordAudit(c, models.AuditLogoutAll, "user", userID.Hex(), nil, nil)
+	return c.JSON(fiber.Map{"message": "All sessions logged out"})
+}
+
+// issueSession starts a new session for an authenticated user and returns
+// its first token pair.
+func issueSession(c *fiber.Ctx, user *models.User, mfa bool) error {
+	session, err := middleware.Sessions.Create(c.Context(), user.ID, c.Get("User-Agent"), c.IP(), mfa)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create session"})
+	}
+	recordUserAudit(c, user, models.AuditLogin, "session", session.ID.Hex(), nil, bson.M{"mfa": mfa, "user_agent": session.UserAgent})
+	return respondWithTokens(c, user, session)
+}
+
+func respondWithTokens(c *fiber.Ctx, user *models.User, session *models.Session) error {
+	refreshToken, refreshExpiresAt, err := middleware.Sessions.IssueRefreshToken(c.Context(), session)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
+	}
+
+	tokenString, expiresAt, err := middleware.Tokens.Issue(middleware.Principal{
+		UserID:     user.ID.Hex(),
+		Username:   user.Username,
+		Role:       user.Role,
+		Enterprise: user.Enterprise,
+		SessionID:  session.ID.Hex(),
+		MFA:        session.MFA,
+	})
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
 	}
 
-	return c.JSON(fiber.Map{"token": tokenString})
+	return c.JSON(fiber.Map{
+		"token":              tokenString,
+		"expires_at":         expiresAt,
+		"refresh_token":      refreshToken,
+		"refresh_expires_at": refreshExpiresAt,
+	})
 }
@@ -0,0 +1,602 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const balancePlanTTL = 15 * time.Minute
+
+var (
+	errGridConflict  = errors.New("grid was modified concurrently")
+	errPlanCommitted = errors.New("plan already committed")
+)
+
+// Shortfalls and overloads below this are rounding, not violations (MW).
+const violationTolerance = 1e-6
+
+// balanceOptions selects how planBalance balances a grid. With escalate
+// set, demand the grid cannot cover itself is offered to its sibling grids.
+type balanceOptions struct {
+	strategy BalancingStrategy
+	shedding SheddingPolicy
+	escalate bool
+}
+
+// planBalance runs the balancing pipeline against a copy of grid and
+// describes the result. Nothing is persisted.
+func (h *GridDistributionHandler) planBalance(ctx context.Context, grid *models.GridNetwork, opts balanceOptions) (*models.BalancePlan, error) {
+	work := *grid
+	work.ChildNodes = append([]models.ChildNode(nil), grid.ChildNodes...)
+
+	history, err := h.loadSheddingHistory(ctx, grid.ID)
+	if err != nil {
+		return nil, fmt.Errorf("shedding history: %w", err)
+	}
+
+	// Imports from siblings are renegotiated on every escalating run, so
+	// the grid starts from its supply without them and their senders get
+	// back what they sent.
+	var released []models.GridInterchange
+	var credits []models.SiblingUpdate
+	if opts.escalate {
+		released, err = h.activeImports(ctx, grid.ID)
+		if err != nil {
+			return nil, fmt.Errorf("active imports: %w", err)
+		}
+		for _, ic := range released {
+			work.Interchange -= ic.Delivered
+		}
+		credits, err = h.releaseCredits(ctx, released)
+		if err != nil {
+			return nil, fmt.Errorf("release credits: %w", err)
+		}
+	}
+
+	now := time.Now()
+	outputs, err := h.generationOutputs(ctx, grid, now)
+	if err != nil {
+		return nil, fmt.Errorf("generation: %w", err)
+	}
+	b := &gridBalance{work: &work, opts: opts, history: history, outputs: outputs}
+	if err := h.settle(b, now); err != nil {
+		return nil, err
+	}
+
+	// Step 5: Cover what is left from sibling grids through the parent
+	var interchanges []models.GridInterchange
+	siblings := credits
+	if need := unservedDemand(&work); opts.escalate && need > violationTolerance {
+		var escalated []models.SiblingUpdate
+		interchanges, escalated, err = h.escalate(ctx, grid, need, opts.strategy, credits)
+		if err != nil {
+			return nil, fmt.Errorf("escalate: %w", err)
+		}
+		siblings = mergeSiblingUpdates(credits, escalated)
+		if len(interchanges) > 0 {
+			for _, ic := range interchanges {
+				work.Interchange += ic.Delivered
+			}
+			if err := h.distribute(b); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	plan := &models.BalancePlan{
+		GridID:      grid.ID,
+		Enterprise:  grid.Enterprise,
+		Strategy:    opts.strategy.Name(),
+		GridVersion: grid.Version,
+		Transfers:   b.transfers,
+		TotalLoss:   totalTransferLoss(b.transfers),
+		Violations:  planViolations(&work, gridSupply(&work)+b.extra, b.transfers),
+		Shedding:    b.schedule,
+		Storage:     b.storage,
+		Curtailment: curtailExports(grid, &work, outputs, b.overLimit, b.stored),
+		Allocations: b.allocations(grid),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(balancePlanTTL),
+
+		Interchanges:     interchanges,
+		InterchangeDelta: work.Interchange - grid.Interchange,
+		Siblings:         siblings,
+	}
+	for _, ic := range released {
+		plan.ReleasedInterchanges = append(plan.ReleasedInterchanges, ic.ID)
+	}
+	return plan, nil
+}
+
+// gridBalance carries one balance of a grid through its steps. Only
+// escalation to sibling grids needs the database; settle and distribute
+// work on what is loaded here.
+type gridBalance struct {
+	work    *models.GridNetwork // copy of the grid being balanced
+	opts    balanceOptions
+	history map[primitive.ObjectID]models.SheddingHistory
+	outputs []float64 // per node, local generation
+
+	overLimit []float64
+	transfers []models.EnergyTransfer
+	routed    []models.ChildNode // the nodes once transfers are done
+	held      float64            // grid supply the nodes kept from the last balance
+	supplied  []float64          // per node, grid supply held before the base distribution
+	shares    []float64          // per node, what the base distribution added
+	storage   []models.StorageDispatch
+	extra     float64 // storage discharge, negative while charging
+	stored    float64 // leftover exports charged into storage
+	schedule  *models.SheddingSchedule
+}
+
+// settle runs the steps of a balance a grid takes on its own: generation,
+// transfers, storage and the base distribution of its supply.
+func (h *GridDistributionHandler) settle(b *gridBalance, now time.Time) error {
+	work := b.work
+
+	// Step 1: Let local generation cover its own node first. Nodes then ask
+	// the grid only for what is left, and exporting nodes have negative
+	// demand. Each node starts from the supply the last balance gave it
+	b.held, b.overLimit = startingAllocations(work, b.outputs)
+	b.supplied = make([]float64, len(work.ChildNodes))
+	for i, node := range work.ChildNodes {
+		b.supplied[i] = node.AllocatedPower
+	}
+
+	// Step 2: Move surplus and exports to the nodes that need them and get
+	// new transfers. Surplus nobody took goes back to the grid
+	var err error
+	b.transfers, err = h.handleExcessDemand(work, b.opts.strategy)
+	if err != nil {
+		return fmt.Errorf("excess demand: %w", err)
+	}
+	for i, released := range releaseSurplus(work) {
+		b.supplied[i] -= released
+		b.held -= released
+	}
+	b.routed = append([]models.ChildNode(nil), work.ChildNodes...)
+	leftover := 0.0
+	for _, node := range b.routed {
+		if node.CurrentDemand < 0 {
+			leftover += math.Max(node.AllocatedPower-node.CurrentDemand, 0)
+		}
+	}
+
+	// Step 3: Discharge storage into a shortfall, or charge it from
+	// leftover exports and then spare supply
+	gap := unservedDemand(work) - (gridSupply(work) - b.held)
+	if gap <= 0 {
+		gap -= leftover
+	}
+	var storageNet float64
+	b.storage, storageNet = dispatchStorage(work, gap, now)
+	if storageNet < 0 {
+		b.stored = math.Min(-storageNet, leftover)
+	}
+	b.extra = storageNet + b.stored
+
+	// Step 4: Calculate base distribution, shedding load if needed
+	return h.distribute(b)
+}
+
+// distribute shares the grid supply the nodes do not already hold among
+// them. It runs again when escalation brings in more supply.
+func (h *GridDistributionHandler) distribute(b *gridBalance) error {
+	schedule, shares, err := h.distributeSupply(b.work, b.routed, gridSupply(b.work)-b.held+b.extra, b.opts.shedding, b.history)
+	if err != nil {
+		return fmt.Errorf("base distribution: %w", err)
+	}
+	b.schedule, b.shares = schedule, shares
+	return nil
+}
+
+// allocations describes each node of grid before and after the balance.
+// Base is the grid supply a node ended up with, tracked apart from the
+// transfers, so the ledger can check After - Base against them.
+func (b *gridBalance) allocations(grid *models.GridNetwork) []models.NodeAllocation {
+	allocations := make([]models.NodeAllocation, 0, len(b.work.ChildNodes))
+	for i, node := range b.work.ChildNodes {
+		allocations = append(allocations, models.NodeAllocation{
+			NodeID:        node.ID,
+			Name:          node.Name,
+			CurrentDemand: grid.ChildNodes[i].CurrentDemand,
+			Before:        grid.ChildNodes[i].AllocatedPower,
+			Base:          b.supplied[i] + b.shares[i],
+			After:         node.AllocatedPower,
+			Generation:    b.outputs[i],
+		})
+	}
+	return allocations
+}
+
+// startingAllocations prepares work, a copy of a grid, for the balancing
+// strategy. Each node's demand becomes what its generation outputs leave
+// for the grid to cover, and each node keeps the grid supply the last
+// balance allocated it, so a node whose demand fell since has surplus to
+// pass on. When that supply no longer fits the grid, or the grid cannot
+// serve every node and has to shed, every node starts from nothing so that
+// shedding decides over the whole demand. It returns the supply held and
+// the export each node's capacity curtails.
+func startingAllocations(work *models.GridNetwork, outputs []float64) (float64, []float64) {
+	held, demand := 0.0, 0.0
+	overLimit := make([]float64, len(work.ChildNodes))
+	for i := range work.ChildNodes {
+		node := &work.ChildNodes[i]
+		node.CurrentDemand, overLimit[i] = netDemand(*node, outputs[i])
+		node.AllocatedPower = math.Max(node.AllocatedPower, 0)
+		held += node.AllocatedPower
+		demand += servableDemand(*node)
+	}
+	supply := gridSupply(work) + violationTolerance
+	if held > supply || demand > supply {
+		for i := range work.ChildNodes {
+			work.ChildNodes[i].AllocatedPower = 0
+		}
+		held = 0
+	}
+	return held, overLimit
+}
+
+// releaseSurplus takes back allocation above what each node needs from the
+// grid once transfers are done and returns how much it took from each.
+func releaseSurplus(work *models.GridNetwork) []float64 {
+	released := make([]float64, len(work.ChildNodes))
+	for i := range work.ChildNodes {
+		node := &work.ChildNodes[i]
+		if over := node.AllocatedPower - math.Max(node.CurrentDemand, 0); over > 0 {
+			node.AllocatedPower -= over
+			released[i] = over
+		}
+	}
+	return released
+}
+
+// planViolations lists the constraints a balanced grid still breaks. supply
+// is what the grid had to allocate, storage included.
+func planViolations(grid *models.GridNetwork, supply float64, transfers []models.EnergyTransfer) []models.PlanViolation {
+	violations := []models.PlanViolation{}
+
+	allocated := 0.0
+	for _, node := range grid.ChildNodes {
+		allocated += node.AllocatedPower
+		if short := node.CurrentDemand - node.AllocatedPower; short > violationTolerance {
+			violations = append(violations, models.PlanViolation{
+				Kind: models.ViolationUnservedDemand, ElementID: node.ID, Amount: short,
+				Detail: fmt.Sprintf("%s is %.3f MW short of demand", node.Name, short),
+			})
+		}
+		if over := node.AllocatedPower - node.Capacity; node.Capacity > 0 && over > violationTolerance {
+			violations = append(violations, models.PlanViolation{
+				Kind: models.ViolationNodeOverCapacity, ElementID: node.ID, Amount: over,
+				Detail: fmt.Sprintf("%s is allocated %.3f MW above its capacity", node.Name, over),
+			})
+		}
+	}
+	if over := allocated - supply; over > violationTolerance {
+		violations = append(violations, models.PlanViolation{
+			Kind: models.ViolationGridOverCapacity, ElementID: grid.ID, Amount: over,
+			Detail: fmt.Sprintf("allocations exceed grid capacity by %.3f MW", over),
+		})
+	}
+
+	flows := map[primitive.ObjectID]float64{}
+	for _, t := range transfers {
+		for _, line := range t.Lines {
+			flows[line] += t.Amount
+		}
+	}
+	for _, line := range grid.Lines {
+		if over := flows[line.ID] - line.ThermalRating; over > violationTolerance {
+			violations = append(violations, models.PlanViolation{
+				Kind: models.ViolationLineOverload, ElementID: line.ID, Amount: over,
+				Detail: fmt.Sprintf("line carries %.3f MW above its thermal rating", over),
+			})
+		}
+	}
+	return violations
+}
+
+// applyPlan writes the plan's allocations, generation output and storage
+// dispatch to grid and records its transfers, an allocation snapshot,
+// curtailment and the storage state of charge in one transaction. A stored
+// plan is marked committed in the same transaction. The grid update only
+// matches the version the plan was computed from, so a concurrent write
+// makes it fail with errGridConflict. cause is recorded on the snapshots of
+// every grid the plan changes. audit, when given, is completed with the
+// nodes as applied and stored in the same transaction.
+func (h *GridDistributionHandler) applyPlan(ctx context.Context, grid *models.GridNetwork, plan *models.BalancePlan, cause string, audit *models.AuditEvent) error {
+	allocations := map[primitive.ObjectID]models.NodeAllocation{}
+	for _, a := range plan.Allocations {
+		allocations[a.NodeID] = a
+	}
+	childNodes := append([]models.ChildNode(nil), grid.ChildNodes...)
+	currentLoad := 0.0
+	for i := range childNodes {
+		if a, ok := allocations[childNodes[i].ID]; ok {
+			childNodes[i].AllocatedPower = a.After
+			if gen := childNodes[i].Generation; gen != nil {
+				refreshed := *gen
+				refreshed.OutputMW = a.Generation
+				refreshed.UpdatedAt = plan.CreatedAt
+				childNodes[i].Generation = &refreshed
+			}
+		}
+		currentLoad += childNodes[i].AllocatedPower
+	}
+	lastBalanced := time.Now()
+
+	// Stored plans keep their ID in the ledger; direct balances get a fresh
+	// one so their transfers can still be matched to their snapshot.
+	ledgerID := plan.ID
+	if ledgerID.IsZero() {
+		ledgerID = primitive.NewObjectID()
+	}
+	storage, socRecords := appliedStorage(grid, plan, ledgerID, lastBalanced)
+
+	if audit != nil {
+		after := bson.M{"child_nodes": childNodes, "last_balanced": lastBalanced}
+		for k, v := range audit.After {
+			after[k] = v
+		}
+		audit.After = toAuditDoc(after)
+		audit.Diff = diffAuditDocs(audit.Before, audit.After)
+	}
+
+	session, err := h.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	set := bson.M{"child_nodes": childNodes, "current_load": currentLoad, "last_balanced": lastBalanced}
+	if len(plan.Storage) > 0 {
+		set["storage"] = storage
+	}
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		res, err := h.db.Collection("grid_networks").UpdateOne(sc,
+			gridVersionFilter(grid.ID, plan.GridVersion),
+			bson.M{"$set": set, "$inc": bson.M{"version": 1, "interchange": plan.InterchangeDelta}},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("update grid network: %w", err)
+		}
+		if res.MatchedCount == 0 {
+			return nil, errGridConflict
+		}
+
+		if audit != nil {
+			if err := insertAudit(sc, h.db.Collection("audit_events"), *audit); err != nil {
+				logAuditFailure(*audit, err)
+				return nil, fmt.Errorf("record audit event: %w", err)
+			}
+		}
+
+		meta := models.GridSnapshot{
+			Cause:   cause,
+			Action:  models.AuditGridBalance,
+			Actor:   plan.CreatedBy,
+			PlanID:  ledgerID,
+			TakenAt: lastBalanced,
+		}
+		if err := h.snapshotStored(sc, grid.ID, meta); err != nil {
+			return nil, err
+		}
+
+		meta.Action = models.SnapshotActionInterchange
+		if err := h.applyInterchanges(sc, plan, meta); err != nil {
+			return nil, err
+		}
+
+		if len(plan.Transfers) > 0 {
+			transferDocs := make([]interface{}, 0, len(plan.Transfers))
+			for _, t := range plan.Transfers {
+				t.GridID = grid.ID
+				t.PlanID = ledgerID
+				transferDocs = append(transferDocs, t)
+			}
+			if _, err := h.db.Collection("energy_transfers").InsertMany(sc, transferDocs); err != nil {
+				return nil, fmt.Errorf("record energy transfers: %w", err)
+			}
+		}
+
+		snapshot := models.AllocationSnapshot{
+			GridID:      grid.ID,
+			PlanID:      ledgerID,
+			GridVersion: plan.GridVersion + 1,
+			TakenAt:     lastBalanced,
+			Allocations: plan.Allocations,
+		}
+		if _, err := h.db.Collection("allocation_snapshots").InsertOne(sc, snapshot); err != nil {
+			return nil, fmt.Errorf("record allocation snapshot: %w", err)
+		}
+
+		if len(plan.Curtailment) > 0 {
+			curtailmentDocs := make([]interface{}, 0, len(plan.Curtailment))
+			for _, ct := range plan.Curtailment {
+				ct.GridID = grid.ID
+				ct.PlanID = ledgerID
+				ct.RecordedAt = lastBalanced
+				curtailmentDocs = append(curtailmentDocs, ct)
+			}
+			if _, err := h.db.Collection("generation_curtailments").InsertMany(sc, curtailmentDocs); err != nil {
+				return nil, fmt.Errorf("record curtailment: %w", err)
+			}
+		}
+
+		if len(socRecords) > 0 {
+			if _, err := h.db.Collection("storage_soc_history").InsertMany(sc, socRecords); err != nil {
+				return nil, fmt.Errorf("record storage state of charge: %w", err)
+			}
+		}
+
+		if plan.Shedding != nil {
+			schedule := *plan.Shedding
+			schedule.PlanID = ledgerID
+			schedule.CreatedAt = lastBalanced
+			if err := h.recordShedding(sc, &schedule); err != nil {
+				return nil, err
+			}
+		}
+
+		if !plan.ID.IsZero() {
+			res, err := h.db.Collection("balance_plans").UpdateOne(sc,
+				bson.M{"_id": plan.ID, "committed_at": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"committed_at": lastBalanced}},
+			)
+			if err != nil {
+				return nil, fmt.Errorf("mark plan committed: %w", err)
+			}
+			if res.MatchedCount == 0 {
+				return nil, errPlanCommitted
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	grid.ChildNodes = childNodes
+	grid.Storage = storage
+	grid.CurrentLoad = currentLoad
+	grid.Interchange += plan.InterchangeDelta
+	grid.LastBalanced = lastBalanced
+	grid.Version = plan.GridVersion + 1
+	if !plan.ID.IsZero() {
+		plan.CommittedAt = &lastBalanced
+	}
+	return nil
+}
+
+// SimulateBalance computes what a rebalance would do and stores the result
+// as a plan that can be committed later. The grid itself is not touched.
+func (h *GridDistributionHandler) SimulateBalance(c *fiber.Ctx) error {
+	strategy, ok := balancingStrategy(c.Query("strategy", defaultBalancingStrategy))
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Unknown balancing strategy"})
+	}
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	shedding, ok := requestShedding(c, grid)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Unknown shedding policy"})
+	}
+
+	opts := balanceOptions{strategy: strategy, shedding: shedding, escalate: c.QueryBool("escalate", true)}
+	plan, err := h.planBalance(c.Context(), grid, opts)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to plan balancing"})
+	}
+	if principal, ok := middleware.GetPrincipal(c); ok {
+		plan.CreatedBy = principal.Username
+	}
+
+	res, err := h.db.Collection("balance_plans").InsertOne(c.Context(), plan)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not store balancing plan"})
+	}
+	plan.ID = res.InsertedID.(primitive.ObjectID)
+	return c.Status(fiber.StatusCreated).JSON(plan)
+}
+
+func (h *GridDistributionHandler) GetBalancePlan(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	plan, err := h.findPlan(c, grid)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Plan not found"})
+	}
+	return c.JSON(plan)
+}
+
+// CommitBalancePlan applies a stored plan. It fails with 409 when the plan
+// was already committed, has expired, or the grid changed since it was
+// computed.
+func (h *GridDistributionHandler) CommitBalancePlan(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	plan, err := h.findPlan(c, grid)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Plan not found"})
+	}
+
+	if plan.CommittedAt != nil {
+		return c.Status(409).JSON(fiber.Map{"error": "Plan already committed"})
+	}
+	if time.Now().After(plan.ExpiresAt) {
+		return c.Status(409).JSON(fiber.Map{"error": "Plan has expired"})
+	}
+	if plan.GridVersion != grid.Version {
+		return c.Status(409).JSON(fiber.Map{"error": "Grid changed since the plan was computed"})
+	}
+
+	before := bson.M{"child_nodes": grid.ChildNodes, "last_balanced": grid.LastBalanced}
+	audit := newAuditEvent(c, models.AuditGridBalance, "grid_network", grid.ID.Hex(), before,
+		bson.M{"transfers": plan.Transfers, "plan_id": plan.ID})
+	if err := h.applyPlan(c.Context(), grid, plan, models.SnapshotCauseBalance, &audit); err != nil {
+		return applyError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"grid": grid, "plan": plan})
+}
+
+// applyError maps an applyPlan error to a response.
+func applyError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errPlanCommitted) {
+		return c.Status(409).JSON(fiber.Map{"error": "Plan already committed"})
+	}
+	return editError(c, err, "Failed to apply plan")
+}
+
+// editError answers a failed versioned write to a grid: 409 when another
+// writer got there first, otherwise 500 with message.
+func editError(c *fiber.Ctx, err error, message string) error {
+	status, message := editErrorStatus(err, message)
+	return c.Status(status).JSON(fiber.Map{"error": message})
+}
+
+func editErrorStatus(err error, message string) (int, string) {
+	if errors.Is(err, errGridConflict) {
+		return 409, "Grid was modified concurrently, retry"
+	}
+	return 500, message
+}
+
+// gridVersionFilter matches a grid at the given version. Grids stored before
+// versioning have no version field and count as version 0.
+func gridVersionFilter(id primitive.ObjectID, version int64) bson.M {
+	if version == 0 {
+		return bson.M{"_id": id, "version": bson.M{"$in": bson.A{0, nil}}}
+	}
+	return bson.M{"_id": id, "version": version}
+}
+
+func (h *GridDistributionHandler) findPlan(c *fiber.Ctx, grid *models.GridNetwork) (*models.BalancePlan, error) {
+	planID, err := primitive.ObjectIDFromHex(c.Params("planId"))
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	var plan models.BalancePlan
+	err = h.db.Collection("balance_plans").FindOne(c.Context(), bson.M{"_id": planID, "grid_id": grid.ID}).Decode(&plan)
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testDatabase connects to MONGO_TEST_URI and returns a fresh database that
+// is dropped when the test ends. Transactions need a replica set, so tests
+// that write through applyPlan are skipped without one.
+func testDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; these tests need a MongoDB replica set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	database := client.Database("grid_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		database.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+	return database
+}
+
+func TestApplyPlanConcurrentCommits(t *testing.T) {
+	database := testDatabase(t)
+	ctx := context.Background()
+	h := NewGridDistributionHandler(database)
+
+	grid := models.GridNetwork{
+		ID:            primitive.NewObjectID(),
+		Name:          "race",
+		TotalCapacity: 100,
+		Version:       1,
+		ChildNodes: []models.ChildNode{
+			{ID: primitive.NewObjectID(), Name: "a", Capacity: 50, CurrentDemand: 30},
+			{ID: primitive.NewObjectID(), Name: "b", Capacity: 50, CurrentDemand: 20},
+		},
+	}
+	if _, err := database.Collection("grid_networks").InsertOne(ctx, grid); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := models.BalancePlan{GridID: grid.ID, GridVersion: grid.Version, CreatedAt: time.Now()}
+	for _, node := range grid.ChildNodes {
+		plan.Allocations = append(plan.Allocations, models.NodeAllocation{
+			NodeID: node.ID, Name: node.Name, CurrentDemand: node.CurrentDemand,
+			Base: node.CurrentDemand, After: node.CurrentDemand,
+		})
+	}
+
+	errs := make([]error, 2)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// applyPlan updates the grid and plan it is given, so each
+			// caller works on its own copies, as concurrent requests do.
+			g, p := grid, plan
+			g.ChildNodes = append([]models.ChildNode(nil), grid.ChildNodes...)
+			<-start
+			errs[i] = h.applyPlan(ctx, &g, &p, models.SnapshotCauseBalance, nil)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	applied, conflicts := 0, 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			applied++
+		case errors.Is(err, errGridConflict):
+			conflicts++
+		default:
+			t.Fatalf("applyPlan: %v", err)
+		}
+	}
+	if applied != 1 || conflicts != 1 {
+		t.Fatalf("got %d applied and %d conflicts, want one of each", applied, conflicts)
+	}
+
+	var stored models.GridNetwork
+	if err := database.Collection("grid_networks").FindOne(ctx, bson.M{"_id": grid.ID}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Version != grid.Version+1 {
+		t.Errorf("grid version %d, want %d", stored.Version, grid.Version+1)
+	}
+	count, err := database.Collection("allocation_snapshots").CountDocuments(ctx, bson.M{"grid_id": grid.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("%d allocation snapshots recorded, want 1", count)
+	}
+}
+
+// balanceOnce settles a copy of grid with policy, the way planBalance does
+// without escalation, then writes the result back to grid and history the
+// way applyPlan does.
+func balanceOnce(t *testing.T, grid *models.GridNetwork, policy SheddingPolicy, history map[primitive.ObjectID]models.SheddingHistory, now time.Time) *gridBalance {
+	t.Helper()
+	work := *grid
+	work.ChildNodes = append([]models.ChildNode(nil), grid.ChildNodes...)
+	b := &gridBalance{
+		work:    &work,
+		opts:    balanceOptions{strategy: MinLossStrategy{}, shedding: policy},
+		history: history,
+		outputs: make([]float64, len(grid.ChildNodes)),
+	}
+	h := &GridDistributionHandler{}
+	if err := h.settle(b, now); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, a := range b.allocations(grid) {
+		grid.ChildNodes[i].AllocatedPower = a.After
+	}
+	if b.schedule != nil {
+		for _, e := range b.schedule.Entries {
+			if e.Shed <= violationTolerance {
+				continue
+			}
+			entry := history[e.NodeID]
+			entry.TotalShed += e.Shed
+			entry.Events++
+			entry.LastShedAt = now
+			history[e.NodeID] = entry
+		}
+	}
+	return b
+}
+
+func allocated(grid *models.GridNetwork) []float64 {
+	out := make([]float64, len(grid.ChildNodes))
+	for i, node := range grid.ChildNodes {
+		out[i] = node.AllocatedPower
+	}
+	return out
+}
+
+func closeTo(got, want []float64) bool {
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-6 {
+			return false
+		}
+	}
+	return len(got) == len(want)
+}
+
+func TestBalanceReshedsByPriority(t *testing.T) {
+	grid := &models.GridNetwork{
+		ID:            primitive.NewObjectID(),
+		TotalCapacity: 100,
+		ChildNodes: []models.ChildNode{
+			{ID: primitive.NewObjectID(), Name: "hospital", Capacity: 100, CurrentDemand: 40, PriorityClass: models.PriorityCritical},
+			{ID: primitive.NewObjectID(), Name: "residential", Capacity: 100, CurrentDemand: 60, PriorityClass: models.PriorityLow, Interruptible: true},
+		},
+	}
+	history := map[primitive.ObjectID]models.SheddingHistory{}
+	now := time.Now()
+
+	balanceOnce(t, grid, PriorityShedding{}, history, now)
+	if got := allocated(grid); !closeTo(got, []float64{40, 60}) {
+		t.Fatalf("first run allocated %v, want [40 60]", got)
+	}
+
+	// The hospital's demand rises past what the grid has left. The
+	// residential load must give way even though it already holds 60 MW.
+	grid.ChildNodes[0].CurrentDemand = 70
+	b := balanceOnce(t, grid, PriorityShedding{}, history, now.Add(time.Hour))
+	if got := allocated(grid); !closeTo(got, []float64{70, 30}) {
+		t.Errorf("second run allocated %v, want [70 30]", got)
+	}
+	if b.schedule == nil || math.Abs(b.schedule.TotalShed-30) > 1e-6 {
+		t.Errorf("schedule = %+v, want 30 MW shed", b.schedule)
+	}
+}
+
+func TestBalanceRotatesRollingShedding(t *testing.T) {
+	node := func(name string, allocated float64) models.ChildNode {
+		return models.ChildNode{ID: primitive.NewObjectID(), Name: name, Capacity: 50, CurrentDemand: 50, AllocatedPower: allocated}
+	}
+	grid := &models.GridNetwork{
+		ID:            primitive.NewObjectID(),
+		TotalCapacity: 100,
+		ChildNodes:    []models.ChildNode{node("a", 50), node("b", 50), node("c", 0)},
+	}
+	now := time.Now()
+	// c was blacked out by an earlier run.
+	history := map[primitive.ObjectID]models.SheddingHistory{
+		grid.ChildNodes[2].ID: {NodeID: grid.ChildNodes[2].ID, TotalShed: 50, Events: 1, LastShedAt: now.Add(-time.Hour)},
+	}
+
+	balanceOnce(t, grid, RollingShedding{}, history, now)
+	if got := allocated(grid); !closeTo(got, []float64{0, 50, 50}) {
+		t.Fatalf("first run allocated %v, want [0 50 50]", got)
+	}
+
+	balanceOnce(t, grid, RollingShedding{}, history, now.Add(time.Hour))
+	if got := allocated(grid); !closeTo(got, []float64{50, 0, 50}) {
+		t.Errorf("second run allocated %v, want [50 0 50]", got)
+	}
+}
+
+func TestAllocationsReconcileWithTransfers(t *testing.T) {
+	grid := underCapacityGrid()
+	b := balanceOnce(t, grid, ProportionalShedding{}, map[primitive.ObjectID]models.SheddingHistory{}, time.Now())
+	if len(b.transfers) == 0 {
+		t.Fatal("balance planned no transfers")
+	}
+
+	planID := primitive.NewObjectID()
+	snapshot := models.AllocationSnapshot{GridID: grid.ID, PlanID: planID, Allocations: b.allocations(grid)}
+	transfers := append([]models.EnergyTransfer(nil), b.transfers...)
+	for i := range transfers {
+		transfers[i].PlanID = planID
+	}
+
+	if d := reconcileLedger([]models.AllocationSnapshot{snapshot}, transfers); len(d) != 0 {
+		t.Errorf("balance does not reconcile with its own transfers: %+v", d)
+	}
+	// A transfer lost from the ledger shows up as a mismatch.
+	d := reconcileLedger([]models.AllocationSnapshot{snapshot}, transfers[1:])
+	if len(d) == 0 || d[0].Kind != models.DiscrepancyAllocationMismatch {
+		t.Errorf("missing transfer not reported: %+v", d)
+	}
+}
@@ -0,0 +1,313 @@
+package controllers
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultBalancingStrategy = "min_loss"
+
+// Power below this is treated as zero when planning transfers (MW).
+const minTransferAmount = 1e-6
+
+// TransferRoute describes how power travels from one child node to another.
+type TransferRoute struct {
+	Distance float64              // km
+	LossRate float64              // fraction of the sent power lost on the way
+	Lines    []primitive.ObjectID // lines crossed, empty for straight-line routes
+}
+
+// BalancingStrategy moves surplus allocation to nodes in deficit. It updates
+// AllocatedPower on grid.ChildNodes in place and returns the transfers that
+// produced the change. Power sent over a line counts against its thermal
+// rating. Strategies never persist anything.
+type BalancingStrategy interface {
+	Name() string
+	Balance(grid *models.GridNetwork, topology Topology) ([]models.EnergyTransfer, error)
+}
+
+var balancingStrategies = map[string]BalancingStrategy{
+	"greedy":   GreedyStrategy{},
+	"min_loss": MinLossStrategy{},
+}
+
+func balancingStrategy(name string) (BalancingStrategy, bool) {
+	s, ok := balancingStrategies[name]
+	return s, ok
+}
+
+// applyTransfer moves power from grid.ChildNodes[from] to grid.ChildNodes[to]
+// over route. sent is what the strategy planned at the route's planned loss
+// rate; the transfer is priced at the load it actually carries, so a route
+// that loses less needs less sent for the same delivery.
+func applyTransfer(grid *models.GridNetwork, topology Topology, from, to int, sent float64, route TransferRoute, strategy string) models.EnergyTransfer {
+	amount, rate := transferCost(topology, route, sent*(1-route.LossRate), sent)
+	grid.ChildNodes[from].AllocatedPower -= amount
+	grid.ChildNodes[to].AllocatedPower += amount * (1 - rate)
+	return models.EnergyTransfer{
+		FromNodeID:   grid.ChildNodes[from].ID,
+		ToNodeID:     grid.ChildNodes[to].ID,
+		Amount:       amount,
+		TransferTime: time.Now(),
+		LossEstimate: rate * 100,
+		Strategy:     strategy,
+		Lines:        route.Lines,
+		PriorityRule: transferRule(grid.ChildNodes[to]),
+	}
+}
+
+// transferCost is the power to send over route for delivered MW to arrive,
+// with losses priced at the power sent, and the loss rate that carries. It
+// never sends more than limit, delivering less when the route loses more at
+// that load than planned.
+func transferCost(topology Topology, route TransferRoute, delivered, limit float64) (float64, float64) {
+	amount := math.Min(delivered, limit)
+	for i := 0; i < 100; i++ {
+		next := limit
+		if rate := topology.TransferLoss(route, amount); rate < 1 {
+			next = math.Min(delivered/(1-rate), limit)
+		}
+		if next-amount <= simplexEpsilon {
+			break
+		}
+		amount = next
+	}
+	return amount, topology.TransferLoss(route, amount)
+}
+
+// priceSharedLines reprices transfers that share a line with others. A
+// line's loss depends on the total power it carries, so each transfer
+// crossing it loses that line's rate at the combined flow rather than at its
+// own amount. The extra loss comes out of what the recipient was credited.
+func priceSharedLines(grid *models.GridNetwork, topology Topology, transfers []models.EnergyTransfer) {
+	flows := map[primitive.ObjectID]float64{}
+	for _, t := range transfers {
+		for _, line := range t.Lines {
+			flows[line] += t.Amount
+		}
+	}
+	index := make(map[primitive.ObjectID]int, len(grid.ChildNodes))
+	for i, node := range grid.ChildNodes {
+		index[node.ID] = i
+	}
+	for i, t := range transfers {
+		if len(t.Lines) == 0 {
+			continue
+		}
+		rate := topology.FlowLoss(TransferRoute{Lines: t.Lines}, t.Amount, flows)
+		if extra := rate - t.LossEstimate/100; extra > 0 {
+			grid.ChildNodes[index[t.ToNodeID]].AllocatedPower -= t.Amount * extra
+			transfers[i].LossEstimate = rate * 100
+		}
+	}
+}
+
+// transferRule names the priority rule that ranked a transfer's recipient.
+func transferRule(node models.ChildNode) string {
+	switch {
+	case node.Interruptible:
+		return models.RuleInterruptibleFirst
+	case priorityClass(node) == models.PriorityCritical:
+		return models.RuleCriticalProtected
+	}
+	return models.RulePriorityClass + ":" + priorityClass(node)
+}
+
+// deficitWeight is how much one MW delivered to node is worth relative to a
+// normal firm load. Interruptible loads rank below every firm class.
+func deficitWeight(node models.ChildNode) float64 {
+	if node.Interruptible {
+		return 0.5
+	}
+	return math.Pow(2, float64(models.PriorityRanks[models.PriorityLow]-priorityRank(node)))
+}
+
+// totalTransferLoss is the power lost in transit across transfers, in MW.
+func totalTransferLoss(transfers []models.EnergyTransfer) float64 {
+	total := 0.0
+	for _, t := range transfers {
+		total += t.Amount * t.LossEstimate / 100
+	}
+	return total
+}
+
+// GreedyStrategy is the original balancing loop: largest surplus first,
+// nearest deficit first, one pair at a time, with deficits taken in priority
+// order. It ignores node capacity and is kept so operators can compare it
+// with MinLossStrategy.
+type GreedyStrategy struct{}
+
+func (GreedyStrategy) Name() string { return "greedy" }
+
+func (s GreedyStrategy) Balance(grid *models.GridNetwork, topology Topology) ([]models.EnergyTransfer, error) {
+	var transfers []models.EnergyTransfer
+	var excess, deficit []int
+
+	residual := map[primitive.ObjectID]float64{}
+	for id, rating := range topology.LineRatings() {
+		residual[id] = rating
+	}
+
+	nodes := grid.ChildNodes
+	for i, node := range nodes {
+		if node.AllocatedPower > node.CurrentDemand {
+			excess = append(excess, i)
+		} else if node.AllocatedPower < node.CurrentDemand {
+			deficit = append(deficit, i)
+		}
+	}
+
+	surplus := func(i int) float64 { return nodes[i].AllocatedPower - nodes[i].CurrentDemand }
+	sort.SliceStable(excess, func(a, b int) bool {
+		if surplus(excess[a]) != surplus(excess[b]) {
+			return surplus(excess[a]) > surplus(excess[b])
+		}
+		return nodes[excess[a]].Distance < nodes[excess[b]].Distance
+	})
+	sort.SliceStable(deficit, func(a, b int) bool {
+		na, nb := nodes[deficit[a]], nodes[deficit[b]]
+		if na.Interruptible != nb.Interruptible {
+			return nb.Interruptible
+		}
+		if priorityRank(na) != priorityRank(nb) {
+			return priorityRank(na) < priorityRank(nb)
+		}
+		return na.Distance < nb.Distance
+	})
+
+	for _, d := range deficit {
+		for _, e := range excess {
+			for _, r := range topology.Routes(nodes[e], nodes[d]) {
+				need := nodes[d].CurrentDemand - nodes[d].AllocatedPower
+				available := surplus(e)
+				if need <= 0 || available <= 0 {
+					break
+				}
+				if r.LossRate >= 1 {
+					continue
+				}
+
+				amount := math.Min(need/(1-r.LossRate), available)
+				for _, line := range r.Lines {
+					amount = math.Min(amount, residual[line])
+				}
+				if amount <= minTransferAmount {
+					continue
+				}
+				t := applyTransfer(grid, topology, e, d, amount, r, s.Name())
+				for _, line := range r.Lines {
+					residual[line] -= t.Amount
+				}
+				transfers = append(transfers, t)
+			}
+		}
+	}
+
+	return transfers, nil
+}
+
+// MinLossStrategy solves the transfers as a linear program over every
+// surplus/deficit pair and candidate route. Delivered power, weighted by the
+// recipient's priority class, is maximised first and total transit loss
+// minimised second. No node is pushed above its Capacity and no line above
+// its thermal rating.
+type MinLossStrategy struct{}
+
+// Weight of one delivered MW against one lost MW in the objective, before
+// the recipient's priority weight. It is large enough that the solver never
+// trades served demand for lower losses.
+const servedDemandWeight = 1000
+
+func (MinLossStrategy) Name() string { return "min_loss" }
+
+func (s MinLossStrategy) Balance(grid *models.GridNetwork, topology Topology) ([]models.EnergyTransfer, error) {
+	nodes := grid.ChildNodes
+
+	var sources, sinks []int
+	var supply, headroom []float64
+	for i, node := range nodes {
+		switch {
+		case node.AllocatedPower > node.CurrentDemand:
+			sources = append(sources, i)
+			supply = append(supply, node.AllocatedPower-node.CurrentDemand)
+		case node.AllocatedPower < node.CurrentDemand:
+			room := node.CurrentDemand - node.AllocatedPower
+			if node.Capacity > 0 {
+				room = math.Min(room, node.Capacity-node.AllocatedPower)
+			}
+			if room > minTransferAmount {
+				sinks = append(sinks, i)
+				headroom = append(headroom, room)
+			}
+		}
+	}
+	if len(sources) == 0 || len(sinks) == 0 {
+		return nil, nil
+	}
+
+	// One variable per source, sink and candidate route: MW sent that way.
+	type pair struct {
+		source, sink int
+		route        TransferRoute
+	}
+	var pairs []pair
+	for si, from := range sources {
+		for di, to := range sinks {
+			for _, r := range topology.Routes(nodes[from], nodes[to]) {
+				if r.LossRate < 1 {
+					pairs = append(pairs, pair{source: si, sink: di, route: r})
+				}
+			}
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	// Rows: one per source (supply), one per sink (headroom) and one per
+	// line some pair crosses (thermal rating).
+	ratings := topology.LineRatings()
+	lineRow := map[primitive.ObjectID]int{}
+	bounds := append(append([]float64(nil), supply...), headroom...)
+	for _, p := range pairs {
+		for _, line := range p.route.Lines {
+			if _, ok := lineRow[line]; !ok {
+				lineRow[line] = len(bounds)
+				bounds = append(bounds, ratings[line])
+			}
+		}
+	}
+
+	objective := make([]float64, len(pairs))
+	rows := make([][]float64, len(bounds))
+	for i := range rows {
+		rows[i] = make([]float64, len(pairs))
+	}
+	for k, p := range pairs {
+		delivered := 1 - p.route.LossRate
+		objective[k] = servedDemandWeight*deficitWeight(nodes[sinks[p.sink]])*delivered - p.route.LossRate
+		rows[p.source][k] = 1
+		rows[len(sources)+p.sink][k] = delivered
+		for _, line := range p.route.Lines {
+			rows[lineRow[line]][k] = 1
+		}
+	}
+
+	sent, err := maximizeLP(objective, rows, bounds)
+	if err != nil {
+		return nil, err
+	}
+
+	var transfers []models.EnergyTransfer
+	for k, p := range pairs {
+		if sent[k] <= minTransferAmount {
+			continue
+		}
+		transfers = append(transfers, applyTransfer(grid, topology, sources[p.source], sinks[p.sink], sent[k], p.route, s.Name()))
+	}
+	return transfers, nil
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// underCapacityGrid has demand well below capacity. The first node's demand
+// fell since the last balance and the second node's rose.
+func underCapacityGrid() *models.GridNetwork {
+	node := func(name string, lat, demand, allocated float64) models.ChildNode {
+		return models.ChildNode{
+			ID:             primitive.NewObjectID(),
+			Name:           name,
+			Location:       models.Location{Latitude: lat, Longitude: 77.2},
+			Capacity:       50,
+			CurrentDemand:  demand,
+			AllocatedPower: allocated,
+		}
+	}
+	return &models.GridNetwork{
+		ID:            primitive.NewObjectID(),
+		TotalCapacity: 100,
+		ChildNodes: []models.ChildNode{
+			node("falling", 28.60, 10, 30),
+			node("rising", 28.61, 40, 20),
+			node("steady", 28.62, 15, 15),
+		},
+	}
+}
+
+func TestMinLossTransfersUnderCapacity(t *testing.T) {
+	work := underCapacityGrid()
+	held, _ := startingAllocations(work, make([]float64, len(work.ChildNodes)))
+	if held != 65 {
+		t.Fatalf("held = %v, want 65", held)
+	}
+
+	h := &GridDistributionHandler{}
+	transfers, err := h.handleExcessDemand(work, MinLossStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) == 0 {
+		t.Fatal("min_loss planned no transfers on a grid under capacity")
+	}
+
+	falling, rising := work.ChildNodes[0], work.ChildNodes[1]
+	for _, tr := range transfers {
+		if tr.FromNodeID != falling.ID || tr.ToNodeID != rising.ID {
+			t.Errorf("transfer %s -> %s, want falling -> rising", tr.FromNodeID.Hex(), tr.ToNodeID.Hex())
+		}
+	}
+	if got := falling.AllocatedPower; math.Abs(got-falling.CurrentDemand) > 1e-6 {
+		t.Errorf("falling node keeps %v MW, want its demand %v", got, falling.CurrentDemand)
+	}
+	if got := rising.AllocatedPower; got <= 20 {
+		t.Errorf("rising node received nothing, allocated %v MW", got)
+	}
+}
+
+func TestStartingAllocationsResetsWhenSupplyShrank(t *testing.T) {
+	work := underCapacityGrid()
+	work.TotalCapacity = 40
+	held, _ := startingAllocations(work, make([]float64, len(work.ChildNodes)))
+	if held != 0 {
+		t.Fatalf("held = %v, want 0", held)
+	}
+	for _, node := range work.ChildNodes {
+		if node.AllocatedPower != 0 {
+			t.Errorf("%s starts with %v MW, want 0", node.Name, node.AllocatedPower)
+		}
+	}
+}
+
+func TestReleaseSurplus(t *testing.T) {
+	work := underCapacityGrid()
+	startingAllocations(work, make([]float64, len(work.ChildNodes)))
+	if released := releaseSurplus(work); released[0] != 20 || released[1] != 0 || released[2] != 0 {
+		t.Fatalf("released = %v, want [20 0 0]", released)
+	}
+	if got := work.ChildNodes[0].AllocatedPower; got != 10 {
+		t.Errorf("falling node keeps %v MW, want 10", got)
+	}
+}
+
+func TestMaximizeLP(t *testing.T) {
+	// maximize 3x + 2y with x + y <= 4, x + 3y <= 6: optimum at x = 4, y = 0.
+	x, err := maximizeLP([]float64{3, 2}, [][]float64{{1, 1}, {1, 3}}, []float64{4, 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(x[0]-4) > 1e-9 || math.Abs(x[1]) > 1e-9 {
+		t.Errorf("x = %v, want [4 0]", x)
+	}
+
+	if _, err := maximizeLP([]float64{1, 1}, [][]float64{{1, -1}}, []float64{1}); !errors.Is(err, errLPUnbounded) {
+		t.Errorf("unbounded program: err = %v, want errLPUnbounded", err)
+	}
+}
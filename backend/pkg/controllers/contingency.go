@@ -0,0 +1,458 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Grids with more elements than this are analysed in the background.
+const defaultContingencySyncLimit = 50
+
+// Only this many analyses run in the background at once per process; the
+// rest wait queued.
+var contingencySlots = make(chan struct{}, 2)
+
+// contingencyProgressEvery is how many contingencies a job works through
+// between progress updates.
+const contingencyProgressEvery = 10
+
+// A job's owner refreshes its heartbeat this often. A queued or running job
+// whose heartbeat is older than contingencyJobStale has lost its process.
+const (
+	contingencyHeartbeat = 30 * time.Second
+	contingencyJobStale  = 3 * contingencyHeartbeat
+)
+
+var errContingencyJobLost = errors.New("analysis stopped when its server went away; start it again")
+
+func contingencySyncLimit() int {
+	raw := os.Getenv("CONTINGENCY_SYNC_LIMIT")
+	if raw == "" {
+		return defaultContingencySyncLimit
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		log.Printf("Error parsing CONTINGENCY_SYNC_LIMIT=%q, using %d", raw, defaultContingencySyncLimit)
+		return defaultContingencySyncLimit
+	}
+	return n
+}
+
+// contingency is one element to trip.
+type contingency struct {
+	elementType string
+	id          primitive.ObjectID
+	name        string
+}
+
+// contingencies lists every node and in-service line of grid.
+func contingencies(grid *models.GridNetwork) []contingency {
+	var list []contingency
+	for _, node := range grid.ChildNodes {
+		list = append(list, contingency{elementType: models.ElementNode, id: node.ID, name: node.Name})
+	}
+	for _, line := range grid.Lines {
+		if line.InService {
+			list = append(list, contingency{elementType: models.ElementLine, id: line.ID})
+		}
+	}
+	return list
+}
+
+// tripped returns a copy of grid with the element out: a node is removed
+// with the lines ending at it and the storage connected there, a line is
+// taken out of service. Nodes the trip cuts off from the grid's supply node
+// are removed as well and returned; their local generation disconnects with
+// them, as anti-islanding protection requires.
+func tripped(grid *models.GridNetwork, ct contingency) (*models.GridNetwork, float64, []models.ChildNode) {
+	work := *grid
+	lostLoad := 0.0
+	removed := map[primitive.ObjectID]bool{}
+	switch ct.elementType {
+	case models.ElementNode:
+		removed[ct.id] = true
+		for _, node := range grid.ChildNodes {
+			if node.ID == ct.id {
+				lostLoad = node.CurrentDemand
+			}
+		}
+		work.Lines = withoutLines(grid.Lines, func(l models.GridLine) bool {
+			return l.FromNodeID == ct.id || l.ToNodeID == ct.id
+		})
+	case models.ElementLine:
+		work.Lines = append([]models.GridLine(nil), grid.Lines...)
+		for i := range work.Lines {
+			if work.Lines[i].ID == ct.id {
+				work.Lines[i].InService = false
+			}
+		}
+	}
+
+	var cutOff []models.ChildNode
+	if ct.elementType != models.ElementNone {
+		before := suppliedNodes(grid, grid.Lines)
+		after := suppliedNodes(grid, work.Lines)
+		for _, node := range grid.ChildNodes {
+			if before[node.ID] && !after[node.ID] && !removed[node.ID] {
+				removed[node.ID] = true
+				cutOff = append(cutOff, node)
+			}
+		}
+		work.Lines = withoutLines(work.Lines, func(l models.GridLine) bool {
+			return removed[l.FromNodeID] || removed[l.ToNodeID]
+		})
+	}
+
+	work.ChildNodes = nil
+	for _, node := range grid.ChildNodes {
+		if !removed[node.ID] {
+			work.ChildNodes = append(work.ChildNodes, node)
+		}
+	}
+	work.Storage = nil
+	for _, asset := range grid.Storage {
+		if !removed[asset.NodeID] {
+			work.Storage = append(work.Storage, asset)
+		}
+	}
+	return &work, lostLoad, cutOff
+}
+
+// supplyNode is where supply from the parent enters grid: the child node
+// nearest the parent by Distance, the first of them on a tie.
+func supplyNode(grid *models.GridNetwork) (primitive.ObjectID, bool) {
+	best := -1
+	for i, node := range grid.ChildNodes {
+		if best < 0 || node.Distance < grid.ChildNodes[best].Distance {
+			best = i
+		}
+	}
+	if best < 0 {
+		return primitive.NilObjectID, false
+	}
+	return grid.ChildNodes[best].ID, true
+}
+
+// suppliedNodes is the set of grid's nodes that in-service lines connect to
+// its supply node. The balancer delivers supply to every node directly, so
+// this only matters for nodes a trip disconnects. Grids without lines are
+// not modelled as a network and every node counts as supplied.
+func suppliedNodes(grid *models.GridNetwork, lines []models.GridLine) map[primitive.ObjectID]bool {
+	supplied := map[primitive.ObjectID]bool{}
+	source, ok := supplyNode(grid)
+	if len(grid.Lines) == 0 || !ok {
+		for _, node := range grid.ChildNodes {
+			supplied[node.ID] = true
+		}
+		return supplied
+	}
+
+	adjacency := map[primitive.ObjectID][]primitive.ObjectID{}
+	for _, line := range lines {
+		if line.InService {
+			adjacency[line.FromNodeID] = append(adjacency[line.FromNodeID], line.ToNodeID)
+			adjacency[line.ToNodeID] = append(adjacency[line.ToNodeID], line.FromNodeID)
+		}
+	}
+	// Nodes no line reaches at all are fed directly, as the balancer
+	// assumes.
+	for _, node := range grid.ChildNodes {
+		if !connected(grid.Lines, node.ID) {
+			supplied[node.ID] = true
+		}
+	}
+	queue := []primitive.ObjectID{source}
+	supplied[source] = true
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, next := range adjacency[id] {
+			if !supplied[next] {
+				supplied[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return supplied
+}
+
+func connected(lines []models.GridLine, nodeID primitive.ObjectID) bool {
+	for _, line := range lines {
+		if line.InService && (line.FromNodeID == nodeID || line.ToNodeID == nodeID) {
+			return true
+		}
+	}
+	return false
+}
+
+// analyseContingency reruns the balancing pipeline on grid with ct tripped.
+// Escalation to sibling grids is left out so each grid is judged on its
+// own.
+func (h *GridDistributionHandler) analyseContingency(ctx context.Context, grid *models.GridNetwork, ct contingency, strategy BalancingStrategy, shedding SheddingPolicy) (models.ContingencyResult, error) {
+	work, lostLoad, cutOff := tripped(grid, ct)
+	plan, err := h.planBalance(ctx, work, balanceOptions{strategy: strategy, shedding: shedding})
+	if err != nil {
+		return models.ContingencyResult{}, err
+	}
+
+	result := models.ContingencyResult{
+		ElementType: ct.elementType,
+		ElementID:   ct.id,
+		Name:        ct.name,
+		LostLoad:    lostLoad,
+		TotalLoss:   plan.TotalLoss,
+		Violations:  plan.Violations,
+	}
+	for _, node := range cutOff {
+		demand := servableDemand(node)
+		result.Unserved += demand
+		result.CutOffNodes = append(result.CutOffNodes, node.ID)
+		result.Violations = append(result.Violations, models.PlanViolation{
+			Kind: models.ViolationUnservedDemand, ElementID: node.ID, Amount: demand,
+			Detail: fmt.Sprintf("%s is cut off from supply", node.Name),
+		})
+	}
+	for _, v := range plan.Violations {
+		switch v.Kind {
+		case models.ViolationUnservedDemand:
+			result.Unserved += v.Amount
+		case models.ViolationNodeOverCapacity:
+			result.NodeOverload += v.Amount
+			result.OverloadedNodes = append(result.OverloadedNodes, v.ElementID)
+		case models.ViolationLineOverload:
+			result.LineOverload += v.Amount
+		}
+	}
+	for _, t := range plan.Transfers {
+		result.WorstLossRate = math.Max(result.WorstLossRate, t.LossEstimate)
+	}
+	result.Severity = result.Unserved + result.NodeOverload + result.LineOverload
+	return result, nil
+}
+
+// runContingencyAnalysis analyses the intact grid and every single trip.
+// progress, if set, is called with the number of contingencies done.
+func (h *GridDistributionHandler) runContingencyAnalysis(ctx context.Context, grid *models.GridNetwork, strategy BalancingStrategy, shedding SheddingPolicy, progress func(int)) (*models.ContingencyReport, error) {
+	base, err := h.analyseContingency(ctx, grid, contingency{elementType: models.ElementNone}, strategy, shedding)
+	if err != nil {
+		return nil, err
+	}
+	report := &models.ContingencyReport{
+		GridID:      grid.ID,
+		GridVersion: grid.Version,
+		Strategy:    strategy.Name(),
+		Shedding:    shedding.Name(),
+		Base:        base,
+		Results:     []models.ContingencyResult{},
+		Secure:      true,
+	}
+
+	for i, ct := range contingencies(grid) {
+		result, err := h.analyseContingency(ctx, grid, ct, strategy, shedding)
+		if err != nil {
+			return nil, err
+		}
+		report.Results = append(report.Results, result)
+		report.WorstLoss = math.Max(report.WorstLoss, result.TotalLoss)
+		if result.Severity > base.Severity+violationTolerance {
+			report.Secure = false
+		}
+		if progress != nil && (i+1)%contingencyProgressEvery == 0 {
+			progress(i + 1)
+		}
+	}
+
+	sort.SliceStable(report.Results, func(i, j int) bool {
+		a, b := report.Results[i], report.Results[j]
+		if a.Severity != b.Severity {
+			return a.Severity > b.Severity
+		}
+		if a.LostLoad != b.LostLoad {
+			return a.LostLoad > b.LostLoad
+		}
+		return a.TotalLoss > b.TotalLoss
+	})
+	return report, nil
+}
+
+// AnalyseContingencies runs an N-1 analysis of a grid. Small grids are
+// answered inline; larger ones get a job to poll, with 202 Accepted. A grid
+// has at most one unfinished job; asking again while it runs is a 409 that
+// returns that job.
+func (h *GridDistributionHandler) AnalyseContingencies(c *fiber.Ctx) error {
+	strategy, ok := balancingStrategy(c.Query("strategy", defaultBalancingStrategy))
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Unknown balancing strategy"})
+	}
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	shedding, ok := requestShedding(c, grid)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Unknown shedding policy"})
+	}
+
+	elements := len(contingencies(grid))
+	if elements <= contingencySyncLimit() && !c.QueryBool("async") {
+		report, err := h.runContingencyAnalysis(c.Context(), grid, strategy, shedding, nil)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Contingency analysis failed"})
+		}
+		return c.JSON(report)
+	}
+
+	now := time.Now()
+	job := models.ContingencyJob{
+		GridID:      grid.ID,
+		Enterprise:  grid.Enterprise,
+		Status:      models.JobQueued,
+		Active:      true,
+		Elements:    elements,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		HeartbeatAt: now,
+	}
+	if principal, ok := middleware.GetPrincipal(c); ok {
+		job.CreatedBy = principal.Username
+	}
+	// A job its server died with must not hold the grid's slot.
+	if _, err := failStaleContingencyJobs(c.Context(), h.db, bson.M{"grid_id": grid.ID}, now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not start contingency analysis"})
+	}
+	res, err := h.db.Collection("contingency_jobs").InsertOne(c.Context(), job)
+	if mongo.IsDuplicateKeyError(err) {
+		var running models.ContingencyJob
+		err := h.db.Collection("contingency_jobs").FindOne(c.Context(), bson.M{"grid_id": grid.ID, "active": true}).Decode(&running)
+		if err != nil {
+			return c.Status(409).JSON(fiber.Map{"error": "A contingency analysis is already running for this grid"})
+		}
+		return c.Status(409).JSON(fiber.Map{"error": "A contingency analysis is already running for this grid", "job": running})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not start contingency analysis"})
+	}
+	job.ID = res.InsertedID.(primitive.ObjectID)
+
+	go h.runContingencyJob(job.ID, grid, strategy, shedding)
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
+
+// runContingencyJob works through a queued job once a slot is free and
+// stores the report or the failure on it. It keeps the job's heartbeat
+// fresh until then, so a job left behind by a crash can be told apart from
+// one still waiting.
+func (h *GridDistributionHandler) runContingencyJob(jobID primitive.ObjectID, grid *models.GridNetwork, strategy BalancingStrategy, shedding SheddingPolicy) {
+	ctx := context.Background()
+	jobs := h.db.Collection("contingency_jobs")
+	// Only unfinished jobs are updated: one already failed as stale stays
+	// failed.
+	unfinished := bson.M{"_id": jobID, "status": bson.M{"$in": []string{models.JobQueued, models.JobRunning}}}
+	update := func(set bson.M) {
+		now := time.Now()
+		set["updated_at"] = now
+		set["heartbeat_at"] = now
+		if _, err := jobs.UpdateOne(ctx, unfinished, bson.M{"$set": set}); err != nil {
+			log.Printf("contingency job %s: %v", jobID.Hex(), err)
+		}
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(contingencyHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				if _, err := jobs.UpdateOne(ctx, unfinished, bson.M{"$set": bson.M{"heartbeat_at": now}}); err != nil {
+					log.Printf("contingency job %s: heartbeat: %v", jobID.Hex(), err)
+				}
+			}
+		}
+	}()
+
+	contingencySlots <- struct{}{}
+	defer func() { <-contingencySlots }()
+
+	update(bson.M{"status": models.JobRunning})
+	report, err := h.runContingencyAnalysis(ctx, grid, strategy, shedding, func(done int) {
+		update(bson.M{"completed": done})
+	})
+	finished := time.Now()
+	if err != nil {
+		log.Printf("contingency job %s: %v", jobID.Hex(), err)
+		update(bson.M{"status": models.JobFailed, "active": false, "error": err.Error(), "finished_at": finished})
+		return
+	}
+	update(bson.M{"status": models.JobDone, "active": false, "completed": len(report.Results), "report": report, "finished_at": finished})
+}
+
+// failStaleContingencyJobs marks the queued and running jobs matching filter
+// whose heartbeat is older than contingencyJobStale as failed. Jobs from
+// before heartbeats were recorded are judged by updated_at.
+func failStaleContingencyJobs(ctx context.Context, database *mongo.Database, filter bson.M, now time.Time) (int64, error) {
+	cutoff := now.Add(-contingencyJobStale)
+	stale := bson.M{
+		"status": bson.M{"$in": []string{models.JobQueued, models.JobRunning}},
+		"$or": []bson.M{
+			{"heartbeat_at": bson.M{"$lt": cutoff}},
+			{"heartbeat_at": bson.M{"$exists": false}, "updated_at": bson.M{"$lt": cutoff}},
+		},
+	}
+	for k, v := range filter {
+		stale[k] = v
+	}
+	res, err := database.Collection("contingency_jobs").UpdateMany(ctx, stale, bson.M{"$set": bson.M{
+		"status":      models.JobFailed,
+		"active":      false,
+		"error":       errContingencyJobLost.Error(),
+		"updated_at":  now,
+		"finished_at": now,
+	}})
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func (h *GridDistributionHandler) GetContingencyJob(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	jobID, err := primitive.ObjectIDFromHex(c.Params("jobId"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Job not found"})
+	}
+	// Another instance may have died with the job since startup.
+	if _, err := failStaleContingencyJobs(c.Context(), h.db, bson.M{"_id": jobID, "grid_id": grid.ID}, time.Now()); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not fetch job"})
+	}
+
+	var job models.ContingencyJob
+	err = h.db.Collection("contingency_jobs").FindOne(c.Context(), bson.M{"_id": jobID, "grid_id": grid.ID}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return c.Status(404).JSON(fiber.Map{"error": "Job not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not fetch job"})
+	}
+	return c.JSON(job)
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFailStaleContingencyJobs(t *testing.T) {
+	database := testDatabase(t)
+	ctx := context.Background()
+	now := time.Now()
+	old := now.Add(-2 * contingencyJobStale)
+
+	job := func(status string, heartbeat time.Time) models.ContingencyJob {
+		return models.ContingencyJob{ID: primitive.NewObjectID(), Status: status, CreatedAt: old, UpdatedAt: old, HeartbeatAt: heartbeat}
+	}
+	lost := job(models.JobRunning, old)
+	waiting := job(models.JobQueued, now.Add(-contingencyHeartbeat))
+	done := job(models.JobDone, old)
+	jobs := []interface{}{lost, waiting, done}
+	// A job written before heartbeats existed is judged by updated_at.
+	legacy := bson.M{"_id": primitive.NewObjectID(), "status": models.JobQueued, "updated_at": old}
+	jobs = append(jobs, legacy)
+	if _, err := database.Collection("contingency_jobs").InsertMany(ctx, jobs); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := failStaleContingencyJobs(ctx, database, bson.M{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("%d jobs failed, want 2", n)
+	}
+
+	want := map[primitive.ObjectID]string{
+		lost.ID:                            models.JobFailed,
+		waiting.ID:                         models.JobQueued,
+		done.ID:                            models.JobDone,
+		legacy["_id"].(primitive.ObjectID): models.JobFailed,
+	}
+	for id, status := range want {
+		var stored models.ContingencyJob
+		if err := database.Collection("contingency_jobs").FindOne(ctx, bson.M{"_id": id}).Decode(&stored); err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status != status {
+			t.Errorf("job %s is %s, want %s", id.Hex(), stored.Status, status)
+		}
+		if status == models.JobFailed && (stored.Error == "" || stored.FinishedAt == nil) {
+			t.Errorf("failed job %s has no error or finish time", id.Hex())
+		}
+	}
+}
+
+// feederGrid is a radial feeder s - a - b with a node c no line reaches.
+func feederGrid() *models.GridNetwork {
+	node := func(name string, distance float64) models.ChildNode {
+		return models.ChildNode{ID: primitive.NewObjectID(), Name: name, Distance: distance, Capacity: 50, CurrentDemand: 10}
+	}
+	s, a, b, c := node("s", 1), node("a", 2), node("b", 3), node("c", 4)
+	line := func(from, to models.ChildNode) models.GridLine {
+		return models.GridLine{ID: primitive.NewObjectID(), FromNodeID: from.ID, ToNodeID: to.ID, Length: 1, ThermalRating: 50, InService: true}
+	}
+	return &models.GridNetwork{
+		ID:            primitive.NewObjectID(),
+		TotalCapacity: 100,
+		ChildNodes:    []models.ChildNode{a, s, b, c},
+		Lines:         []models.GridLine{line(s, a), line(a, b)},
+		Storage:       []models.StorageAsset{{ID: primitive.NewObjectID(), NodeID: b.ID}},
+	}
+}
+
+func TestTrippedCutsOffDownstreamNodes(t *testing.T) {
+	grid := feederGrid()
+	a, s, b := grid.ChildNodes[0], grid.ChildNodes[1], grid.ChildNodes[2]
+	names := func(nodes []models.ChildNode) []string {
+		out := []string{}
+		for _, n := range nodes {
+			out = append(out, n.Name)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name      string
+		ct        contingency
+		lostLoad  float64
+		cutOff    []string
+		remaining int
+	}{
+		{name: "intact", ct: contingency{elementType: models.ElementNone}, cutOff: []string{}, remaining: 4},
+		{name: "last line", ct: contingency{elementType: models.ElementLine, id: grid.Lines[1].ID}, cutOff: []string{"b"}, remaining: 3},
+		{name: "first line", ct: contingency{elementType: models.ElementLine, id: grid.Lines[0].ID}, cutOff: []string{"a", "b"}, remaining: 2},
+		{name: "middle node", ct: contingency{elementType: models.ElementNode, id: a.ID}, lostLoad: 10, cutOff: []string{"b"}, remaining: 2},
+		{name: "supply node", ct: contingency{elementType: models.ElementNode, id: s.ID}, lostLoad: 10, cutOff: []string{"a", "b"}, remaining: 1},
+		{name: "end node", ct: contingency{elementType: models.ElementNode, id: b.ID}, lostLoad: 10, cutOff: []string{}, remaining: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			work, lostLoad, cutOff := tripped(grid, tt.ct)
+			if lostLoad != tt.lostLoad {
+				t.Errorf("lost load = %v, want %v", lostLoad, tt.lostLoad)
+			}
+			if got := names(cutOff); !reflect.DeepEqual(got, tt.cutOff) {
+				t.Errorf("cut off %v, want %v", got, tt.cutOff)
+			}
+			if len(work.ChildNodes) != tt.remaining {
+				t.Errorf("%d nodes remain, want %d", len(work.ChildNodes), tt.remaining)
+			}
+			// The storage sits at b and goes with it.
+			bOut := tt.ct.id == b.ID
+			for _, n := range cutOff {
+				bOut = bOut || n.ID == b.ID
+			}
+			if kept := len(work.Storage) == 1; kept == bOut {
+				t.Errorf("storage kept = %v with b out = %v", kept, bOut)
+			}
+		})
+	}
+}
+
+func TestTrippedWithoutLinesNeverCutsOff(t *testing.T) {
+	grid := feederGrid()
+	grid.Lines = nil
+	_, lostLoad, cutOff := tripped(grid, contingency{elementType: models.ElementNode, id: grid.ChildNodes[1].ID})
+	if lostLoad != 10 || len(cutOff) != 0 {
+		t.Errorf("lost load %v, cut off %d nodes, want 10 and none", lostLoad, len(cutOff))
+	}
+}
@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// generationLookback is how far back the peak that measured and forecast
+// output is scaled against is taken from.
+func generationLookback() time.Duration {
+	return envDuration("GENERATION_LOOKBACK", 7*24*time.Hour)
+}
+
+// generationOutput is the node's last known output, 0 without generation.
+func generationOutput(node models.ChildNode) float64 {
+	if node.Generation == nil {
+		return 0
+	}
+	return node.Generation.OutputMW
+}
+
+// netDemand is what node needs from the grid once output covers its own
+// demand, negative when it exports. Export is limited to the node's
+// capacity; the second result is the output that limit leaves unused.
+func netDemand(node models.ChildNode, output float64) (float64, float64) {
+	net := node.CurrentDemand - output
+	if node.Capacity > 0 && net < -node.Capacity {
+		return -node.Capacity, -node.Capacity - net
+	}
+	return net, 0
+}
+
+// generationOutputs works out the current output of every node of grid, in
+// node order. Nodes without generation produce nothing. When a source has
+// no recent data the node keeps its last known output.
+func (h *GridDistributionHandler) generationOutputs(ctx context.Context, grid *models.GridNetwork, now time.Time) ([]float64, error) {
+	outputs := make([]float64, len(grid.ChildNodes))
+	factors := map[string]float64{}
+	var zones map[string]bool
+
+	for i, node := range grid.ChildNodes {
+		gen := node.Generation
+		if gen == nil {
+			continue
+		}
+		outputs[i] = gen.OutputMW
+		if gen.Source == models.GenerationSourceManual {
+			continue
+		}
+
+		key := gen.Source + ":" + gen.Kind
+		if gen.Source == models.GenerationSourceForecast {
+			key = gen.Source + ":" + gen.ZoneID
+		}
+		factor, ok := factors[key]
+		if !ok {
+			var found bool
+			var err error
+			switch gen.Source {
+			case models.GenerationSourceMeasured:
+				factor, found, err = h.measuredFactor(ctx, gen.Kind, now)
+			case models.GenerationSourceForecast:
+				if zones == nil && grid.Enterprise != "" {
+					ids, err := tenantZoneIDs(ctx, h.db, grid.Enterprise)
+					if err != nil {
+						return nil, err
+					}
+					zones = map[string]bool{}
+					for _, id := range ids {
+						zones[id] = true
+					}
+				}
+				if zones == nil || zones[gen.ZoneID] {
+					factor, found, err = h.forecastFactor(ctx, gen.ZoneID, now)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				factor = -1
+			}
+			factors[key] = factor
+		}
+		if factor < 0 {
+			log.Printf("No usable %s data for node %s on grid %s, keeping its last output", gen.Source, node.ID.Hex(), grid.ID.Hex())
+			continue
+		}
+		outputs[i] = gen.CapacityMW * factor
+	}
+	return outputs, nil
+}
+
+// measuredFactor is the latest system-wide solar or wind generation as a
+// share of its peak over the lookback.
+func (h *GridDistributionHandler) measuredFactor(ctx context.Context, kind string, now time.Time) (float64, bool, error) {
+	field := "$solar_mw"
+	if kind == models.GenerationWind {
+		field = "$wind_mw"
+	}
+	match := bson.M{"timestamp": bson.M{"$gte": now.Add(-generationLookback()), "$lte": now}}
+	return h.peakFactor(ctx, "power_generation", match, field)
+}
+
+// forecastFactor is the renewable supply forecast for zone over the coming
+// balance interval as a share of its peak over the lookback.
+func (h *GridDistributionHandler) forecastFactor(ctx context.Context, zone string, now time.Time) (float64, bool, error) {
+	match := bson.M{
+		"zone_id":   zone,
+		"timestamp": bson.M{"$gte": now.Add(-generationLookback()), "$lte": now.Add(storageHorizon())},
+	}
+	renewable := bson.M{"$multiply": bson.A{"$forecasted_supply_kw", "$renewable_percentage"}}
+	return h.peakFactor(ctx, "power_forecasts", match, renewable)
+}
+
+// peakFactor divides the latest value of expr among the matching documents
+// by the largest one.
+func (h *GridDistributionHandler) peakFactor(ctx context.Context, collection string, match bson.M, expr interface{}) (float64, bool, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.M{"timestamp": -1}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "latest": bson.M{"$first": expr}, "peak": bson.M{"$max": expr}}}},
+	}
+	cursor, err := h.db.Collection(collection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, false, err
+	}
+	var rows []struct {
+		Latest float64 `bson:"latest"`
+		Peak   float64 `bson:"peak"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return 0, false, err
+	}
+	if len(rows) == 0 {
+		return 0, false, nil
+	}
+	if rows[0].Peak <= 0 {
+		return 0, true, nil
+	}
+	return math.Min(math.Max(rows[0].Latest/rows[0].Peak, 0), 1), true, nil
+}
+
+// curtailExports records the export a balance could not use: export above a
+// node's capacity, and export left on its node after transfers. stored MW of
+// the latter went into storage and is shared out by each node's leftover.
+func curtailExports(grid, work *models.GridNetwork, outputs, overLimit []float64, stored float64) []models.Curtailment {
+	leftover := make([]float64, len(work.ChildNodes))
+	totalLeftover := 0.0
+	for i, node := range work.ChildNodes {
+		if node.CurrentDemand < 0 {
+			leftover[i] = math.Max(node.AllocatedPower-node.CurrentDemand, 0)
+			totalLeftover += leftover[i]
+		}
+	}
+
+	var curtailment []models.Curtailment
+	for i, node := range grid.ChildNodes {
+		if node.Generation == nil {
+			continue
+		}
+		entry := models.Curtailment{
+			NodeID: node.ID,
+			Name:   node.Name,
+			Kind:   node.Generation.Kind,
+			Output: outputs[i],
+			Export: math.Max(outputs[i]-node.CurrentDemand, 0),
+		}
+		if overLimit[i] > minTransferAmount {
+			limited := entry
+			limited.Curtailed = overLimit[i]
+			limited.Reason = models.CurtailConnectionLimit
+			curtailment = append(curtailment, limited)
+		}
+		if leftover[i] > minTransferAmount {
+			entry.Stored = stored * leftover[i] / totalLeftover
+			entry.Curtailed = leftover[i] - entry.Stored
+			entry.Reason = models.CurtailNoAbsorption
+			curtailment = append(curtailment, entry)
+		}
+	}
+	return curtailment
+}
+
+// GetCurtailments returns a grid's recorded curtailment, newest first,
+// optionally for one node and within a time range, one page at a time.
+func (h *GridDistributionHandler) GetCurtailments(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	filter := bson.M{"grid_id": grid.ID}
+	if raw := c.Query("node_id"); raw != "" {
+		nodeID, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid node_id"})
+		}
+		filter["node_id"] = nodeID
+	}
+	from, to, msg := queryTimeRange(c)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	timeRangeFilter(filter, "recorded_at", from, to)
+	page, limit, msg := pageParams(c)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	opts := options.Find().SetSort(bson.M{"recorded_at": -1}).SetSkip((page - 1) * limit).SetLimit(limit)
+	cursor, err := h.db.Collection("generation_curtailments").Find(c.Context(), filter, opts)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not fetch curtailment"})
+	}
+	curtailment := []models.Curtailment{}
+	if err := cursor.All(c.Context(), &curtailment); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not fetch curtailment"})
+	}
+	return c.JSON(curtailment)
+}
+
+// validateGeneration rejects unknown kinds and sources, a non-positive
+// capacity and forecast output without a zone; nil means no generation.
+func validateGeneration(gen *models.NodeGeneration) string {
+	if gen == nil {
+		return ""
+	}
+	switch {
+	case gen.Kind != models.GenerationSolar && gen.Kind != models.GenerationWind:
+		return "generation.kind must be solar or wind"
+	case !finite(gen.CapacityMW) || gen.CapacityMW <= 0:
+		return "generation.capacity_mw must be positive"
+	case gen.Source != models.GenerationSourceMeasured && gen.Source != models.GenerationSourceForecast && gen.Source != models.GenerationSourceManual:
+		return "generation.source must be power_generation, forecast or manual"
+	case gen.Source == models.GenerationSourceForecast && gen.ZoneID == "":
+		return "generation.zone_id is required for forecast output"
+	case !finite(gen.OutputMW) || gen.OutputMW < 0 || gen.OutputMW > gen.CapacityMW:
+		return "generation.output_mw must be between 0 and capacity_mw"
+	}
+	return ""
+}
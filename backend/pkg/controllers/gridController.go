@@ -9,11 +9,17 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func GetGrids(c *fiber.Ctx) error {
+	filter, err := scopeByEnterprise(c, bson.M{})
+	if err != nil {
+		return tenantError(c, err)
+	}
+
 	var grids []models.Grid
-	cursor, err := db.GetCollection("grids").Find(context.TODO(), bson.M{})
+	cursor, err := db.GetCollection("grids").Find(context.TODO(), filter)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Error fetching grids"})
 	}
@@ -28,15 +34,29 @@ func UpdateGrid(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid input"})
 	}
 
-	_, err := db.GetCollection("grids").UpdateOne(context.TODO(),
-		bson.M{"_id": id},
-		bson.M{"$set": bson.M{
-			"consumption": updateData.Consumption,
-			"updated_at":  time.Now().Unix(),
-		}},
-	)
+	filter, err := scopeByEnterprise(c, bson.M{"_id": id})
+	if err != nil {
+		return tenantError(c, err)
+	}
+
+	changes := bson.M{
+		"consumption": updateData.Consumption,
+		"updated_at":  time.Now().Unix(),
+	}
+
+	var previous models.Grid
+	err = db.GetCollection("grids").FindOneAndUpdate(context.TODO(),
+		filter,
+		bson.M{"$set": changes},
+	).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		return c.Status(404).JSON(fiber.Map{"error": "Grid not found"})
+	}
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Could not update grid"})
 	}
+
+	recordAudit(c, models.AuditGridUpdate, "grid", id.Hex(),
+		bson.M{"consumption": previous.Consumption}, changes)
 	return c.JSON(fiber.Map{"message": "Grid updated successfully"})
 }
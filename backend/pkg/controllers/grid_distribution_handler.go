@@ -2,16 +2,18 @@ package controllers
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"log"
 	"math"
-	"sort"
 	"time"
 
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
 	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type GridDistributionHandler struct {
@@ -22,179 +24,282 @@ func NewGridDistributionHandler(db *mongo.Database) *GridDistributionHandler {
 	return &GridDistributionHandler{db: db}
 }
 
-func (h *GridDistributionHandler) handleExcessDemand(grid *models.GridNetwork) ([]models.EnergyTransfer, error) {
-	var transfers []models.EnergyTransfer
-	var nodesWithExcess, nodesWithDeficit []models.ChildNode
+// SetupGridDistribution creates the indexes the balancing endpoints rely on.
+func SetupGridDistribution(database *mongo.Database) {
+	// Expired plans stay around for a day so commits get a clear 409.
+	_, err := database.Collection("balance_plans").Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32((24 * time.Hour).Seconds())),
+	})
+	if err != nil {
+		log.Println("Could not create balance plan indexes:", err)
+	}
 
-	// 1. Classify nodes
-	for _, node := range grid.ChildNodes {
-		if node.AllocatedPower > node.CurrentDemand {
-			nodesWithExcess = append(nodesWithExcess, node)
-		} else if node.AllocatedPower < node.CurrentDemand {
-			nodesWithDeficit = append(nodesWithDeficit, node)
-		}
+	_, err = database.Collection("shedding_history").Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "grid_id", Value: 1}, {Key: "node_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("Could not create shedding history indexes:", err)
 	}
 
-	fmt.Println("Nodes with Excess:", len(nodesWithExcess))
-	fmt.Println("Nodes with Deficit:", len(nodesWithDeficit))
+	_, err = database.Collection("balance_runs").Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "grid_id", Value: 1}, {Key: "started_at", Value: -1}},
+	})
+	if err != nil {
+		log.Println("Could not create balance run indexes:", err)
+	}
 
-	// 2. Sort excess nodes by highest surplus, then by distance
-	sort.Slice(nodesWithExcess, func(i, j int) bool {
-		if nodesWithExcess[i].AllocatedPower-nodesWithExcess[i].CurrentDemand !=
-			nodesWithExcess[j].AllocatedPower-nodesWithExcess[j].CurrentDemand {
-			return (nodesWithExcess[i].AllocatedPower - nodesWithExcess[i].CurrentDemand) >
-				(nodesWithExcess[j].AllocatedPower - nodesWithExcess[j].CurrentDemand)
-		}
-		return nodesWithExcess[i].Distance < nodesWithExcess[j].Distance
+	_, err = database.Collection("grid_networks").Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "parent_node_id", Value: 1}}},
+		{Keys: bson.D{{Key: "child_nodes._id", Value: 1}}},
 	})
+	if err != nil {
+		log.Println("Could not create grid hierarchy indexes:", err)
+	}
 
-	sort.Slice(nodesWithDeficit, func(i, j int) bool {
-		return nodesWithDeficit[i].Distance < nodesWithDeficit[j].Distance
+	_, err = database.Collection("grid_interchanges").Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "to_grid_id", Value: 1}, {Key: "active", Value: 1}},
 	})
+	if err != nil {
+		log.Println("Could not create grid interchange indexes:", err)
+	}
 
-	// 3. Process Transfers
-	for dIdx, deficitNode := range nodesWithDeficit {
-		deficit := deficitNode.CurrentDemand - deficitNode.AllocatedPower
-
-		for eIdx := range nodesWithExcess {
-			if deficit <= 0 {
-				break
-			}
-
-			excess := nodesWithExcess[eIdx].AllocatedPower - nodesWithExcess[eIdx].CurrentDemand
-			if excess <= 0 {
-				continue
-			}
-
-			distance := calculateDistance(nodesWithExcess[eIdx].Location, deficitNode.Location)
-			lossRate := calculateLossRate(distance)
-			transferAmount := math.Min(deficit/(1-lossRate), excess)
-
-			if transferAmount <= 0 {
-				continue
-			}
-
-			transfer := models.EnergyTransfer{
-				FromNodeID:   nodesWithExcess[eIdx].ID,
-				ToNodeID:     deficitNode.ID,
-				Amount:       transferAmount,
-				TransferTime: time.Now(),
-				LossEstimate: lossRate * 100,
-			}
-			transfers = append(transfers, transfer)
-
-			fmt.Printf("Transfer -> From: %s, To: %s, Amount: %f\n",
-				transfer.FromNodeID.Hex(), transfer.ToNodeID.Hex(), transfer.Amount)
-
-			deficit -= transferAmount * (1 - lossRate)
-			nodesWithExcess[eIdx].AllocatedPower -= transferAmount
-
-			for j := range grid.ChildNodes {
-				if grid.ChildNodes[j].ID == nodesWithExcess[eIdx].ID {
-					grid.ChildNodes[j].AllocatedPower = nodesWithExcess[eIdx].AllocatedPower
-				}
-				if grid.ChildNodes[j].ID == deficitNode.ID {
-					grid.ChildNodes[j].AllocatedPower += transferAmount * (1 - lossRate)
-				}
-			}
-			nodesWithDeficit[dIdx].AllocatedPower += transferAmount * (1 - lossRate)
-		}
+	_, err = database.Collection("energy_transfers").Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "grid_id", Value: 1}, {Key: "transfer_time", Value: -1}}},
+		{Keys: bson.D{{Key: "plan_id", Value: 1}}},
+	})
+	if err != nil {
+		log.Println("Could not create energy transfer indexes:", err)
 	}
 
-	// 4. Persist Updated Grid to MongoDB
-	_, err := h.db.Collection("grid_networks").UpdateOne(
-		context.Background(),
-		bson.M{"_id": grid.ID},
-		bson.M{"$set": bson.M{"child_nodes": grid.ChildNodes}},
-	)
+	_, err = database.Collection("allocation_snapshots").Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "grid_id", Value: 1}, {Key: "taken_at", Value: 1}},
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to update grid: %v", err)
+		log.Println("Could not create allocation snapshot indexes:", err)
 	}
 
-	return transfers, nil
+	if err := migrateEnergyTransfers(context.TODO(), database); err != nil {
+		log.Println("Could not migrate energy transfers:", err)
+	}
+
+	_, err = database.Collection("grid_snapshots").Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "grid_id", Value: 1}, {Key: "version", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "grid_id", Value: 1}, {Key: "taken_at", Value: -1}}},
+	})
+	if err != nil {
+		log.Println("Could not create grid snapshot indexes:", err)
+	}
+	if err := seedGridSnapshots(context.TODO(), database); err != nil {
+		log.Println("Could not seed grid snapshots:", err)
+	}
+
+	// Finished analyses are kept for a week.
+	_, err = database.Collection("contingency_jobs").Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32((7 * 24 * time.Hour).Seconds())),
+	})
+	if err == nil {
+		_, err = database.Collection("contingency_jobs").Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+			Keys:    bson.D{{Key: "grid_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"active": true}),
+		})
+	}
+	if err != nil {
+		log.Println("Could not create contingency job indexes:", err)
+	}
+	// Jobs whose server went away would otherwise stay queued or running.
+	if n, err := failStaleContingencyJobs(context.TODO(), database, bson.M{}, time.Now()); err != nil {
+		log.Println("Could not expire stale contingency jobs:", err)
+	} else if n > 0 {
+		log.Printf("Marked %d stale contingency jobs failed", n)
+	}
+
+	_, err = database.Collection("storage_soc_history").Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "grid_id", Value: 1}, {Key: "storage_id", Value: 1}, {Key: "recorded_at", Value: -1}},
+	})
+	if err != nil {
+		log.Println("Could not create storage history indexes:", err)
+	}
+
+	_, err = database.Collection("generation_curtailments").Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "grid_id", Value: 1}, {Key: "recorded_at", Value: -1}},
+	})
+	if err != nil {
+		log.Println("Could not create curtailment indexes:", err)
+	}
 }
 
-func (h *GridDistributionHandler) BalanceGridEnergy(c *fiber.Ctx) error {
+var errInvalidGridID = errors.New("invalid grid ID")
+
+// findGrid loads the grid named by the gridId route parameter, within the
+// caller's tenant.
+func (h *GridDistributionHandler) findGrid(c *fiber.Ctx) (*models.GridNetwork, error) {
 	gridID, err := primitive.ObjectIDFromHex(c.Params("gridId"))
 	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid grid ID"})
+		return nil, errInvalidGridID
+	}
+	filter, err := scopeByEnterprise(c, bson.M{"_id": gridID})
+	if err != nil {
+		return nil, err
 	}
 
 	var grid models.GridNetwork
-	err = h.db.Collection("grid_networks").FindOne(context.Background(), bson.M{"_id": gridID}).Decode(&grid)
-	if err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Grid network not found"})
+	if err := h.db.Collection("grid_networks").FindOne(c.Context(), filter).Decode(&grid); err != nil {
+		return nil, err
 	}
+	return &grid, nil
+}
 
-	transfers, err := h.handleExcessDemand(&grid)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to handle excess demand"})
+// gridError maps a findGrid error to a response.
+func gridError(c *fiber.Ctx, err error) error {
+	status, message := gridErrorStatus(err)
+	return c.Status(status).JSON(fiber.Map{"error": message})
+}
+
+func gridErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, errInvalidGridID):
+		return 400, "Invalid grid ID"
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return 404, "Grid network not found"
+	case errors.Is(err, errNoTenant):
+		return fiber.StatusForbidden, "No enterprise assigned to this account"
 	}
+	return 500, "Could not load grid network"
+}
 
-	// Step 1: Calculate base distribution
-	err = h.calculateBaseDistribution(&grid)
+// handleExcessDemand moves surplus allocation to nodes in deficit using the
+// given strategy, routed over the grid's lines and priced with its loss
+// model. Nothing is persisted here.
+func (h *GridDistributionHandler) handleExcessDemand(grid *models.GridNetwork, strategy BalancingStrategy) ([]models.EnergyTransfer, error) {
+	model := gridLossModel(grid)
+	topology := gridTopology(grid, model)
+	transfers, err := strategy.Balance(grid, topology)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to calculate base distribution"})
+		return nil, err
+	}
+	priceSharedLines(grid, topology, transfers)
+	for i := range transfers {
+		transfers[i].LossModel = model.Name()
+		transfers[i].LossParams = model.Params()
 	}
+	return transfers, nil
+}
 
-	// Step 2: Handle excess demand and get new transfers
+// BalanceGridEnergy plans and applies a rebalance in one step. Use
+// SimulateBalance and CommitBalancePlan to review the plan first.
+func (h *GridDistributionHandler) BalanceGridEnergy(c *fiber.Ctx) error {
+	strategy, ok := balancingStrategy(c.Query("strategy", defaultBalancingStrategy))
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Unknown balancing strategy"})
+	}
 
-	// Step 3: Store transfers in `energy_transfers`
-	if len(transfers) > 0 {
-		var transferDocs []interface{}
-		for _, t := range transfers {
-			transferDocs = append(transferDocs, bson.M{
-				"from":          t.FromNodeID,
-				"to":            t.ToNodeID,
-				"amount":        t.Amount,
-				"transfer_time": t.TransferTime,
-				"loss_estimate": t.LossEstimate,
-			})
-		}
-		_, err = h.db.Collection("energy_transfers").InsertMany(context.Background(), transferDocs)
-		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": "Failed to record energy transfers"})
-		}
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	shedding, ok := requestShedding(c, grid)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Unknown shedding policy"})
 	}
 
-	// Step 4: Update grid balancing time
-	grid.LastBalanced = time.Now()
-	_, err = h.db.Collection("grid_networks").UpdateOne(
-		context.Background(),
-		bson.M{"_id": gridID},
-		bson.M{"$set": bson.M{"child_nodes": grid.ChildNodes, "last_balanced": grid.LastBalanced}},
-	)
+	opts := balanceOptions{strategy: strategy, shedding: shedding, escalate: c.QueryBool("escalate", true)}
+	plan, err := h.planBalance(c.Context(), grid, opts)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to update grid network"})
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to plan balancing"})
+	}
+
+	if principal, ok := middleware.GetPrincipal(c); ok {
+		plan.CreatedBy = principal.Username
+	}
+	before := bson.M{"child_nodes": grid.ChildNodes, "last_balanced": grid.LastBalanced}
+	audit := newAuditEvent(c, models.AuditGridBalance, "grid_network", grid.ID.Hex(), before,
+		bson.M{"transfers": plan.Transfers})
+	if err := h.applyPlan(c.Context(), grid, plan, models.SnapshotCauseBalance, &audit); err != nil {
+		return applyError(c, err)
 	}
 
-	// Step 5: Return updated grid (but fetch transfers from `energy_transfers`)
 	return c.JSON(fiber.Map{
-		"grid":      grid,
-		"transfers": transfers, // Transfers are still returned, but they are stored in energy_transfers
+		"grid":         grid,
+		"transfers":    plan.Transfers, // Transfers are still returned, but they are stored in energy_transfers
+		"strategy":     plan.Strategy,
+		"total_loss":   plan.TotalLoss,
+		"violations":   plan.Violations,
+		"shedding":     plan.Shedding,
+		"interchanges": plan.Interchanges,
+		"curtailment":  plan.Curtailment,
 	})
 }
 
-func (h *GridDistributionHandler) calculateBaseDistribution(grid *models.GridNetwork) error {
-    totalDemand := 0.0
-    for _, node := range grid.ChildNodes {
-        totalDemand += node.CurrentDemand
-    }
-
-    fmt.Printf("Total Demand: %f, Total Capacity: %f\n", totalDemand, grid.TotalCapacity)
+// calculateBaseDistribution allocates supply to the grid's nodes. When the
+// grid can carry every node's demand, capped at the node's own capacity,
+// each node gets exactly that. Otherwise load is shed by priority and the
+// shedding policy, and the returned schedule records what was cut.
+func (h *GridDistributionHandler) calculateBaseDistribution(grid *models.GridNetwork, supply float64, policy SheddingPolicy, history map[primitive.ObjectID]models.SheddingHistory) (*models.SheddingSchedule, error) {
+	demand := make([]float64, len(grid.ChildNodes))
+	totalDemand := 0.0
+	for i, node := range grid.ChildNodes {
+		demand[i] = servableDemand(node)
+		totalDemand += demand[i]
+	}
 
-    if totalDemand <= grid.TotalCapacity {
-        for i := range grid.ChildNodes {
-            grid.ChildNodes[i].AllocatedPower = grid.ChildNodes[i].CurrentDemand
-            fmt.Printf("Node: %s allocated exact demand: %f\n", grid.ChildNodes[i].ID.Hex(), grid.ChildNodes[i].AllocatedPower)
-			fmt.Printf("Node: %s allocated exact supply: %f\n", grid.ChildNodes[i].ID.Hex(), grid.ChildNodes[i].CurrentDemand)
-        }
-    }
+	if totalDemand <= supply {
+		for i := range grid.ChildNodes {
+			grid.ChildNodes[i].AllocatedPower = demand[i]
+		}
+		return nil, nil
+	}
 
-    return nil
+	served, rules, reasons := shedByPriority(grid.ChildNodes, demand, supply, policy, history)
+	schedule := &models.SheddingSchedule{
+		GridID:    grid.ID,
+		Policy:    policy.Name(),
+		Capacity:  supply,
+		Demand:    totalDemand,
+		CreatedAt: time.Now(),
+	}
+	for i, node := range grid.ChildNodes {
+		grid.ChildNodes[i].AllocatedPower = served[i]
+		schedule.TotalShed += demand[i] - served[i]
+		schedule.Entries = append(schedule.Entries, models.SheddingEntry{
+			NodeID:        node.ID,
+			Name:          node.Name,
+			PriorityClass: priorityClass(node),
+			Interruptible: node.Interruptible,
+			Demand:        demand[i],
+			Served:        served[i],
+			Shed:          demand[i] - served[i],
+			Rule:          rules[i],
+			Reason:        reasons[i],
+		})
+	}
+	return schedule, nil
 }
 
-
+// distributeSupply runs the base distribution over the demand left uncovered
+// once transfers were planned. routed is the grid's nodes at that point; each
+// node ends up with its share of supply on top of what it already held. The
+// shares are returned alongside the schedule.
+func (h *GridDistributionHandler) distributeSupply(grid *models.GridNetwork, routed []models.ChildNode, supply float64, policy SheddingPolicy, history map[primitive.ObjectID]models.SheddingHistory) (*models.SheddingSchedule, []float64, error) {
+	copy(grid.ChildNodes, routed)
+	for i, node := range routed {
+		if received := node.AllocatedPower; received > 0 {
+			grid.ChildNodes[i].CurrentDemand = math.Max(servableDemand(node)-received, 0)
+			grid.ChildNodes[i].Capacity = 0
+			grid.ChildNodes[i].MinGuaranteed = math.Max(node.MinGuaranteed-received, 0)
+		}
+	}
+	schedule, err := h.calculateBaseDistribution(grid, supply, policy, history)
+	shares := make([]float64, len(routed))
+	for i, node := range routed {
+		shares[i] = grid.ChildNodes[i].AllocatedPower
+		grid.ChildNodes[i] = node
+		grid.ChildNodes[i].AllocatedPower += shares[i]
+	}
+	return schedule, shares, err
+}
 
 func calculateDistance(loc1, loc2 models.Location) float64 {
 	const R = 6371
@@ -211,7 +316,3 @@ func calculateDistance(loc1, loc2 models.Location) float64 {
 
 	return R * c
 }
-
-func calculateLossRate(distance float64) float64 {
-	return math.Min(0.005*(distance/10), 0.5)
-}
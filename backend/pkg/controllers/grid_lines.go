@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (h *GridDistributionHandler) GetGridLines(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return respondGridError(c, err)
+	}
+	lines := grid.Lines
+	if lines == nil {
+		lines = []models.GridLine{}
+	}
+	return respond(c, 200, "Lines fetched successfully", lines)
+}
+
+func (h *GridDistributionHandler) CreateGridLine(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return respondGridError(c, err)
+	}
+
+	line, msg := parseGridLine(c, grid)
+	if msg != "" {
+		return respondFail(c, 400, msg)
+	}
+	line.ID = primitive.NewObjectID()
+
+	audit := newAuditEvent(c, models.AuditGridLineCreate, "grid_network", grid.ID.Hex(), nil, line)
+	err = h.editGrid(c, grid, audit, false, h.versionedUpdate(
+		gridVersionFilter(grid.ID, grid.Version),
+		bson.M{"$push": bson.M{"lines": line}, "$inc": bson.M{"version": 1}},
+	))
+	if err != nil {
+		return respondEditError(c, err, "Could not add line")
+	}
+
+	grid.Lines = append(grid.Lines, line)
+	grid.Version++
+
+	return respond(c, fiber.StatusCreated, "Line created successfully", line)
+}
+
+func (h *GridDistributionHandler) UpdateGridLine(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return respondGridError(c, err)
+	}
+	previous, ok := findGridLine(grid, c.Params("lineId"))
+	if !ok {
+		return respondFail(c, 404, "Line not found")
+	}
+
+	line, msg := parseGridLine(c, grid)
+	if msg != "" {
+		return respondFail(c, 400, msg)
+	}
+	line.ID = previous.ID
+
+	filter := gridVersionFilter(grid.ID, grid.Version)
+	filter["lines._id"] = line.ID
+	audit := newAuditEvent(c, models.AuditGridLineUpdate, "grid_network", grid.ID.Hex(), previous, line)
+	err = h.editGrid(c, grid, audit, false, h.versionedUpdate(
+		filter,
+		bson.M{"$set": bson.M{"lines.$": line}, "$inc": bson.M{"version": 1}},
+	))
+	if err != nil {
+		return respondEditError(c, err, "Could not update line")
+	}
+
+	for i := range grid.Lines {
+		if grid.Lines[i].ID == line.ID {
+			grid.Lines[i] = line
+		}
+	}
+	grid.Version++
+
+	return respond(c, 200, "Line updated successfully", line)
+}
+
+func (h *GridDistributionHandler) DeleteGridLine(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return respondGridError(c, err)
+	}
+	previous, ok := findGridLine(grid, c.Params("lineId"))
+	if !ok {
+		return respondFail(c, 404, "Line not found")
+	}
+
+	audit := newAuditEvent(c, models.AuditGridLineDelete, "grid_network", grid.ID.Hex(), previous, nil)
+	err = h.editGrid(c, grid, audit, false, h.versionedUpdate(
+		gridVersionFilter(grid.ID, grid.Version),
+		bson.M{"$pull": bson.M{"lines": bson.M{"_id": previous.ID}}, "$inc": bson.M{"version": 1}},
+	))
+	if err != nil {
+		return respondEditError(c, err, "Could not delete line")
+	}
+
+	grid.Lines = withoutLines(grid.Lines, func(l models.GridLine) bool { return l.ID == previous.ID })
+	grid.Version++
+
+	return respond(c, 200, "Line deleted successfully", nil)
+}
+
+// parseGridLine reads a line body and checks that both ends are distinct
+// nodes of grid and that the electrical ratings make sense. A non-empty
+// string is the reason the line was rejected.
+func parseGridLine(c *fiber.Ctx, grid *models.GridNetwork) (models.GridLine, string) {
+	var req models.GridLineRequest
+	if err := c.BodyParser(&req); err != nil {
+		return models.GridLine{}, "Invalid input"
+	}
+
+	line := models.GridLine{
+		FromNodeID:    req.FromNodeID,
+		ToNodeID:      req.ToNodeID,
+		Length:        req.Length,
+		Resistance:    req.Resistance,
+		VoltageKV:     req.VoltageKV,
+		ThermalRating: req.ThermalRating,
+		InService:     req.InService == nil || *req.InService,
+	}
+
+	switch {
+	case line.FromNodeID == line.ToNodeID:
+		return line, "A line must connect two different nodes"
+	case !hasChildNode(grid, line.FromNodeID) || !hasChildNode(grid, line.ToNodeID):
+		return line, "Both ends of a line must be nodes of this grid"
+	case line.Length < 0 || line.Resistance < 0:
+		return line, "Length and resistance must not be negative"
+	case line.VoltageKV <= 0:
+		return line, "voltage_kv must be positive"
+	case line.ThermalRating <= 0:
+		return line, "thermal_rating must be positive"
+	}
+	return line, ""
+}
+
+func findGridLine(grid *models.GridNetwork, hexID string) (models.GridLine, bool) {
+	id, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		return models.GridLine{}, false
+	}
+	for _, line := range grid.Lines {
+		if line.ID == id {
+			return line, true
+		}
+	}
+	return models.GridLine{}, false
+}
+
+func hasChildNode(grid *models.GridNetwork, id primitive.ObjectID) bool {
+	for _, node := range grid.ChildNodes {
+		if node.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutLines returns the lines drop does not match.
+func withoutLines(lines []models.GridLine, drop func(models.GridLine) bool) []models.GridLine {
+	kept := []models.GridLine{}
+	for _, line := range lines {
+		if !drop(line) {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
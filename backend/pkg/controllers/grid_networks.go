@@ -0,0 +1,559 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+	maxNameLength    = 100
+)
+
+// The grid and node management endpoints answer in the models.Response
+// envelope, errors included.
+
+func respond(c *fiber.Ctx, status int, message string, data interface{}) error {
+	return c.Status(status).JSON(&models.Response{Status: "success", Message: message, Data: data})
+}
+
+func respondFail(c *fiber.Ctx, status int, message string) error {
+	return c.Status(status).JSON(&models.Response{Status: "fail", Message: message})
+}
+
+func respondGridError(c *fiber.Ctx, err error) error {
+	status, message := gridErrorStatus(err)
+	return respondFail(c, status, message)
+}
+
+func respondEditError(c *fiber.Ctx, err error, message string) error {
+	status, message := editErrorStatus(err, message)
+	return respondFail(c, status, message)
+}
+
+// pageParams reads the page (from 1) and limit query parameters.
+func pageParams(c *fiber.Ctx) (page, limit int64, msg string) {
+	page, err := strconv.ParseInt(c.Query("page", "1"), 10, 64)
+	if err != nil || page < 1 {
+		return 0, 0, "page must be a positive integer"
+	}
+	limit, err = strconv.ParseInt(c.Query("limit", strconv.Itoa(defaultPageLimit)), 10, 64)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		return 0, 0, "limit must be between 1 and 100"
+	}
+	return page, limit, ""
+}
+
+// ListGridNetworks pages through the caller's grid networks. They can be
+// filtered by tier, parent_node_id, shedding_policy and a case-insensitive
+// name fragment.
+func (h *GridDistributionHandler) ListGridNetworks(c *fiber.Ctx) error {
+	page, limit, msg := pageParams(c)
+	if msg != "" {
+		return respondFail(c, 400, msg)
+	}
+
+	filter := bson.M{}
+	if tier := c.Query("tier"); tier != "" {
+		filter["tier"] = tier
+	}
+	if policy := c.Query("shedding_policy"); policy != "" {
+		filter["shedding_policy"] = policy
+	}
+	if parent := c.Query("parent_node_id"); parent != "" {
+		id, err := primitive.ObjectIDFromHex(parent)
+		if err != nil {
+			return respondFail(c, 400, "Invalid parent_node_id")
+		}
+		filter["parent_node_id"] = id
+	}
+	if name := c.Query("name"); name != "" {
+		filter["name"] = bson.M{"$regex": regexp.QuoteMeta(name), "$options": "i"}
+	}
+	filter, err := scopeByEnterprise(c, filter)
+	if err != nil {
+		return respondGridError(c, err)
+	}
+
+	collection := h.db.Collection("grid_networks")
+	total, err := collection.CountDocuments(c.Context(), filter)
+	if err != nil {
+		return respondFail(c, 500, "Could not list grid networks")
+	}
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetSkip((page - 1) * limit).SetLimit(limit)
+	cursor, err := collection.Find(c.Context(), filter, opts)
+	if err != nil {
+		return respondFail(c, 500, "Could not list grid networks")
+	}
+	grids := []models.GridNetwork{}
+	if err := cursor.All(c.Context(), &grids); err != nil {
+		return respondFail(c, 500, "Could not list grid networks")
+	}
+
+	return respond(c, 200, "Grid networks fetched successfully", models.Page{Items: grids, Total: total, Page: page, Limit: limit})
+}
+
+func (h *GridDistributionHandler) GetGridNetwork(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return respondGridError(c, err)
+	}
+	return respond(c, 200, "Grid network fetched successfully", grid)
+}
+
+func (h *GridDistributionHandler) CreateGridNetwork(c *fiber.Ctx) error {
+	var req models.GridNetworkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return respondFail(c, 400, "Invalid input")
+	}
+
+	enterprise, crossTenant, err := callerTenant(c)
+	if err != nil {
+		return respondGridError(c, err)
+	}
+	if crossTenant {
+		enterprise = req.Enterprise
+	}
+
+	grid := models.GridNetwork{
+		Name:           strings.TrimSpace(req.Name),
+		ParentNodeID:   req.ParentNodeID,
+		Tier:           req.Tier,
+		TotalCapacity:  req.TotalCapacity,
+		SheddingPolicy: req.SheddingPolicy,
+		LossModel:      req.LossModel,
+		Enterprise:     enterprise,
+		ChildNodes:     []models.ChildNode{},
+	}
+	for _, nodeReq := range req.ChildNodes {
+		node := childNodeFromRequest(nodeReq)
+		node.ID = primitive.NewObjectID()
+		if msg := validateChildNode(&grid, node, -1); msg != "" {
+			return respondFail(c, 400, msg)
+		}
+		grid.ChildNodes = append(grid.ChildNodes, node)
+	}
+	msg, err := h.validateGridNetwork(c.Context(), &grid)
+	if err != nil {
+		return respondFail(c, 500, "Could not validate grid network")
+	}
+	if msg != "" {
+		return respondFail(c, 400, msg)
+	}
+
+	grid.ID = primitive.NewObjectID()
+	audit := newAuditEvent(c, models.AuditGridNetworkCreate, "grid_network", grid.ID.Hex(), nil, grid)
+	err = h.editGrid(c, &grid, audit, false, func(sc mongo.SessionContext) error {
+		_, err := h.db.Collection("grid_networks").InsertOne(sc, grid)
+		return err
+	})
+	if err != nil {
+		return respondFail(c, 500, "Could not create grid network")
+	}
+
+	return respond(c, fiber.StatusCreated, "Grid network created successfully", grid)
+}
+
+// UpdateGridNetwork edits a grid's own settings. Its nodes and lines are
+// left alone.
+func (h *GridDistributionHandler) UpdateGridNetwork(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return respondGridError(c, err)
+	}
+	var req models.GridNetworkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return respondFail(c, 400, "Invalid input")
+	}
+
+	previous := bson.M{
+		"name": grid.Name, "parent_node_id": grid.ParentNodeID, "tier": grid.Tier,
+		"total_capacity": grid.TotalCapacity, "shedding_policy": grid.SheddingPolicy, "loss_model": grid.LossModel,
+	}
+	updated := *grid
+	updated.Name = strings.TrimSpace(req.Name)
+	updated.ParentNodeID = req.ParentNodeID
+	updated.Tier = req.Tier
+	updated.TotalCapacity = req.TotalCapacity
+	updated.SheddingPolicy = req.SheddingPolicy
+	updated.LossModel = req.LossModel
+	msg, err := h.validateGridNetwork(c.Context(), &updated)
+	if err != nil {
+		return respondFail(c, 500, "Could not validate grid network")
+	}
+	if msg != "" {
+		return respondFail(c, 400, msg)
+	}
+
+	changes := bson.M{
+		"name": updated.Name, "parent_node_id": updated.ParentNodeID, "tier": updated.Tier,
+		"total_capacity": updated.TotalCapacity, "shedding_policy": updated.SheddingPolicy, "loss_model": updated.LossModel,
+	}
+	audit := newAuditEvent(c, models.AuditGridNetworkUpdate, "grid_network", grid.ID.Hex(), previous, changes)
+	err = h.editGrid(c, grid, audit, false, h.versionedUpdate(
+		gridVersionFilter(grid.ID, grid.Version),
+		bson.M{"$set": changes, "$inc": bson.M{"version": 1}},
+	))
+	if err != nil {
+		return respondEditError(c, err, "Could not update grid network")
+	}
+	updated.Version++
+
+	return respond(c, 200, "Grid network updated successfully", updated)
+}
+
+// DeleteGridNetwork removes a grid and its balancing schedule. Grids that
+// still have grids behind their nodes, or that exchange power with siblings,
+// cannot be deleted.
+func (h *GridDistributionHandler) DeleteGridNetwork(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return respondGridError(c, err)
+	}
+
+	children, err := h.childGrids(c.Context(), grid)
+	if err != nil {
+		return respondFail(c, 500, "Could not delete grid network")
+	}
+	if len(children) > 0 {
+		return respondFail(c, 409, "Grid network has grids behind its nodes")
+	}
+	active, err := h.db.Collection("grid_interchanges").CountDocuments(c.Context(), bson.M{
+		"active": true,
+		"$or":    bson.A{bson.M{"from_grid_id": grid.ID}, bson.M{"to_grid_id": grid.ID}},
+	})
+	if err != nil {
+		return respondFail(c, 500, "Could not delete grid network")
+	}
+	if active > 0 {
+		return respondFail(c, 409, "Grid network has active interchanges with sibling grids")
+	}
+
+	audit := newAuditEvent(c, models.AuditGridNetworkDelete, "grid_network", grid.ID.Hex(), grid, nil)
+	err = h.editGrid(c, grid, audit, true, func(sc mongo.SessionContext) error {
+		res, err := h.db.Collection("grid_networks").DeleteOne(sc, gridVersionFilter(grid.ID, grid.Version))
+		if err != nil {
+			return err
+		}
+		if res.DeletedCount == 0 {
+			return errGridConflict
+		}
+		_, err = h.db.Collection("balance_schedules").DeleteOne(sc, bson.M{"_id": grid.ID})
+		return err
+	})
+	if err != nil {
+		return respondEditError(c, err, "Could not delete grid network")
+	}
+
+	return respond(c, 200, "Grid network deleted successfully", nil)
+}
+
+// validateGridNetwork checks a grid's own settings and returns a message
+// describing the first problem found. The parent node must belong to
+// another grid of the same enterprise that is not below this one.
+func (h *GridDistributionHandler) validateGridNetwork(ctx context.Context, grid *models.GridNetwork) (string, error) {
+	switch {
+	case grid.Name == "":
+		return "name is required", nil
+	case len(grid.Name) > maxNameLength:
+		return "name must be at most 100 characters", nil
+	case math.IsNaN(grid.TotalCapacity) || math.IsInf(grid.TotalCapacity, 0) || grid.TotalCapacity < 0:
+		return "total_capacity must be a non-negative number", nil
+	}
+	switch grid.Tier {
+	case "", models.TierRegional, models.TierSubstation, models.TierFeeder:
+	default:
+		return "tier must be one of regional, substation or feeder", nil
+	}
+	if _, ok := sheddingPolicy(grid.SheddingPolicy); !ok {
+		return "Unknown shedding policy", nil
+	}
+	if _, ok := lossModel(grid.LossModel); grid.LossModel != "" && !ok {
+		return "Unknown loss model", nil
+	}
+
+	if grid.ParentNodeID.IsZero() {
+		return "", nil
+	}
+	if hasChildNode(grid, grid.ParentNodeID) {
+		return "A grid cannot sit behind one of its own nodes", nil
+	}
+	parent, err := h.parentGrid(ctx, grid)
+	if err != nil {
+		return "", err
+	}
+	if parent == nil {
+		return "parent_node_id is not a node of any grid network", nil
+	}
+	for depth := 0; parent != nil && depth < maxGridDepth; depth++ {
+		if !grid.ID.IsZero() && parent.ID == grid.ID {
+			return "parent_node_id would make the grid its own ancestor", nil
+		}
+		if parent, err = h.parentGrid(ctx, parent); err != nil {
+			return "", err
+		}
+	}
+	if parent != nil {
+		return "Grid hierarchy is nested too deeply", nil
+	}
+	return "", nil
+}
+
+func (h *GridDistributionHandler) ListChildNodes(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return respondGridError(c, err)
+	}
+	page, limit, msg := pageParams(c)
+	if msg != "" {
+		return respondFail(c, 400, msg)
+	}
+	match, msg := childNodeFilter(c)
+	if msg != "" {
+		return respondFail(c, 400, msg)
+	}
+
+	nodes := []models.ChildNode{}
+	for _, node := range grid.ChildNodes {
+		if match(node) {
+			nodes = append(nodes, node)
+		}
+	}
+	total := int64(len(nodes))
+	start := min((page-1)*limit, total)
+	end := min(start+limit, total)
+
+	return respond(c, 200, "Nodes fetched successfully", models.Page{Items: nodes[start:end], Total: total, Page: page, Limit: limit})
+}
+
+// childNodeFilter builds a node predicate from the name (case-insensitive
+// fragment), priority_class and interruptible query parameters.
+func childNodeFilter(c *fiber.Ctx) (func(models.ChildNode) bool, string) {
+	name := strings.ToLower(c.Query("name"))
+	class := c.Query("priority_class")
+	var interruptible *bool
+	if v := c.Query("interruptible"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, "interruptible must be true or false"
+		}
+		interruptible = &b
+	}
+	return func(node models.ChildNode) bool {
+		if name != "" && !strings.Contains(strings.ToLower(node.Name), name) {
+			return false
+		}
+		if class != "" && priorityClass(node) != class {
+			return false
+		}
+		return interruptible == nil || node.Interruptible == *interruptible
+	}, ""
+}
+
+func (h *GridDistributionHandler) GetChildNode(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return respondGridError(c, err)
+	}
+	index, ok := findChildNode(grid, c.Params("nodeId"))
+	if !ok {
+		return respondFail(c, 404, "Node not found")
+	}
+	return respond(c, 200, "Node fetched successfully", grid.ChildNodes[index])
+}
+
+func (h *GridDistributionHandler) CreateChildNode(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return respondGridError(c, err)
+	}
+	var req models.ChildNodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return respondFail(c, 400, "Invalid input")
+	}
+	node := childNodeFromRequest(req)
+	node.ID = primitive.NewObjectID()
+	if msg := validateChildNode(grid, node, -1); msg != "" {
+		return respondFail(c, 400, msg)
+	}
+
+	audit := newAuditEvent(c, models.AuditNodeCreate, "grid_network", grid.ID.Hex(), nil, node)
+	err = h.editGrid(c, grid, audit, false, h.versionedUpdate(
+		gridVersionFilter(grid.ID, grid.Version),
+		bson.M{"$push": bson.M{"child_nodes": node}, "$inc": bson.M{"version": 1}},
+	))
+	if err != nil {
+		return respondEditError(c, err, "Could not add node")
+	}
+
+	grid.ChildNodes = append(grid.ChildNodes, node)
+	grid.Version++
+
+	return respond(c, fiber.StatusCreated, "Node created successfully", node)
+}
+
+// UpdateChildNode replaces a node's editable fields.
+func (h *GridDistributionHandler) UpdateChildNode(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return respondGridError(c, err)
+	}
+	index, ok := findChildNode(grid, c.Params("nodeId"))
+	if !ok {
+		return respondFail(c, 404, "Node not found")
+	}
+	var req models.ChildNodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return respondFail(c, 400, "Invalid input")
+	}
+	previous := grid.ChildNodes[index]
+	node := childNodeFromRequest(req)
+	node.ID = previous.ID
+	// Output from a data source is only refreshed by balancing.
+	if gen, was := node.Generation, previous.Generation; gen != nil && gen.Source != models.GenerationSourceManual && was != nil {
+		gen.OutputMW = math.Min(was.OutputMW, gen.CapacityMW)
+		gen.UpdatedAt = was.UpdatedAt
+	}
+	if msg := validateChildNode(grid, node, index); msg != "" {
+		return respondFail(c, 400, msg)
+	}
+
+	filter := gridVersionFilter(grid.ID, grid.Version)
+	filter["child_nodes._id"] = node.ID
+	audit := newAuditEvent(c, models.AuditNodeUpdate, "grid_network", grid.ID.Hex(), previous, node)
+	err = h.editGrid(c, grid, audit, false, h.versionedUpdate(
+		filter,
+		bson.M{"$set": bson.M{"child_nodes.$": node}, "$inc": bson.M{"version": 1}},
+	))
+	if err != nil {
+		return respondEditError(c, err, "Could not update node")
+	}
+
+	grid.ChildNodes[index] = node
+	grid.Version++
+
+	return respond(c, 200, "Node updated successfully", node)
+}
+
+// DeleteChildNode removes a node together with the lines ending at it. A
+// node with a grid behind it cannot be deleted.
+func (h *GridDistributionHandler) DeleteChildNode(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return respondGridError(c, err)
+	}
+	index, ok := findChildNode(grid, c.Params("nodeId"))
+	if !ok {
+		return respondFail(c, 404, "Node not found")
+	}
+	previous := grid.ChildNodes[index]
+
+	for _, asset := range grid.Storage {
+		if asset.NodeID == previous.ID {
+			return respondFail(c, 409, "Storage is connected at this node")
+		}
+	}
+
+	err = h.db.Collection("grid_networks").FindOne(c.Context(), bson.M{"parent_node_id": previous.ID}).Err()
+	if err == nil {
+		return respondFail(c, 409, "A grid network sits behind this node")
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return respondFail(c, 500, "Could not delete node")
+	}
+
+	audit := newAuditEvent(c, models.AuditNodeDelete, "grid_network", grid.ID.Hex(), previous, nil)
+	err = h.editGrid(c, grid, audit, false, h.versionedUpdate(
+		gridVersionFilter(grid.ID, grid.Version),
+		bson.M{
+			"$pull": bson.M{
+				"child_nodes": bson.M{"_id": previous.ID},
+				"lines":       bson.M{"$or": bson.A{bson.M{"from_node_id": previous.ID}, bson.M{"to_node_id": previous.ID}}},
+			},
+			"$inc": bson.M{"version": 1},
+		},
+	))
+	if err != nil {
+		return respondEditError(c, err, "Could not delete node")
+	}
+
+	grid.ChildNodes = append(grid.ChildNodes[:index:index], grid.ChildNodes[index+1:]...)
+	grid.Lines = withoutLines(grid.Lines, func(l models.GridLine) bool {
+		return l.FromNodeID == previous.ID || l.ToNodeID == previous.ID
+	})
+	grid.Version++
+
+	return respond(c, 200, "Node deleted successfully", nil)
+}
+
+func childNodeFromRequest(req models.ChildNodeRequest) models.ChildNode {
+	if req.Generation != nil {
+		req.Generation.UpdatedAt = time.Now()
+	}
+	return models.ChildNode{
+		Name:           strings.TrimSpace(req.Name),
+		Location:       req.Location,
+		Capacity:       req.Capacity,
+		CurrentDemand:  req.CurrentDemand,
+		AllocatedPower: req.AllocatedPower,
+		Distance:       req.Distance,
+		PriorityClass:  req.PriorityClass,
+		MinGuaranteed:  req.MinGuaranteed,
+		Interruptible:  req.Interruptible,
+		Generation:     req.Generation,
+	}
+}
+
+// validateChildNode checks node as it would be stored at index in grid (-1
+// for a new node): its own fields, then its generation and priority
+// settings. It returns the client-facing reason for rejecting it, if any.
+func validateChildNode(grid *models.GridNetwork, node models.ChildNode, index int) string {
+	switch {
+	case node.Name == "":
+		return "name is required"
+	case len(node.Name) > maxNameLength:
+		return "name must be at most 100 characters"
+	case !finite(node.Location.Latitude) || node.Location.Latitude < -90 || node.Location.Latitude > 90:
+		return "location.latitude must be between -90 and 90"
+	case !finite(node.Location.Longitude) || node.Location.Longitude < -180 || node.Location.Longitude > 180:
+		return "location.longitude must be between -180 and 180"
+	case !finite(node.Capacity) || node.Capacity < 0:
+		return "capacity must be a non-negative number"
+	case !finite(node.CurrentDemand) || node.CurrentDemand < 0:
+		return "current_demand must be a non-negative number"
+	case !finite(node.AllocatedPower) || node.AllocatedPower < 0 && node.Generation == nil:
+		return "allocated_power must be a non-negative number"
+	case node.Capacity > 0 && node.AllocatedPower < -node.Capacity:
+		return "allocated_power must not export more than capacity"
+	case node.Capacity > 0 && node.AllocatedPower > node.Capacity:
+		return "allocated_power must not exceed capacity"
+	case !finite(node.Distance) || node.Distance < 0:
+		return "distance must be a non-negative number"
+	}
+	for i, other := range grid.ChildNodes {
+		if i != index && strings.EqualFold(other.Name, node.Name) {
+			return "A node with this name already exists in the grid"
+		}
+	}
+	if msg := validateGeneration(node.Generation); msg != "" {
+		return msg
+	}
+	return validateNodePriority(node)
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
@@ -0,0 +1,25 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+)
+
+func TestValidateChildNodeCapacity(t *testing.T) {
+	grid := &models.GridNetwork{}
+	tests := []struct {
+		name string
+		node models.ChildNode
+		want string
+	}{
+		{name: "uncapped node", node: models.ChildNode{Name: "n", CurrentDemand: 40, AllocatedPower: 40}},
+		{name: "within capacity", node: models.ChildNode{Name: "n", Capacity: 50, AllocatedPower: 50}},
+		{name: "over capacity", node: models.ChildNode{Name: "n", Capacity: 50, AllocatedPower: 51}, want: "allocated_power must not exceed capacity"},
+	}
+	for _, tt := range tests {
+		if got := validateChildNode(grid, tt.node, -1); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UpdateNodePriority changes a child node's priority class, minimum
+// guaranteed supply and interruptibility.
+func (h *GridDistributionHandler) UpdateNodePriority(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return respondGridError(c, err)
+	}
+	index, ok := findChildNode(grid, c.Params("nodeId"))
+	if !ok {
+		return respondFail(c, 404, "Node not found")
+	}
+
+	var req models.NodePriority
+	if err := c.BodyParser(&req); err != nil {
+		return respondFail(c, 400, "Invalid input")
+	}
+	node := grid.ChildNodes[index]
+	previous := models.NodePriority{PriorityClass: node.PriorityClass, MinGuaranteed: node.MinGuaranteed, Interruptible: node.Interruptible}
+	node.PriorityClass = req.PriorityClass
+	node.MinGuaranteed = req.MinGuaranteed
+	node.Interruptible = req.Interruptible
+	if msg := validateNodePriority(node); msg != "" {
+		return respondFail(c, 400, msg)
+	}
+
+	filter := gridVersionFilter(grid.ID, grid.Version)
+	filter["child_nodes._id"] = node.ID
+	audit := newAuditEvent(c, models.AuditNodePriority, "grid_network", grid.ID.Hex(), previous, req)
+	err = h.editGrid(c, grid, audit, false, h.versionedUpdate(filter, bson.M{
+		"$set": bson.M{
+			"child_nodes.$.priority_class": node.PriorityClass,
+			"child_nodes.$.min_guaranteed": node.MinGuaranteed,
+			"child_nodes.$.interruptible":  node.Interruptible,
+		},
+		"$inc": bson.M{"version": 1},
+	}))
+	if err != nil {
+		return respondEditError(c, err, "Could not update node")
+	}
+
+	grid.ChildNodes[index] = node
+	grid.Version++
+
+	return respond(c, 200, "Node priority updated successfully", node)
+}
+
+// validateNodePriority reports why node's priority class, guarantee or
+// interruptible flag cannot be stored, or "" when they can.
+func validateNodePriority(node models.ChildNode) string {
+	if _, ok := models.PriorityRanks[node.PriorityClass]; node.PriorityClass != "" && !ok {
+		return "priority_class must be one of critical, high, normal or low"
+	}
+	if node.MinGuaranteed < 0 {
+		return "min_guaranteed must not be negative"
+	}
+	if node.Capacity > 0 && node.MinGuaranteed > node.Capacity {
+		return "min_guaranteed must not exceed the node's capacity"
+	}
+	if node.Interruptible && node.PriorityClass == models.PriorityCritical {
+		return "A critical load cannot be interruptible"
+	}
+	return ""
+}
+
+func findChildNode(grid *models.GridNetwork, hexID string) (int, bool) {
+	id, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		return 0, false
+	}
+	for i, node := range grid.ChildNodes {
+		if node.ID == id {
+			return i, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,392 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Guards tree walks against ParentNodeID cycles in bad data.
+const maxGridDepth = 8
+
+// sameEnterprise matches documents of the given tenant. Grids without an
+// enterprise have no field at all.
+func sameEnterprise(enterprise string) interface{} {
+	if enterprise == "" {
+		return bson.M{"$in": bson.A{"", nil}}
+	}
+	return enterprise
+}
+
+// parentGrid returns the grid holding grid's parent node, or nil at the top
+// of the hierarchy.
+func (h *GridDistributionHandler) parentGrid(ctx context.Context, grid *models.GridNetwork) (*models.GridNetwork, error) {
+	if grid.ParentNodeID.IsZero() {
+		return nil, nil
+	}
+	var parent models.GridNetwork
+	err := h.db.Collection("grid_networks").FindOne(ctx, bson.M{
+		"child_nodes._id": grid.ParentNodeID,
+		"enterprise":      sameEnterprise(grid.Enterprise),
+	}).Decode(&parent)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &parent, nil
+}
+
+// childGrids returns the grids sitting behind grid's nodes.
+func (h *GridDistributionHandler) childGrids(ctx context.Context, grid *models.GridNetwork) ([]models.GridNetwork, error) {
+	ids := make([]primitive.ObjectID, 0, len(grid.ChildNodes))
+	for _, node := range grid.ChildNodes {
+		ids = append(ids, node.ID)
+	}
+	cursor, err := h.db.Collection("grid_networks").Find(ctx, bson.M{
+		"parent_node_id": bson.M{"$in": ids},
+		"enterprise":     sameEnterprise(grid.Enterprise),
+	})
+	if err != nil {
+		return nil, err
+	}
+	children := []models.GridNetwork{}
+	if err := cursor.All(ctx, &children); err != nil {
+		return nil, err
+	}
+	return children, nil
+}
+
+// servableDemand is what a node can take: its demand, capped at its own
+// capacity.
+func servableDemand(node models.ChildNode) float64 {
+	demand := math.Max(node.CurrentDemand, 0)
+	if node.Capacity > 0 {
+		demand = math.Min(demand, node.Capacity)
+	}
+	return demand
+}
+
+// unservedDemand is the servable demand the grid's nodes still lack. What an
+// exporting node sends out is not demand.
+func unservedDemand(grid *models.GridNetwork) float64 {
+	total := 0.0
+	for _, node := range grid.ChildNodes {
+		total += math.Max(servableDemand(node)-math.Max(node.AllocatedPower, 0), 0)
+	}
+	return total
+}
+
+// activeImports returns the interchanges currently feeding grid.
+func (h *GridDistributionHandler) activeImports(ctx context.Context, gridID primitive.ObjectID) ([]models.GridInterchange, error) {
+	cursor, err := h.db.Collection("grid_interchanges").Find(ctx, bson.M{"to_grid_id": gridID, "active": true})
+	if err != nil {
+		return nil, err
+	}
+	var imports []models.GridInterchange
+	if err := cursor.All(ctx, &imports); err != nil {
+		return nil, err
+	}
+	return imports, nil
+}
+
+// releaseCredits gives the senders of released imports back what they sent,
+// as one update per sender grid that still exists.
+func (h *GridDistributionHandler) releaseCredits(ctx context.Context, released []models.GridInterchange) ([]models.SiblingUpdate, error) {
+	returned := map[primitive.ObjectID]float64{}
+	var ids []primitive.ObjectID
+	for _, ic := range released {
+		if _, ok := returned[ic.FromGridID]; !ok {
+			ids = append(ids, ic.FromGridID)
+		}
+		returned[ic.FromGridID] += ic.Sent
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	cursor, err := h.db.Collection("grid_networks").Find(ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"version": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var senders []models.GridNetwork
+	if err := cursor.All(ctx, &senders); err != nil {
+		return nil, err
+	}
+	updates := make([]models.SiblingUpdate, 0, len(senders))
+	for _, sender := range senders {
+		updates = append(updates, models.SiblingUpdate{GridID: sender.ID, Version: sender.Version, InterchangeDelta: returned[sender.ID]})
+	}
+	return updates, nil
+}
+
+// mergeSiblingUpdates adds up the deltas of updates to the same grid. The
+// lowest version read is kept, so a grid that changed in between still
+// fails the plan.
+func mergeSiblingUpdates(lists ...[]models.SiblingUpdate) []models.SiblingUpdate {
+	var merged []models.SiblingUpdate
+	index := map[primitive.ObjectID]int{}
+	for _, list := range lists {
+		for _, u := range list {
+			i, ok := index[u.GridID]
+			if !ok {
+				index[u.GridID] = len(merged)
+				merged = append(merged, u)
+				continue
+			}
+			merged[i].InterchangeDelta += u.InterchangeDelta
+			if u.Version < merged[i].Version {
+				merged[i].Version = u.Version
+			}
+		}
+	}
+	return merged
+}
+
+// escalate covers up to need MW of grid's unserved demand from the spare
+// capacity of its sibling grids, routed over the parent grid's topology.
+// credits are what grid's released imports give back to their senders; the
+// senders' spare capacity includes it, but the credits themselves are left
+// to the caller.
+func (h *GridDistributionHandler) escalate(ctx context.Context, grid *models.GridNetwork, need float64, strategy BalancingStrategy, credits []models.SiblingUpdate) ([]models.GridInterchange, []models.SiblingUpdate, error) {
+	parent, err := h.parentGrid(ctx, grid)
+	if err != nil || parent == nil {
+		return nil, nil, err
+	}
+	siblings, err := h.childGrids(ctx, parent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	returned := map[primitive.ObjectID]float64{}
+	for _, credit := range credits {
+		returned[credit.GridID] += credit.InterchangeDelta
+	}
+
+	parentNodes := map[primitive.ObjectID]models.ChildNode{}
+	for _, node := range parent.ChildNodes {
+		parentNodes[node.ID] = node
+	}
+
+	// The parent level as seen from grid: its own parent node needs the
+	// shortfall, and each sibling's parent node offers its spare capacity.
+	level := models.GridNetwork{ID: parent.ID, Lines: parent.Lines, LossModel: parent.LossModel}
+	level.ChildNodes = append(level.ChildNodes, models.ChildNode{
+		ID:            grid.ParentNodeID,
+		Location:      parentNodes[grid.ParentNodeID].Location,
+		CurrentDemand: need,
+	})
+	bySourceNode := map[primitive.ObjectID]*models.GridNetwork{}
+	for i := range siblings {
+		sibling := &siblings[i]
+		if sibling.ID == grid.ID || sibling.ParentNodeID == grid.ParentNodeID {
+			continue
+		}
+		// The parent level has one source per node, so only the first grid
+		// behind a node offers its spare capacity.
+		if _, taken := bySourceNode[sibling.ParentNodeID]; taken {
+			continue
+		}
+		spare := gridSupply(sibling) + returned[sibling.ID]
+		for _, node := range sibling.ChildNodes {
+			node.CurrentDemand, _ = netDemand(node, generationOutput(node))
+			spare -= servableDemand(node)
+		}
+		if spare <= minTransferAmount {
+			continue
+		}
+		bySourceNode[sibling.ParentNodeID] = sibling
+		level.ChildNodes = append(level.ChildNodes, models.ChildNode{
+			ID:             sibling.ParentNodeID,
+			Location:       parentNodes[sibling.ParentNodeID].Location,
+			AllocatedPower: spare,
+		})
+	}
+	if len(bySourceNode) == 0 {
+		return nil, nil, nil
+	}
+
+	topology := gridTopology(&level, gridLossModel(&level))
+	transfers, err := strategy.Balance(&level, topology)
+	if err != nil {
+		return nil, nil, err
+	}
+	priceSharedLines(&level, topology, transfers)
+
+	now := time.Now()
+	var interchanges []models.GridInterchange
+	deltas := map[primitive.ObjectID]float64{}
+	for _, t := range transfers {
+		sibling := bySourceNode[t.FromNodeID]
+		interchanges = append(interchanges, models.GridInterchange{
+			ParentGridID: parent.ID,
+			FromGridID:   sibling.ID,
+			ToGridID:     grid.ID,
+			Sent:         t.Amount,
+			Delivered:    t.Amount * (1 - t.LossEstimate/100),
+			LossEstimate: t.LossEstimate,
+			Lines:        t.Lines,
+			Active:       true,
+			CreatedAt:    now,
+		})
+		deltas[sibling.ID] -= t.Amount
+	}
+
+	var updates []models.SiblingUpdate
+	for i := range siblings {
+		if delta, ok := deltas[siblings[i].ID]; ok && siblings[i].ID != grid.ID {
+			updates = append(updates, models.SiblingUpdate{GridID: siblings[i].ID, Version: siblings[i].Version, InterchangeDelta: delta})
+		}
+	}
+	return interchanges, updates, nil
+}
+
+// gridTree builds the hierarchy below grid with own and rolled-up metrics.
+func (h *GridDistributionHandler) gridTree(ctx context.Context, grid *models.GridNetwork, depth int) (models.GridTreeNode, error) {
+	tree := models.GridTreeNode{
+		GridID:       grid.ID,
+		ParentNodeID: grid.ParentNodeID,
+		Tier:         grid.Tier,
+		Metrics:      gridMetrics(grid, nil),
+	}
+	if depth >= maxGridDepth {
+		tree.Rollup = tree.Metrics
+		return tree, nil
+	}
+
+	children, err := h.childGrids(ctx, grid)
+	if err != nil {
+		return tree, err
+	}
+	behind := map[primitive.ObjectID]models.GridMetrics{}
+	for i := range children {
+		child, err := h.gridTree(ctx, &children[i], depth+1)
+		if err != nil {
+			return tree, err
+		}
+		tree.Children = append(tree.Children, child)
+		behind[children[i].ParentNodeID] = child.Rollup
+	}
+	tree.Rollup = gridMetrics(grid, behind)
+	return tree, nil
+}
+
+// gridMetrics sums grid's nodes, substituting the rollup of the grid behind
+// a node where there is one.
+func gridMetrics(grid *models.GridNetwork, behind map[primitive.ObjectID]models.GridMetrics) models.GridMetrics {
+	m := models.GridMetrics{Supply: grid.TotalCapacity + grid.Interchange}
+	for _, node := range grid.ChildNodes {
+		if sub, ok := behind[node.ID]; ok {
+			m.Nodes += sub.Nodes
+			m.NodeCapacity += sub.NodeCapacity
+			m.Demand += sub.Demand
+			m.Generation += sub.Generation
+			m.Allocated += sub.Allocated
+			m.Unserved += sub.Unserved
+			continue
+		}
+		demand, _ := netDemand(node, generationOutput(node))
+		m.Nodes++
+		m.NodeCapacity += node.Capacity
+		m.Demand += node.CurrentDemand
+		m.Generation += generationOutput(node)
+		m.Allocated += node.AllocatedPower
+		m.Unserved += math.Max(demand-node.AllocatedPower, 0)
+	}
+	return m
+}
+
+// GetGridTree returns the hierarchy below a grid, at any level.
+func (h *GridDistributionHandler) GetGridTree(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	tree, err := h.gridTree(c.Context(), grid, 0)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not build grid tree"})
+	}
+	return c.JSON(tree)
+}
+
+// GetGridMetrics returns a grid's own and rolled-up metrics without the
+// tree itself.
+func (h *GridDistributionHandler) GetGridMetrics(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	tree, err := h.gridTree(c.Context(), grid, 0)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not aggregate grid metrics"})
+	}
+	return c.JSON(fiber.Map{"grid_id": grid.ID, "tier": grid.Tier, "metrics": tree.Metrics, "rollup": tree.Rollup})
+}
+
+// gridSupply is the power available to a grid's nodes: its own capacity
+// plus whatever it currently imports from, or minus what it exports to,
+// its siblings.
+func gridSupply(grid *models.GridNetwork) float64 {
+	return grid.TotalCapacity + grid.Interchange
+}
+
+// applyInterchanges moves a plan's escalation into place inside applyPlan's
+// transaction and snapshots the siblings it changes with meta. A sibling
+// that changed since the plan was computed fails it with errGridConflict, as
+// does an import released in the meantime.
+func (h *GridDistributionHandler) applyInterchanges(sc mongo.SessionContext, plan *models.BalancePlan, meta models.GridSnapshot) error {
+	now := meta.TakenAt
+	for _, sibling := range plan.Siblings {
+		res, err := h.db.Collection("grid_networks").UpdateOne(sc,
+			gridVersionFilter(sibling.GridID, sibling.Version),
+			bson.M{"$inc": bson.M{"version": 1, "interchange": sibling.InterchangeDelta}},
+		)
+		if err != nil {
+			return fmt.Errorf("update sibling grid: %w", err)
+		}
+		if res.MatchedCount == 0 {
+			return errGridConflict
+		}
+		if err := h.snapshotStored(sc, sibling.GridID, meta); err != nil {
+			return err
+		}
+	}
+
+	if len(plan.ReleasedInterchanges) > 0 {
+		res, err := h.db.Collection("grid_interchanges").UpdateMany(sc,
+			bson.M{"_id": bson.M{"$in": plan.ReleasedInterchanges}, "active": true},
+			bson.M{"$set": bson.M{"active": false, "released_at": now}},
+		)
+		if err != nil {
+			return fmt.Errorf("release interchanges: %w", err)
+		}
+		if res.ModifiedCount != int64(len(plan.ReleasedInterchanges)) {
+			return errGridConflict
+		}
+	}
+
+	if len(plan.Interchanges) > 0 {
+		docs := make([]interface{}, 0, len(plan.Interchanges))
+		for _, ic := range plan.Interchanges {
+			ic.CreatedAt = now
+			docs = append(docs, ic)
+		}
+		if _, err := h.db.Collection("grid_interchanges").InsertMany(sc, docs); err != nil {
+			return fmt.Errorf("record interchanges: %w", err)
+		}
+	}
+	return nil
+}
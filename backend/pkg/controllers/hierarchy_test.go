@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMergeSiblingUpdates(t *testing.T) {
+	a, b := primitive.NewObjectID(), primitive.NewObjectID()
+	credits := []models.SiblingUpdate{{GridID: a, Version: 3, InterchangeDelta: 8}}
+	escalated := []models.SiblingUpdate{
+		{GridID: a, Version: 4, InterchangeDelta: -5},
+		{GridID: b, Version: 1, InterchangeDelta: -2},
+	}
+
+	merged := mergeSiblingUpdates(credits, escalated)
+	if len(merged) != 2 {
+		t.Fatalf("got %d updates, want 2", len(merged))
+	}
+	if merged[0].GridID != a || merged[0].InterchangeDelta != 3 || merged[0].Version != 3 {
+		t.Errorf("sender update = %+v, want delta 3 at version 3", merged[0])
+	}
+	if merged[1].GridID != b || merged[1].InterchangeDelta != -2 {
+		t.Errorf("sibling update = %+v, want delta -2", merged[1])
+	}
+
+	// Released imports are credited even when nothing escalates.
+	if merged := mergeSiblingUpdates(credits, nil); len(merged) != 1 || merged[0].InterchangeDelta != 8 {
+		t.Errorf("credits alone = %+v, want the sender credited 8", merged)
+	}
+}
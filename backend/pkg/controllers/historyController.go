@@ -40,15 +40,20 @@ func (h *HistoryHandler) GetZoneHistory(c *fiber.Ctx) error {
 		})
 	}
 
+	match, err := scopeByZone(c, h.db, bson.M{
+		"zone_id": zoneID,
+		"timestamp": bson.M{
+			"$gte": startDate,
+			"$lte": endDate,
+		},
+	})
+	if err != nil {
+		return tenantError(c, err)
+	}
+
 	pipeline := []bson.M{
 		{
-			"$match": bson.M{
-				"zone_id": zoneID,
-				"timestamp": bson.M{
-					"$gte": startDate,
-					"$lte": endDate,
-				},
-			},
+			"$match": match,
 		},
 		{
 			"$group": bson.M{
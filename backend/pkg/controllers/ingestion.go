@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/db"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxIngestBatch = 1000
+
+// IngestMeterReadings stores a batch of consumption readings pushed by
+// meters or SCADA gateways.
+func IngestMeterReadings(c *fiber.Ctx) error {
+	var readings []models.PowerConsumption
+	if err := c.BodyParser(&readings); err != nil || len(readings) == 0 || len(readings) > maxIngestBatch {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Expected a JSON array of 1-1000 readings"})
+	}
+
+	docs := make([]interface{}, 0, len(readings))
+	zones := make([]string, 0, len(readings))
+	for i := range readings {
+		if readings[i].ZoneID == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Every reading needs a zone_id"})
+		}
+		readings[i].ID = primitive.NewObjectID()
+		if readings[i].Timestamp.IsZero() {
+			readings[i].Timestamp = time.Now()
+		}
+		zones = append(zones, readings[i].ZoneID)
+		docs = append(docs, readings[i])
+	}
+
+	foreign, err := foreignZone(c, zones)
+	if err != nil {
+		return tenantError(c, err)
+	}
+	if foreign != "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Zone outside your enterprise: " + foreign})
+	}
+
+	if _, err := db.GetCollection("power_consumption").InsertMany(context.TODO(), docs); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not store readings"})
+	}
+	recordAudit(c, models.AuditMeterIngest, "power_consumption", "", nil, bson.M{"count": len(docs), "zones": zones})
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"inserted": len(docs)})
+}
+
+// IngestForecasts stores forecasts published by the ML service.
+func IngestForecasts(c *fiber.Ctx) error {
+	var forecasts []models.PowerForecast
+	if err := c.BodyParser(&forecasts); err != nil || len(forecasts) == 0 || len(forecasts) > maxIngestBatch {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Expected a JSON array of 1-1000 forecasts"})
+	}
+
+	docs := make([]interface{}, 0, len(forecasts))
+	zones := make([]string, 0, len(forecasts))
+	for i := range forecasts {
+		if forecasts[i].ZoneID == "" || forecasts[i].Timestamp.IsZero() {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Every forecast needs a zone_id and timestamp"})
+		}
+		forecasts[i].ID = primitive.NewObjectID()
+		zones = append(zones, forecasts[i].ZoneID)
+		docs = append(docs, forecasts[i])
+	}
+
+	foreign, err := foreignZone(c, zones)
+	if err != nil {
+		return tenantError(c, err)
+	}
+	if foreign != "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Zone outside your enterprise: " + foreign})
+	}
+
+	if _, err := db.GetCollection("power_forecasts").InsertMany(context.TODO(), docs); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not store forecasts"})
+	}
+	recordAudit(c, models.AuditForecastIngest, "power_forecasts", "", nil, bson.M{"count": len(docs), "zones": zones})
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"inserted": len(docs)})
+}
+
+// foreignZone returns the first zone that lies outside the caller's tenant,
+// or "" if the caller may write to all of them.
+func foreignZone(c *fiber.Ctx, zones []string) (string, error) {
+	enterprise, crossTenant, err := callerTenant(c)
+	if err != nil || crossTenant {
+		return "", err
+	}
+
+	owned, err := tenantZoneIDs(c.Context(), db.DB, enterprise)
+	if err != nil {
+		return "", err
+	}
+	for _, z := range zones {
+		if !containsString(owned, z) {
+			return z, nil
+		}
+	}
+	return "", nil
+}
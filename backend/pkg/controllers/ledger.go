@@ -0,0 +1,398 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// queryTimeRange reads the optional from and to query parameters (RFC 3339).
+// It returns a message describing the first invalid one.
+func queryTimeRange(c *fiber.Ctx) (from, to *time.Time, msg string) {
+	for _, param := range []string{"from", "to"} {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, nil, "Invalid " + param + " time, expected RFC 3339"
+		}
+		if param == "from" {
+			from = &t
+		} else {
+			to = &t
+		}
+	}
+	return from, to, ""
+}
+
+// timeRangeFilter matches field against an inclusive range; nil ends are
+// open.
+func timeRangeFilter(filter bson.M, field string, from, to *time.Time) {
+	r := bson.M{}
+	if from != nil {
+		r["$gte"] = *from
+	}
+	if to != nil {
+		r["$lte"] = *to
+	}
+	if len(r) > 0 {
+		filter[field] = r
+	}
+}
+
+// ledgerFilter builds the energy_transfers filter shared by the ledger
+// endpoints: the grid, an optional node at either end and the time range.
+func ledgerFilter(c *fiber.Ctx, grid *models.GridNetwork) (bson.M, *time.Time, *time.Time, string) {
+	filter := bson.M{"grid_id": grid.ID}
+	if raw := c.Query("node_id"); raw != "" {
+		nodeID, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			return nil, nil, nil, "Invalid node_id"
+		}
+		filter["$or"] = bson.A{bson.M{"from_node_id": nodeID}, bson.M{"to_node_id": nodeID}}
+	}
+	from, to, msg := queryTimeRange(c)
+	if msg != "" {
+		return nil, nil, nil, msg
+	}
+	timeRangeFilter(filter, "transfer_time", from, to)
+	return filter, from, to, ""
+}
+
+// GetEnergyTransfers pages through a grid's transfer ledger, newest first,
+// optionally narrowed to one node and a time range.
+func (h *GridDistributionHandler) GetEnergyTransfers(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	filter, _, _, msg := ledgerFilter(c, grid)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	page, limit, msg := pageParams(c)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	collection := h.db.Collection("energy_transfers")
+	total, err := collection.CountDocuments(c.Context(), filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not fetch energy transfers"})
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "transfer_time", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip((page - 1) * limit).SetLimit(limit)
+	cursor, err := collection.Find(c.Context(), filter, opts)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not fetch energy transfers"})
+	}
+	transfers := []models.EnergyTransfer{}
+	if err := cursor.All(c.Context(), &transfers); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not fetch energy transfers"})
+	}
+	return c.JSON(models.Page{Items: transfers, Total: total, Page: page, Limit: limit})
+}
+
+// GetEnergyTransferSummary totals sent, received and lost power per node,
+// per day (in the tz time zone, UTC by default) or per node and day.
+func (h *GridDistributionHandler) GetEnergyTransferSummary(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	filter, _, _, msg := ledgerFilter(c, grid)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	tz := c.Query("tz", "UTC")
+	if _, err := time.LoadLocation(tz); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Unknown time zone"})
+	}
+
+	group := bson.M{}
+	switch c.Query("group", "node") {
+	case "node":
+		group["node"] = "$legs.node"
+	case "day":
+		group["day"] = "$legs.day"
+	case "node_day":
+		group["node"] = "$legs.node"
+		group["day"] = "$legs.day"
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "group must be node, day or node_day"})
+	}
+
+	// Each transfer becomes a sending and a receiving leg. Without a node in
+	// the grouping or filter both legs land in the same bucket, so only the
+	// sending leg counts the transfer.
+	_, byNode := group["node"]
+	nodeID, _ := primitive.ObjectIDFromHex(c.Query("node_id"))
+	receiverCount := 0
+	if byNode || !nodeID.IsZero() {
+		receiverCount = 1
+	}
+	lossFraction := bson.M{"$divide": bson.A{"$loss_estimate", 100}}
+	day := bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$transfer_time", "timezone": tz}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$project", Value: bson.M{"legs": bson.A{
+			bson.M{
+				"node": "$from_node_id", "day": day, "count": 1,
+				"sent": "$amount", "received": 0.0,
+				"loss": bson.M{"$multiply": bson.A{"$amount", lossFraction}},
+			},
+			bson.M{
+				"node": "$to_node_id", "day": day, "count": receiverCount,
+				"sent": 0.0, "received": bson.M{"$multiply": bson.A{"$amount", bson.M{"$subtract": bson.A{1, lossFraction}}}},
+				"loss": 0.0,
+			},
+		}}}},
+		{{Key: "$unwind", Value: "$legs"}},
+	}
+	if !nodeID.IsZero() {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"legs.node": nodeID}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":       group,
+			"sent":      bson.M{"$sum": "$legs.sent"},
+			"received":  bson.M{"$sum": "$legs.received"},
+			"loss":      bson.M{"$sum": "$legs.loss"},
+			"transfers": bson.M{"$sum": "$legs.count"},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id": 0, "node_id": "$_id.node", "day": "$_id.day",
+			"sent": 1, "received": 1, "loss": 1, "transfers": 1,
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "day", Value: 1}, {Key: "node_id", Value: 1}}}},
+	)
+
+	cursor, err := h.db.Collection("energy_transfers").Aggregate(c.Context(), pipeline)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not summarise energy transfers"})
+	}
+	summary := []models.LedgerSummary{}
+	if err := cursor.All(c.Context(), &summary); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not summarise energy transfers"})
+	}
+	return c.JSON(summary)
+}
+
+// ReconcileLedger checks a grid's transfers against the allocation
+// snapshots of the balances that produced them. Balances are selected by
+// snapshot or transfer time; their snapshots and transfers are then loaded
+// in full so a balance straddling the range edge is not reported as broken.
+func (h *GridDistributionHandler) ReconcileLedger(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	from, to, msg := queryTimeRange(c)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	snapshots, transfers, err := h.loadLedger(c.Context(), grid.ID, from, to)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not load ledger"})
+	}
+
+	return c.JSON(models.LedgerReconciliation{
+		GridID:        grid.ID,
+		From:          from,
+		To:            to,
+		Snapshots:     len(snapshots),
+		Transfers:     len(transfers),
+		Discrepancies: reconcileLedger(snapshots, transfers),
+	})
+}
+
+// loadLedger returns the snapshots taken and the transfers made in a time
+// range, completed with the snapshots and transfers of the same balances.
+// Snapshots come back oldest first.
+func (h *GridDistributionHandler) loadLedger(ctx context.Context, gridID primitive.ObjectID, from, to *time.Time) ([]models.AllocationSnapshot, []models.EnergyTransfer, error) {
+	snapshotFilter := bson.M{"grid_id": gridID}
+	timeRangeFilter(snapshotFilter, "taken_at", from, to)
+	var snapshots []models.AllocationSnapshot
+	if err := h.findAll(ctx, "allocation_snapshots", snapshotFilter, &snapshots); err != nil {
+		return nil, nil, err
+	}
+	planIDs := bson.A{}
+	for _, s := range snapshots {
+		planIDs = append(planIDs, s.PlanID)
+	}
+
+	rangeFilter := bson.M{}
+	timeRangeFilter(rangeFilter, "transfer_time", from, to)
+	var transfers []models.EnergyTransfer
+	err := h.findAll(ctx, "energy_transfers", bson.M{
+		"grid_id": gridID,
+		"$or":     bson.A{rangeFilter, bson.M{"plan_id": bson.M{"$in": planIDs}}},
+	}, &transfers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := map[primitive.ObjectID]bool{}
+	for _, s := range snapshots {
+		seen[s.PlanID] = true
+	}
+	missing := bson.A{}
+	for _, t := range transfers {
+		if !t.PlanID.IsZero() && !seen[t.PlanID] {
+			seen[t.PlanID] = true
+			missing = append(missing, t.PlanID)
+		}
+	}
+	if len(missing) > 0 {
+		var more []models.AllocationSnapshot
+		err := h.findAll(ctx, "allocation_snapshots", bson.M{"grid_id": gridID, "plan_id": bson.M{"$in": missing}}, &more)
+		if err != nil {
+			return nil, nil, err
+		}
+		snapshots = append(snapshots, more...)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].TakenAt.Before(snapshots[j].TakenAt) })
+	return snapshots, transfers, nil
+}
+
+func (h *GridDistributionHandler) findAll(ctx context.Context, collection string, filter bson.M, out interface{}) error {
+	cursor, err := h.db.Collection(collection).Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, out)
+}
+
+// reconcileLedger compares each balance's net transfers per node with the
+// change its snapshot recorded between distribution and transfers, and each
+// snapshot's starting allocations with the previous snapshot's results.
+func reconcileLedger(snapshots []models.AllocationSnapshot, transfers []models.EnergyTransfer) []models.LedgerDiscrepancy {
+	discrepancies := []models.LedgerDiscrepancy{}
+
+	byPlan := map[primitive.ObjectID][]models.EnergyTransfer{}
+	legacy := 0.0
+	legacyCount := 0
+	for _, t := range transfers {
+		if t.PlanID.IsZero() {
+			legacy += t.Amount
+			legacyCount++
+			continue
+		}
+		byPlan[t.PlanID] = append(byPlan[t.PlanID], t)
+	}
+	if legacyCount > 0 {
+		discrepancies = append(discrepancies, models.LedgerDiscrepancy{
+			Kind: models.DiscrepancyMissingSnapshot, Recorded: legacy,
+			Detail: fmt.Sprintf("%d transfers were recorded before balances were snapshotted", legacyCount),
+		})
+	}
+
+	var previous map[primitive.ObjectID]float64
+	for _, snapshot := range snapshots {
+		net := map[primitive.ObjectID]float64{}
+		nodes := map[primitive.ObjectID]bool{}
+		for _, a := range snapshot.Allocations {
+			nodes[a.NodeID] = true
+		}
+		for _, t := range byPlan[snapshot.PlanID] {
+			for _, end := range []primitive.ObjectID{t.FromNodeID, t.ToNodeID} {
+				if !nodes[end] {
+					discrepancies = append(discrepancies, models.LedgerDiscrepancy{
+						Kind: models.DiscrepancyUnknownNode, PlanID: snapshot.PlanID, NodeID: end, TransferID: t.ID,
+						Recorded: t.Amount, Detail: "transfer endpoint is not a node of the snapshot",
+					})
+				}
+			}
+			net[t.FromNodeID] -= t.Amount
+			net[t.ToNodeID] += t.Amount * (1 - t.LossEstimate/100)
+		}
+		delete(byPlan, snapshot.PlanID)
+
+		for _, a := range snapshot.Allocations {
+			if expected := a.After - a.Base; math.Abs(expected-net[a.NodeID]) > violationTolerance {
+				discrepancies = append(discrepancies, models.LedgerDiscrepancy{
+					Kind: models.DiscrepancyAllocationMismatch, PlanID: snapshot.PlanID, NodeID: a.NodeID,
+					Expected: expected, Recorded: net[a.NodeID],
+					Detail: fmt.Sprintf("%s changed by %.3f MW but its transfers net %.3f MW", a.Name, expected, net[a.NodeID]),
+				})
+			}
+			if before, ok := previous[a.NodeID]; ok && math.Abs(before-a.Before) > violationTolerance {
+				discrepancies = append(discrepancies, models.LedgerDiscrepancy{
+					Kind: models.DiscrepancyOutOfBand, PlanID: snapshot.PlanID, NodeID: a.NodeID,
+					Expected: before, Recorded: a.Before,
+					Detail: fmt.Sprintf("%s was changed outside balancing since the previous snapshot", a.Name),
+				})
+			}
+		}
+
+		previous = map[primitive.ObjectID]float64{}
+		for _, a := range snapshot.Allocations {
+			previous[a.NodeID] = a.After
+		}
+	}
+
+	for planID, orphans := range byPlan {
+		sent := 0.0
+		for _, t := range orphans {
+			sent += t.Amount
+		}
+		discrepancies = append(discrepancies, models.LedgerDiscrepancy{
+			Kind: models.DiscrepancyMissingSnapshot, PlanID: planID, Recorded: sent,
+			Detail: fmt.Sprintf("%d transfers belong to a balance with no snapshot", len(orphans)),
+		})
+	}
+	return discrepancies
+}
+
+// migrateEnergyTransfers moves ledger entries written with from/to keys to
+// the EnergyTransfer schema and attributes them to the grid holding their
+// sending node.
+func migrateEnergyTransfers(ctx context.Context, database *mongo.Database) error {
+	transfers := database.Collection("energy_transfers")
+	_, err := transfers.UpdateMany(ctx,
+		bson.M{"from": bson.M{"$exists": true}},
+		bson.M{"$rename": bson.M{"from": "from_node_id", "to": "to_node_id"}},
+	)
+	if err != nil {
+		return err
+	}
+
+	nodeIDs, err := transfers.Distinct(ctx, "from_node_id", bson.M{"grid_id": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	for _, nodeID := range nodeIDs {
+		var grid models.GridNetwork
+		err := database.Collection("grid_networks").FindOne(ctx, bson.M{"child_nodes._id": nodeID},
+			options.FindOne().SetProjection(bson.M{"_id": 1})).Decode(&grid)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = transfers.UpdateMany(ctx,
+			bson.M{"from_node_id": nodeID, "grid_id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"grid_id": grid.ID}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// LossModel prices the power lost moving a transfer. Rates are fractions of
+// the power sent, between 0 and 1.
+type LossModel interface {
+	Name() string
+	// Params are the settings the model prices with. They are recorded on
+	// every transfer the model prices.
+	Params() map[string]interface{}
+	// DistanceLoss is the rate over a straight-line distance in km carrying
+	// load MW, for grids without lines.
+	DistanceLoss(distance, load float64) float64
+	// LineLoss is the rate on a line carrying load MW.
+	LineLoss(line models.GridLine, load float64) float64
+}
+
+var lossModels = map[string]LossModel{
+	models.LossModelLinear:    LinearLoss{PerTenKm: 0.005, Cap: 0.5},
+	models.LossModelResistive: ResistiveLoss{OhmsPerKm: 0.2, VoltageKV: 33, ReferenceLoad: 10},
+}
+
+// lossModel resolves a configured loss model name.
+func lossModel(name string) (LossModel, bool) {
+	m, ok := lossModels[name]
+	return m, ok
+}
+
+// gridLossModel is the grid's configured model. Grids without one, or whose
+// configured one is no longer loaded, are priced resistively when they have
+// lines to take resistance and voltage from and linearly otherwise.
+func gridLossModel(grid *models.GridNetwork) LossModel {
+	if grid.LossModel != "" {
+		if m, ok := lossModel(grid.LossModel); ok {
+			return m
+		}
+		log.Printf("Unknown loss model %q on grid %s, using the default", grid.LossModel, grid.ID.Hex())
+	}
+	if len(grid.Lines) > 0 {
+		return lossModels[models.LossModelResistive]
+	}
+	return lossModels[models.LossModelLinear]
+}
+
+// planningLoad is the load straight-line routes are priced at before the
+// strategy picks amounts. Only the resistive model's rate depends on it.
+func planningLoad(model LossModel) float64 {
+	if m, ok := model.(ResistiveLoss); ok {
+		return m.ReferenceLoad
+	}
+	return 0
+}
+
+// LinearLoss is the original model: a fixed rate per 10 km, capped.
+type LinearLoss struct {
+	PerTenKm float64
+	Cap      float64
+}
+
+func (LinearLoss) Name() string { return models.LossModelLinear }
+
+func (m LinearLoss) Params() map[string]interface{} {
+	return map[string]interface{}{"per_10_km": m.PerTenKm, "cap": m.Cap}
+}
+
+func (m LinearLoss) DistanceLoss(distance, _ float64) float64 {
+	return math.Min(m.PerTenKm*(distance/10), m.Cap)
+}
+
+func (m LinearLoss) LineLoss(line models.GridLine, load float64) float64 {
+	return m.DistanceLoss(line.Length, load)
+}
+
+// ResistiveLoss is the I²R loss of a three-phase line. With I = P/(√3·V)
+// the loss 3·I²·R reduces to P²·R/V², so the rate is P·R/V² with P in MW
+// and V in kV. Straight-line distances are priced as a conductor of
+// OhmsPerKm at VoltageKV; routes over them are planned at ReferenceLoad.
+type ResistiveLoss struct {
+	OhmsPerKm     float64
+	VoltageKV     float64
+	ReferenceLoad float64 // in MW
+}
+
+func (ResistiveLoss) Name() string { return models.LossModelResistive }
+
+func (m ResistiveLoss) Params() map[string]interface{} {
+	return map[string]interface{}{"ohms_per_km": m.OhmsPerKm, "voltage_kv": m.VoltageKV, "reference_load_mw": m.ReferenceLoad}
+}
+
+func (m ResistiveLoss) DistanceLoss(distance, load float64) float64 {
+	return m.LineLoss(models.GridLine{Resistance: distance * m.OhmsPerKm, VoltageKV: m.VoltageKV}, load)
+}
+
+func (ResistiveLoss) LineLoss(line models.GridLine, load float64) float64 {
+	if line.VoltageKV <= 0 {
+		return 1
+	}
+	return math.Min(load*line.Resistance/(line.VoltageKV*line.VoltageKV), 1)
+}
+
+// TableLoss interpolates the rate linearly between measured distances and
+// holds it flat beyond the last one. Tables come from LOSS_MODELS_FILE.
+type TableLoss struct {
+	Label  string           `json:"name"`
+	Points []LossTablePoint `json:"points"`
+}
+
+type LossTablePoint struct {
+	DistanceKM float64 `json:"distance_km"`
+	Loss       float64 `json:"loss"`
+}
+
+func (m TableLoss) Name() string { return m.Label }
+
+func (m TableLoss) Params() map[string]interface{} {
+	return map[string]interface{}{"points": m.Points}
+}
+
+func (m TableLoss) DistanceLoss(distance, _ float64) float64 {
+	points := m.Points
+	if distance <= points[0].DistanceKM {
+		return points[0].Loss
+	}
+	for i := 1; i < len(points); i++ {
+		if distance <= points[i].DistanceKM {
+			a, b := points[i-1], points[i]
+			return a.Loss + (b.Loss-a.Loss)*(distance-a.DistanceKM)/(b.DistanceKM-a.DistanceKM)
+		}
+	}
+	return points[len(points)-1].Loss
+}
+
+func (m TableLoss) LineLoss(line models.GridLine, load float64) float64 {
+	return m.DistanceLoss(line.Length, load)
+}
+
+// SetupLossModels registers the table models listed in LOSS_MODELS_FILE, a
+// JSON document of the form {"tables": [{"name": ..., "points": [...]}]}.
+// Table names must start with "table:". Invalid tables are logged and
+// skipped.
+func SetupLossModels() {
+	path := os.Getenv("LOSS_MODELS_FILE")
+	if path == "" {
+		return
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		log.Println("Could not read loss models:", err)
+		return
+	}
+	var config struct {
+		Tables []TableLoss `json:"tables"`
+	}
+	if err := json.Unmarshal(raw, &config); err != nil {
+		log.Println("Could not parse loss models:", err)
+		return
+	}
+	for _, table := range config.Tables {
+		if err := validateLossTable(&table); err != nil {
+			log.Printf("Skipping loss table %q: %v", table.Label, err)
+			continue
+		}
+		lossModels[table.Label] = table
+	}
+}
+
+// validateLossTable checks a table and sorts its points by distance.
+func validateLossTable(table *TableLoss) error {
+	if !strings.HasPrefix(table.Label, models.LossModelTablePrefix) || len(table.Label) == len(models.LossModelTablePrefix) {
+		return fmt.Errorf("name must start with %q", models.LossModelTablePrefix)
+	}
+	if len(table.Points) == 0 {
+		return fmt.Errorf("no points")
+	}
+	sort.Slice(table.Points, func(i, j int) bool { return table.Points[i].DistanceKM < table.Points[j].DistanceKM })
+	for i, p := range table.Points {
+		if p.DistanceKM < 0 || p.Loss < 0 || p.Loss >= 1 {
+			return fmt.Errorf("point %d: distance must be non-negative and loss in [0, 1)", i)
+		}
+		if i > 0 && p.DistanceKM == table.Points[i-1].DistanceKM {
+			return fmt.Errorf("duplicate distance %g km", p.DistanceKM)
+		}
+	}
+	return nil
+}
+
+// GetLossModels lists the loss models grids can choose from.
+func (h *GridDistributionHandler) GetLossModels(c *fiber.Ctx) error {
+	list := []fiber.Map{}
+	for name, m := range lossModels {
+		list = append(list, fiber.Map{"name": name, "params": m.Params()})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i]["name"].(string) < list[j]["name"].(string) })
+	return c.JSON(list)
+}
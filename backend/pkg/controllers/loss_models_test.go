@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// linedGrid has a node with 10 MW to spare and a node 10 MW short, joined by
+// one 33 kV line.
+func linedGrid() (*models.GridNetwork, models.GridLine) {
+	from, to := primitive.NewObjectID(), primitive.NewObjectID()
+	line := models.GridLine{
+		ID: primitive.NewObjectID(), FromNodeID: from, ToNodeID: to,
+		Length: 20, Resistance: 4, VoltageKV: 33, ThermalRating: 100, InService: true,
+	}
+	return &models.GridNetwork{
+		ID:            primitive.NewObjectID(),
+		TotalCapacity: 100,
+		ChildNodes: []models.ChildNode{
+			{ID: from, Name: "spare", CurrentDemand: 5, AllocatedPower: 15},
+			{ID: to, Name: "short", CurrentDemand: 20, AllocatedPower: 10},
+		},
+		Lines: []models.GridLine{line},
+	}, line
+}
+
+func TestGridLossModelDefault(t *testing.T) {
+	grid, _ := linedGrid()
+	if got := gridLossModel(grid).Name(); got != models.LossModelResistive {
+		t.Errorf("grid with lines uses %q, want resistive", got)
+	}
+	grid.Lines = nil
+	if got := gridLossModel(grid).Name(); got != models.LossModelLinear {
+		t.Errorf("grid without lines uses %q, want linear", got)
+	}
+	grid.LossModel = "table:missing"
+	if got := gridLossModel(grid).Name(); got != models.LossModelLinear {
+		t.Errorf("unknown model falls back to %q, want linear", got)
+	}
+}
+
+func TestLineWithoutVoltageKeptForLinear(t *testing.T) {
+	grid, line := linedGrid()
+	grid.Lines[0].VoltageKV = 0
+
+	linear := newLineTopology(grid.Lines, lossModels[models.LossModelLinear])
+	if routes := linear.Routes(grid.ChildNodes[0], grid.ChildNodes[1]); len(routes) != 1 {
+		t.Errorf("linear model found %d routes over a line without voltage, want 1", len(routes))
+	}
+	resistive := newLineTopology(grid.Lines, lossModels[models.LossModelResistive])
+	if routes := resistive.Routes(grid.ChildNodes[0], grid.ChildNodes[1]); len(routes) != 0 {
+		t.Errorf("resistive model routed over line %s without voltage", line.ID.Hex())
+	}
+}
+
+func TestTransferLossFromAmount(t *testing.T) {
+	grid, line := linedGrid()
+	h := &GridDistributionHandler{}
+	transfers, err := h.handleExcessDemand(grid, MinLossStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 1 {
+		t.Fatalf("got %d transfers, want 1", len(transfers))
+	}
+	tr := transfers[0]
+	if tr.LossModel != models.LossModelResistive {
+		t.Errorf("transfer priced with %q, want resistive", tr.LossModel)
+	}
+
+	model := lossModels[models.LossModelResistive]
+	want := model.LineLoss(line, tr.Amount) * 100
+	if math.Abs(tr.LossEstimate-want) > 1e-9 {
+		t.Errorf("loss estimate %v%%, want %v%% at %v MW", tr.LossEstimate, want, tr.Amount)
+	}
+	if atRating := model.LineLoss(line, line.ThermalRating) * 100; tr.LossEstimate >= atRating {
+		t.Errorf("loss estimate %v%% is not below the thermal-rating rate %v%%", tr.LossEstimate, atRating)
+	}
+	if delivered := tr.Amount * (1 - tr.LossEstimate/100); math.Abs(grid.ChildNodes[1].AllocatedPower-10-delivered) > 1e-9 {
+		t.Errorf("short node received %v MW, transfer delivers %v", grid.ChildNodes[1].AllocatedPower-10, delivered)
+	}
+}
+
+func TestSharedLinePricedAtTotalFlow(t *testing.T) {
+	a, b, hub, short := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	line := func(from, to primitive.ObjectID) models.GridLine {
+		return models.GridLine{
+			ID: primitive.NewObjectID(), FromNodeID: from, ToNodeID: to,
+			Length: 20, Resistance: 4, VoltageKV: 33, ThermalRating: 100, InService: true,
+		}
+	}
+	feeder := line(hub, short)
+	grid := &models.GridNetwork{
+		ID:            primitive.NewObjectID(),
+		TotalCapacity: 100,
+		ChildNodes: []models.ChildNode{
+			{ID: a, Name: "a", CurrentDemand: 5, AllocatedPower: 17},
+			{ID: b, Name: "b", CurrentDemand: 5, AllocatedPower: 17},
+			{ID: hub, Name: "hub"},
+			{ID: short, Name: "short", CurrentDemand: 30, AllocatedPower: 10},
+		},
+		Lines: []models.GridLine{line(a, hub), line(b, hub), feeder},
+	}
+
+	h := &GridDistributionHandler{}
+	transfers, err := h.handleExcessDemand(grid, MinLossStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 2 {
+		t.Fatalf("got %d transfers, want one from each source", len(transfers))
+	}
+
+	model := lossModels[models.LossModelResistive]
+	lines := map[primitive.ObjectID]models.GridLine{}
+	for _, l := range grid.Lines {
+		lines[l.ID] = l
+	}
+	flow := transfers[0].Amount + transfers[1].Amount
+	delivered := 0.0
+	for _, tr := range transfers {
+		kept := 1.0
+		for _, id := range tr.Lines {
+			load := tr.Amount
+			if id == feeder.ID {
+				load = flow
+			}
+			kept *= 1 - model.LineLoss(lines[id], load)
+		}
+		if want := (1 - kept) * 100; math.Abs(tr.LossEstimate-want) > 1e-9 {
+			t.Errorf("transfer of %v MW lost %v%%, want %v%% with %v MW on the shared feeder", tr.Amount, tr.LossEstimate, want, flow)
+		}
+		delivered += tr.Amount * kept
+	}
+	if got := grid.ChildNodes[3].AllocatedPower - 10; math.Abs(got-delivered) > 1e-9 {
+		t.Errorf("short node received %v MW, transfers deliver %v", got, delivered)
+	}
+}
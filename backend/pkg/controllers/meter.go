@@ -2,7 +2,6 @@ package controllers
 
 import (
 	"context"
-	"log"
 	"time"
 
 	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/db"
@@ -17,15 +16,20 @@ func GetMeters(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	cursor, err := collection.Find(ctx, bson.M{})
+	filter, err := scopeByEnterprise(c, bson.M{})
 	if err != nil {
-		log.Fatal(err)
+		return tenantError(c, err)
+	}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error fetching meters"})
 	}
 	defer cursor.Close(ctx)
 
 	var meters []models.Meter
 	if err := cursor.All(ctx, &meters); err != nil {
-		log.Fatal(err)
+		return c.Status(500).JSON(fiber.Map{"error": "Error fetching meters"})
 	}
 
 	return c.JSON(meters)
@@ -0,0 +1,304 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/db"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoginMFA completes a two-step login with a TOTP or recovery code and the
+// challenge token returned by Login.
+func LoginMFA(c *fiber.Ctx) error {
+	var req models.MFALogin
+	if err := c.BodyParser(&req); err != nil || req.MFAToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	subject, err := middleware.Tokens.ValidateMFAChallenge(req.MFAToken)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired MFA token"})
+	}
+	userID, err := primitive.ObjectIDFromHex(subject)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired MFA token"})
+	}
+
+	var user models.User
+	err = db.GetCollection("users").FindOne(context.TODO(), bson.M{"_id": userID}).Decode(&user)
+	if err != nil || user.Disabled || !user.MFAEnabled {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired MFA token"})
+	}
+
+	wait, err := middleware.Lockout.Locked(c.Context(), user.Username)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not verify credentials"})
+	}
+	if wait > 0 {
+		return middleware.RetryAfter(c, wait)
+	}
+
+	ok, err := checkSecondFactor(&user, req.Code, req.RecoveryCode)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not verify code"})
+	}
+	if !ok {
+		return rejectLogin(c, user.Username, "Invalid code")
+	}
+	clearLoginFailures(c, user.Username)
+
+	return issueSession(c, &user, true)
+}
+
+// EnrollMFA starts TOTP enrollment. The secret stays pending until
+// VerifyMFA proves the authenticator app produces matching codes.
+func EnrollMFA(c *fiber.Ctx) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	if user.MFAEnabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "MFA already enabled"})
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not start enrollment"})
+	}
+	_, err = db.GetCollection("users").UpdateOne(context.TODO(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"mfa_pending_secret": secret}},
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not start enrollment"})
+	}
+
+	return c.JSON(fiber.Map{
+		"secret":      secret,
+		"otpauth_uri": totpProvisioningURI(secret, user.Username),
+	})
+}
+
+// VerifyMFA confirms enrollment and returns single-use recovery codes. They
+// are shown only once.
+func VerifyMFA(c *fiber.Ctx) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req models.MFACode
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if user.MFAEnabled || user.MFAPendingSecret == "" {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "No MFA enrollment in progress"})
+	}
+
+	step, ok := verifyTOTP(user.MFAPendingSecret, req.Code, time.Now())
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid code"})
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not enable MFA"})
+	}
+
+	_, err = db.GetCollection("users").UpdateOne(context.TODO(),
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$set": bson.M{
+				"mfa_enabled":        true,
+				"mfa_secret":         user.MFAPendingSecret,
+				"mfa_recovery_codes": hashes,
+				"mfa_last_step":      step,
+			},
+			"$unset": bson.M{"mfa_pending_secret": ""},
+		},
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not enable MFA"})
+	}
+
+	recordAudit(c, models.AuditMFAEnable, "user", user.ID.Hex(), bson.M{"mfa_enabled": false}, bson.M{"mfa_enabled": true})
+	return c.JSON(fiber.Map{"message": "MFA enabled", "recovery_codes": codes})
+}
+
+// DisableMFA turns MFA off. It needs a current TOTP or recovery code, and
+// the password too when the account has one. Accounts created through OIDC
+// have no local password, so the second factor alone is accepted for them.
+func DisableMFA(c *fiber.Ctx) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req models.MFACode
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if !user.MFAEnabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "MFA not enabled"})
+	}
+	if !passwordConfirmed(user, req.Password) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
+	}
+	ok, err := checkSecondFactor(user, req.Code, req.RecoveryCode)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not verify code"})
+	}
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid code"})
+	}
+
+	_, err = db.GetCollection("users").UpdateOne(context.TODO(),
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$set":   bson.M{"mfa_enabled": false},
+			"$unset": bson.M{"mfa_secret": "", "mfa_recovery_codes": "", "mfa_pending_secret": ""},
+		},
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not disable MFA"})
+	}
+
+	// Sessions opened with MFA must not keep their elevated status.
+	if err := middleware.Sessions.RevokeAllForUser(c.Context(), user.ID, primitive.NilObjectID, "mfa disabled"); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not revoke sessions"})
+	}
+
+	recordAudit(c, models.AuditMFADisable, "user", user.ID.Hex(), bson.M{"mfa_enabled": true}, bson.M{"mfa_enabled": false})
+	return c.JSON(fiber.Map{"message": "MFA disabled"})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after a TOTP check.
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req models.MFACode
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if !user.MFAEnabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "MFA not enabled"})
+	}
+	ok, err := checkSecondFactor(user, req.Code, "")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not verify code"})
+	}
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid code"})
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate recovery codes"})
+	}
+	_, err = db.GetCollection("users").UpdateOne(context.TODO(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"mfa_recovery_codes": hashes}},
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate recovery codes"})
+	}
+
+	recordAudit(c, models.AuditMFARecoveryCodes, "user", user.ID.Hex(), nil, nil)
+	return c.JSON(fiber.Map{"recovery_codes": codes})
+}
+
+// SetPermissionMFA marks a permission as requiring, or no longer requiring,
+// a second factor.
+func SetPermissionMFA(c *fiber.Ctx) error {
+	var req struct {
+		RequireMFA bool `json:"require_mfa"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	name := c.Params("name")
+	found, err := middleware.Roles.SetPermissionMFA(c.Context(), name, req.RequireMFA)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update permission"})
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Permission not found"})
+	}
+
+	recordAudit(c, models.AuditPermissionMFA, "permission", name, nil, bson.M{"require_mfa": req.RequireMFA})
+	return c.JSON(fiber.Map{"message": "Permission updated"})
+}
+
+// passwordConfirmed checks password against the account's own. An account
+// without a local password, such as one provisioned by OIDC, has nothing to
+// confirm.
+func passwordConfirmed(user *models.User, password string) bool {
+	if user.Password == "" {
+		return true
+	}
+	return checkPassword(user.Password, password)
+}
+
+// checkSecondFactor accepts a TOTP code or a recovery code. Both are
+// consumed atomically: a TOTP step cannot be replayed and a recovery code
+// works once.
+func checkSecondFactor(user *models.User, code, recoveryCode string) (bool, error) {
+	users := db.GetCollection("users")
+
+	if recoveryCode != "" {
+		hash := hashRecoveryCode(recoveryCode)
+		result, err := users.UpdateOne(context.TODO(),
+			bson.M{"_id": user.ID, "mfa_recovery_codes": hash},
+			bson.M{"$pull": bson.M{"mfa_recovery_codes": hash}},
+		)
+		if err != nil {
+			return false, err
+		}
+		return result.ModifiedCount == 1, nil
+	}
+
+	step, ok := verifyTOTP(user.MFASecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	result, err := users.UpdateOne(context.TODO(),
+		bson.M{"_id": user.ID, "$or": []bson.M{
+			{"mfa_last_step": bson.M{"$lt": step}},
+			{"mfa_last_step": bson.M{"$exists": false}},
+		}},
+		bson.M{"$set": bson.M{"mfa_last_step": step}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func currentUser(c *fiber.Ctx) (*models.User, error) {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok || principal.Kind != middleware.PrincipalUser {
+		return nil, errNoUser
+	}
+	userID, err := primitive.ObjectIDFromHex(principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := db.GetCollection("users").FindOne(context.TODO(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, errNoUser
+	}
+	return &user, nil
+}
@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+)
+
+func TestPasswordConfirmed(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &models.User{Username: "local", Password: hash}
+	oidc := &models.User{Username: "sso"}
+
+	tests := []struct {
+		name     string
+		user     *models.User
+		password string
+		want     bool
+	}{
+		{name: "local account, right password", user: local, password: "correct horse", want: true},
+		{name: "local account, wrong password", user: local, password: "battery staple", want: false},
+		{name: "local account, no password", user: local, want: false},
+		{name: "oidc account", user: oidc, want: true},
+		{name: "oidc account, any password", user: oidc, password: "ignored", want: true},
+	}
+	for _, tt := range tests {
+		if got := passwordConfirmed(tt.user, tt.password); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/db"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OIDCLogin redirects the browser to the identity provider.
+func OIDCLogin(c *fiber.Ctx) error {
+	if middleware.OIDC == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "OIDC login is not configured"})
+	}
+
+	authURL, err := middleware.OIDC.Begin(c.Context())
+	if err != nil {
+		log.Println("oidc: could not start login:", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Identity provider unavailable"})
+	}
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// OIDCCallback finishes the authorization-code flow, provisions or updates
+// the local user and returns the same tokens, or MFA challenge, as Login.
+func OIDCCallback(c *fiber.Ctx) error {
+	if middleware.OIDC == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "OIDC login is not configured"})
+	}
+	if errCode := c.Query("error"); errCode != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Identity provider error: " + errCode})
+	}
+
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing state or code"})
+	}
+
+	identity, err := middleware.OIDC.Complete(c.Context(), state, code)
+	if err != nil {
+		log.Println("oidc: login failed:", err)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "OIDC login failed"})
+	}
+
+	role, enterprise := middleware.OIDC.MapGroups(identity.Groups)
+	if role != "" {
+		if _, err := middleware.Roles.Permissions(c.Context(), role); err != nil {
+			log.Printf("oidc: group mapping names unknown role %q", role)
+			role = ""
+		}
+	}
+
+	user, err := provisionOIDCUser(c, identity, role, enterprise)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not provision user"})
+	}
+	if user.Disabled {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Account disabled"})
+	}
+
+	// An IdP login that was not multi-factor still has to pass the local
+	// second factor the account enrolled.
+	return completeLogin(c, user, identity.MFA)
+}
+
+// provisionOIDCUser finds the user linked to the IdP subject, creating it on
+// first login. Role and enterprise always follow the IdP groups. Local
+// accounts are never linked by username or email, so an IdP user cannot
+// take one over.
+func provisionOIDCUser(c *fiber.Ctx, identity *middleware.OIDCIdentity, role, enterprise string) (*models.User, error) {
+	users := db.GetCollection("users")
+	filter := bson.M{"oidc_issuer": identity.Issuer, "oidc_subject": identity.Subject}
+
+	var user models.User
+	err := users.FindOne(context.TODO(), filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		user = models.User{
+			ID:          primitive.NewObjectID(),
+			Username:    oidcUsername(identity),
+			Name:        identity.Name,
+			Email:       identity.Email,
+			Role:        role,
+			Enterprise:  enterprise,
+			CreatedAt:   time.Now(),
+			OIDCIssuer:  identity.Issuer,
+			OIDCSubject: identity.Subject,
+		}
+		_, err := users.InsertOne(context.TODO(), user)
+		if fallback := "oidc:" + identity.Subject; mongo.IsDuplicateKeyError(err) && user.Username != fallback {
+			// Another account took the name since oidcUsername checked.
+			user.Username = fallback
+			_, err = users.InsertOne(context.TODO(), user)
+		}
+		if err != nil {
+			return nil, err
+		}
+		recordAudit(c, models.AuditUserOIDCProvision, "user", user.ID.Hex(), nil, user)
+		return &user, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Role != role || user.Enterprise != enterprise || user.Email != identity.Email || user.Name != identity.Name {
+		before := bson.M{"role": user.Role, "enterprise": user.Enterprise, "email": user.Email, "name": user.Name}
+		after := bson.M{"role": role, "enterprise": enterprise, "email": identity.Email, "name": identity.Name}
+		if _, err := users.UpdateOne(context.TODO(), bson.M{"_id": user.ID}, bson.M{"$set": after}); err != nil {
+			return nil, err
+		}
+		recordAudit(c, models.AuditUserOIDCSync, "user", user.ID.Hex(), before, after)
+		user.Role, user.Enterprise, user.Email, user.Name = role, enterprise, identity.Email, identity.Name
+	}
+	return &user, nil
+}
+
+// oidcUsername prefers the IdP's username but falls back to a name derived
+// from the subject when it is missing or already used by another account.
+func oidcUsername(identity *middleware.OIDCIdentity) string {
+	candidate := identity.Username
+	if candidate == "" {
+		candidate = identity.Email
+	}
+	if candidate != "" {
+		count, err := db.GetCollection("users").CountDocuments(context.TODO(), bson.M{"username": candidate})
+		if err == nil && count == 0 {
+			return candidate
+		}
+	}
+	return "oidc:" + identity.Subject
+}
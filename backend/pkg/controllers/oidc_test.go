@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// withTokens swaps in a token service for the length of the test.
+func withTokens(t *testing.T) *middleware.TokenService {
+	t.Helper()
+	saved := middleware.Tokens
+	middleware.Tokens = middleware.NewTokenService([]byte("test-secret"), "test", "test-api", time.Minute)
+	t.Cleanup(func() { middleware.Tokens = saved })
+	return middleware.Tokens
+}
+
+func TestOIDCLoginRequiresEnrolledMFA(t *testing.T) {
+	tokens := withTokens(t)
+	user := &models.User{ID: primitive.NewObjectID(), Username: "ada", MFAEnabled: true, OIDCSubject: "user-42"}
+
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		// The IdP vouched for the password only.
+		return completeLogin(c, user, false)
+	})
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+		Token       string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if !body.MFARequired || body.Token != "" {
+		t.Fatalf("response = %+v, want an MFA challenge and no session", body)
+	}
+	subject, err := tokens.ValidateMFAChallenge(body.MFAToken)
+	if err != nil || subject != user.ID.Hex() {
+		t.Errorf("challenge subject = %q, %v, want %s", subject, err, user.ID.Hex())
+	}
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 8
+	// bcrypt silently truncates anything longer than 72 bytes.
+	maxPasswordLength = 72
+	resetTokenTTL     = 30 * time.Minute
+)
+
+var (
+	errWeakPassword = errors.New("password must be between 8 and 72 characters")
+	errNoUser       = errors.New("no active user for this request")
+)
+
+// dummyHash is compared against when the username does not exist so that a
+// failed lookup costs the same as a wrong password.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("timing-equaliser"), bcrypt.DefaultCost)
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return errWeakPassword
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// checkPassword compares in constant time. An empty hash still pays for a
+// full bcrypt comparison.
+func checkPassword(hash, password string) bool {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// newOpaqueToken returns a random URL-safe token and the hex SHA-256 that is
+// persisted in its place.
+func newOpaqueToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, middleware.HashToken(token), nil
+}
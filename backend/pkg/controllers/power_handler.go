@@ -19,6 +19,8 @@ func NewPowerHandler(db *mongo.Database) *PowerHandler {
 	return &PowerHandler{db: db}
 }
 
+// GetRealTimePowerGeneration reports system-wide generation, which is shared
+// by every tenant and therefore not scoped.
 func (h *PowerHandler) GetRealTimePowerGeneration(c *fiber.Ctx) error {
 	opts := options.FindOne().SetSort(bson.M{"timestamp": -1})
 
@@ -47,15 +49,25 @@ func (h *PowerHandler) GetZoneDemandSupply(c *fiber.Ctx) error {
 		})
 	}
 
+	filter, err := scopeByZone(c, h.db, bson.M{"zone_id": zoneID})
+	if err != nil {
+		return tenantError(c, err)
+	}
+
 	opts := options.FindOne().SetSort(bson.M{"timestamp": -1})
 
 	var demandSupply models.PowerDemandSupply
-	err := h.db.Collection("power_demand_supply").FindOne(
+	err = h.db.Collection("power_demand_supply").FindOne(
 		context.Background(),
-		bson.M{"zone_id": zoneID},
+		filter,
 		opts,
 	).Decode(&demandSupply)
 
+	if err == mongo.ErrNoDocuments {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Zone not found",
+		})
+	}
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": "Failed to fetch demand and supply data",
@@ -83,15 +95,20 @@ func (h *PowerHandler) GetPowerForecast(c *fiber.Ctx) error {
 
 	nextDay := date.AddDate(0, 0, 1)
 
+	filter, err := scopeByZone(c, h.db, bson.M{
+		"timestamp": bson.M{
+			"$gte": date,
+			"$lt":  nextDay,
+		},
+	})
+	if err != nil {
+		return tenantError(c, err)
+	}
+
 	var forecasts []models.PowerForecast
 	cursor, err := h.db.Collection("power_forecasts").Find(
 		context.Background(),
-		bson.M{
-			"timestamp": bson.M{
-				"$gte": date,
-				"$lt":  nextDay,
-			},
-		},
+		filter,
 	)
 
 	if err != nil {
@@ -111,10 +128,15 @@ func (h *PowerHandler) GetPowerForecast(c *fiber.Ctx) error {
 
 // GetActiveOutages gets all active outages
 func (h *PowerHandler) GetActiveOutages(c *fiber.Ctx) error {
+	filter, err := scopeByZone(c, h.db, bson.M{"status": "Ongoing"})
+	if err != nil {
+		return tenantError(c, err)
+	}
+
 	var outages []models.Outage
 	cursor, err := h.db.Collection("outages").Find(
 		context.Background(),
-		bson.M{"status": "Ongoing"},
+		filter,
 	)
 
 	if err != nil {
@@ -140,6 +162,10 @@ func (h *PowerHandler) GetIncidents(c *fiber.Ctx) error {
 	if severity != "all" {
 		filter["severity"] = severity
 	}
+	filter, err := scopeByZone(c, h.db, filter)
+	if err != nil {
+		return tenantError(c, err)
+	}
 
 	var incidents []models.Incident
 	cursor, err := h.db.Collection("incidents").Find(
@@ -164,10 +190,15 @@ func (h *PowerHandler) GetIncidents(c *fiber.Ctx) error {
 
 // GetAIRecommendations gets AI-driven recommendations
 func (h *PowerHandler) GetAIRecommendations(c *fiber.Ctx) error {
+	filter, err := scopeByZone(c, h.db, bson.M{})
+	if err != nil {
+		return tenantError(c, err)
+	}
+
 	var recommendations []models.AIRecommendation
 	cursor, err := h.db.Collection("ai_recommendations").Find(
 		context.Background(),
-		bson.M{},
+		filter,
 		options.Find().SetSort(bson.M{"timestamp": -1}).SetLimit(10),
 	)
 
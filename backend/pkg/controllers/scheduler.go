@@ -0,0 +1,402 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const schedulerLeaseID = "grid-balancer"
+
+// BalanceScheduler rebalances grid networks in the background, on each
+// grid's interval or when its imbalance grows past a threshold. Every API
+// replica runs one, but only the replica holding the lease in
+// scheduler_leases does any work.
+type BalanceScheduler struct {
+	handler   *GridDistributionHandler
+	db        *mongo.Database
+	instance  string
+	tick      time.Duration
+	interval  time.Duration
+	jitter    time.Duration
+	threshold float64
+}
+
+// Scheduler is started by SetupBalanceScheduler.
+var Scheduler *BalanceScheduler
+
+// SetupBalanceScheduler reads the scheduler settings from env and starts it
+// in the background unless BALANCE_SCHEDULER=off.
+func SetupBalanceScheduler(database *mongo.Database) {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+
+	Scheduler = &BalanceScheduler{
+		handler:   NewGridDistributionHandler(database),
+		db:        database,
+		instance:  host + "-" + hex.EncodeToString(suffix),
+		tick:      envDuration("BALANCE_SCHEDULER_TICK", 30*time.Second),
+		interval:  envDuration("BALANCE_INTERVAL", 15*time.Minute),
+		jitter:    envDuration("BALANCE_JITTER", time.Minute),
+		threshold: envFloat("BALANCE_IMBALANCE_THRESHOLD", 0),
+	}
+
+	if os.Getenv("BALANCE_SCHEDULER") == "off" {
+		log.Println("Balance scheduler disabled")
+		return
+	}
+	go Scheduler.run(context.Background())
+}
+
+func (s *BalanceScheduler) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.tick + randomDuration(s.tick/5)):
+		}
+
+		leader, err := s.acquireLease(ctx)
+		if err != nil {
+			log.Println("balance scheduler: lease:", err)
+			continue
+		}
+		if leader {
+			s.runDue(ctx)
+		}
+	}
+}
+
+// acquireLease takes or renews the scheduler lease. A lease that is not
+// renewed within three ticks passes to another replica.
+func (s *BalanceScheduler) acquireLease(ctx context.Context) (bool, error) {
+	now := time.Now()
+	err := s.db.Collection("scheduler_leases").FindOneAndUpdate(ctx,
+		bson.M{"_id": schedulerLeaseID, "$or": []bson.M{
+			{"holder": s.instance},
+			{"expires_at": bson.M{"$lt": now}},
+		}},
+		bson.M{"$set": bson.M{"holder": s.instance, "expires_at": now.Add(3 * s.tick)}},
+		options.FindOneAndUpdate().SetUpsert(true),
+	).Err()
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil && err != mongo.ErrNoDocuments {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *BalanceScheduler) runDue(ctx context.Context) {
+	schedules := map[primitive.ObjectID]models.BalanceSchedule{}
+	cursor, err := s.db.Collection("balance_schedules").Find(ctx, bson.M{})
+	if err != nil {
+		log.Println("balance scheduler: schedules:", err)
+		return
+	}
+	var stored []models.BalanceSchedule
+	if err := cursor.All(ctx, &stored); err != nil {
+		log.Println("balance scheduler: schedules:", err)
+		return
+	}
+	for _, sched := range stored {
+		schedules[sched.GridID] = sched
+	}
+
+	cursor, err = s.db.Collection("grid_networks").Find(ctx, bson.M{})
+	if err != nil {
+		log.Println("balance scheduler: grids:", err)
+		return
+	}
+	var grids []models.GridNetwork
+	if err := cursor.All(ctx, &grids); err != nil {
+		log.Println("balance scheduler: grids:", err)
+		return
+	}
+
+	for i := range grids {
+		sched := schedules[grids[i].ID]
+		if sched.Paused {
+			continue
+		}
+		if sched.NextRunAt == nil {
+			first, err := s.scheduleFirstRun(ctx, grids[i].ID, time.Now())
+			if err != nil {
+				log.Println("balance scheduler: first run:", err)
+				continue
+			}
+			sched.NextRunAt = &first
+		}
+		trigger := s.due(&grids[i], sched, time.Now())
+		if trigger == "" {
+			continue
+		}
+		// Runs can be slow; make sure no other replica took over meanwhile.
+		if leader, err := s.acquireLease(ctx); err != nil || !leader {
+			return
+		}
+		s.runGrid(ctx, &grids[i], sched, trigger)
+	}
+}
+
+// scheduleFirstRun stores when a grid that has never run is first due: up
+// to one jitter from now, so grids that appear together, or every grid once
+// the scheduler is first enabled, do not all balance on the same tick. A time
+// another replica stored first is kept.
+func (s *BalanceScheduler) scheduleFirstRun(ctx context.Context, gridID primitive.ObjectID, now time.Time) (time.Time, error) {
+	first := now.Add(randomDuration(s.jitter))
+	var sched models.BalanceSchedule
+	err := s.db.Collection("balance_schedules").FindOneAndUpdate(ctx,
+		bson.M{"_id": gridID},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"next_run_at": bson.M{"$ifNull": bson.A{"$next_run_at", first}}}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&sched)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if sched.NextRunAt == nil {
+		return first, nil
+	}
+	return *sched.NextRunAt, nil
+}
+
+// due returns why grid should be balanced now, or "" if it should not.
+func (s *BalanceScheduler) due(grid *models.GridNetwork, sched models.BalanceSchedule, now time.Time) string {
+	threshold := sched.ImbalanceThreshold
+	if threshold == 0 {
+		threshold = s.threshold
+	}
+	if threshold > 0 && gridImbalance(grid)-sched.ResidualImbalance >= threshold {
+		return models.TriggerImbalance
+	}
+	if sched.NextRunAt == nil || !now.Before(*sched.NextRunAt) {
+		return models.TriggerInterval
+	}
+	return ""
+}
+
+func (s *BalanceScheduler) runGrid(ctx context.Context, grid *models.GridNetwork, sched models.BalanceSchedule, trigger string) {
+	run := models.BalanceRun{
+		GridID:    grid.ID,
+		Trigger:   trigger,
+		Instance:  s.instance,
+		Imbalance: gridImbalance(grid),
+		StartedAt: time.Now(),
+	}
+
+	var plan *models.BalancePlan
+	strategy, _ := balancingStrategy(defaultBalancingStrategy)
+	shedding, ok := sheddingPolicy(grid.SheddingPolicy)
+	err := errors.New("unknown shedding policy " + grid.SheddingPolicy)
+	if ok {
+		plan, err = s.handler.planBalance(ctx, grid, balanceOptions{strategy: strategy, shedding: shedding, escalate: true})
+	}
+	if err == nil {
+		plan.CreatedBy = "scheduler@" + s.instance
+		err = s.handler.applyPlan(ctx, grid, plan, models.SnapshotCauseScheduler, nil)
+	}
+
+	switch {
+	case errors.Is(err, errGridConflict):
+		run.Outcome = models.RunConflict
+	case err != nil:
+		run.Outcome = models.RunFailed
+		run.Error = err.Error()
+	default:
+		run.Outcome = models.RunSucceeded
+		run.Transfers = len(plan.Transfers)
+		run.TotalLoss = plan.TotalLoss
+		if plan.Shedding != nil {
+			run.TotalShed = plan.Shedding.TotalShed
+		}
+	}
+	run.DurationMS = time.Since(run.StartedAt).Milliseconds()
+
+	if _, err := s.db.Collection("balance_runs").InsertOne(ctx, run); err != nil {
+		log.Println("balance scheduler: record run:", err)
+	}
+
+	// A conflict means someone else just wrote the grid; try again next tick.
+	if run.Outcome == models.RunConflict {
+		return
+	}
+	interval := s.interval
+	if sched.IntervalSeconds > 0 {
+		interval = time.Duration(sched.IntervalSeconds) * time.Second
+	}
+	set := bson.M{
+		"last_run_at": run.StartedAt,
+		"next_run_at": time.Now().Add(interval + randomDuration(s.jitter)),
+	}
+	if run.Outcome == models.RunSucceeded {
+		set["residual_imbalance"] = gridImbalance(grid)
+	}
+	_, err = s.db.Collection("balance_schedules").UpdateOne(ctx,
+		bson.M{"_id": grid.ID}, bson.M{"$set": set}, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Println("balance scheduler: update schedule:", err)
+	}
+}
+
+// gridImbalance is the total gap between demand and allocation, in MW.
+// Demand is net of the node's own generation, and export that could not be
+// used is curtailment rather than imbalance.
+func gridImbalance(grid *models.GridNetwork) float64 {
+	total := 0.0
+	for _, node := range grid.ChildNodes {
+		demand, _ := netDemand(node, generationOutput(node))
+		total += math.Abs(math.Max(demand, 0) - math.Max(node.AllocatedPower, 0))
+	}
+	return total
+}
+
+func randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0
+	}
+	return time.Duration(n.Int64())
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		log.Printf("Error parsing %s=%q, using %s", key, raw, fallback)
+		return fallback
+	}
+	return d
+}
+
+func envFloat(key string, fallback float64) float64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil || f < 0 {
+		log.Printf("Error parsing %s=%q, using %g", key, raw, fallback)
+		return fallback
+	}
+	return f
+}
+
+func (h *GridDistributionHandler) GetBalanceSchedule(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	sched := models.BalanceSchedule{GridID: grid.ID}
+	err = h.db.Collection("balance_schedules").FindOne(c.Context(), bson.M{"_id": grid.ID}).Decode(&sched)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not fetch schedule"})
+	}
+	return c.JSON(sched)
+}
+
+// UpdateBalanceSchedule sets a grid's interval and imbalance threshold.
+// Zero means the scheduler-wide default.
+func (h *GridDistributionHandler) UpdateBalanceSchedule(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	var req models.BalanceScheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid input"})
+	}
+	if req.IntervalSeconds < 0 || req.ImbalanceThreshold < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "interval_seconds and imbalance_threshold must not be negative"})
+	}
+
+	// Drop next_run_at so the new interval applies from the next tick.
+	return h.updateSchedule(c, grid, models.AuditScheduleUpdate, bson.M{
+		"$set":   bson.M{"interval_seconds": req.IntervalSeconds, "imbalance_threshold": req.ImbalanceThreshold},
+		"$unset": bson.M{"next_run_at": ""},
+	})
+}
+
+func (h *GridDistributionHandler) PauseBalanceSchedule(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	principal, _ := middleware.GetPrincipal(c)
+	return h.updateSchedule(c, grid, models.AuditSchedulePause, bson.M{
+		"$set": bson.M{"paused": true, "paused_by": principal.Username, "paused_at": time.Now()},
+	})
+}
+
+func (h *GridDistributionHandler) ResumeBalanceSchedule(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	return h.updateSchedule(c, grid, models.AuditScheduleResume, bson.M{
+		"$set":   bson.M{"paused": false},
+		"$unset": bson.M{"paused_by": "", "paused_at": ""},
+	})
+}
+
+func (h *GridDistributionHandler) updateSchedule(c *fiber.Ctx, grid *models.GridNetwork, action string, update bson.M) error {
+	var previous, sched models.BalanceSchedule
+	err := h.db.Collection("balance_schedules").FindOneAndUpdate(c.Context(),
+		bson.M{"_id": grid.ID}, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before),
+	).Decode(&previous)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not update schedule"})
+	}
+	if err := h.db.Collection("balance_schedules").FindOne(c.Context(), bson.M{"_id": grid.ID}).Decode(&sched); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not update schedule"})
+	}
+
+	recordAudit(c, action, "grid_network", grid.ID.Hex(), previous, sched)
+	return c.JSON(sched)
+}
+
+// GetBalanceRuns pages through a grid's scheduled runs, newest first.
+func (h *GridDistributionHandler) GetBalanceRuns(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	page, limit, msg := pageParams(c)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	opts := options.Find().SetSort(bson.M{"started_at": -1}).SetSkip((page - 1) * limit).SetLimit(limit)
+	cursor, err := h.db.Collection("balance_runs").Find(c.Context(), bson.M{"grid_id": grid.ID}, opts)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not fetch runs"})
+	}
+	runs := []models.BalanceRun{}
+	if err := cursor.All(c.Context(), &runs); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not fetch runs"})
+	}
+	return c.JSON(runs)
+}
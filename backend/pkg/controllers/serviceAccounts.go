@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func CreateServiceAccount(c *fiber.Ctx) error {
+	var account models.ServiceAccount
+	if err := c.BodyParser(&account); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if account.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Name required"})
+	}
+	if msg := checkGrantableScopes(c, account.Scopes); msg != "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": msg})
+	}
+
+	// Accounts act for their creator's enterprise. Only a cross-tenant
+	// administrator may create one for another enterprise.
+	enterprise, crossTenant, err := callerTenant(c)
+	if err != nil {
+		return tenantError(c, err)
+	}
+	if !crossTenant {
+		account.Enterprise = enterprise
+	}
+
+	principal, _ := middleware.GetPrincipal(c)
+	account.CreatedBy = principal.Username
+	account.Disabled = false
+
+	if err := middleware.APIKeys.CreateAccount(c.Context(), &account); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create service account"})
+	}
+	recordAudit(c, models.AuditServiceAccountCreate, "service_account", account.ID.Hex(), nil, account)
+	return c.Status(fiber.StatusCreated).JSON(account)
+}
+
+func GetServiceAccounts(c *fiber.Ctx) error {
+	accounts, err := middleware.APIKeys.ListAccounts(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch service accounts"})
+	}
+	return c.JSON(accounts)
+}
+
+// CreateAPIKey issues a key for a service account. Without explicit scopes
+// the key gets all of the account's scopes.
+func CreateAPIKey(c *fiber.Ctx) error {
+	accountID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid service account ID"})
+	}
+
+	var req models.APIKeyRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+		}
+	}
+
+	account, err := middleware.APIKeys.Account(c.Context(), accountID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Service account not found"})
+	}
+
+	scopes := req.Scopes
+	if len(scopes) == 0 {
+		scopes = account.Scopes
+	}
+	for _, scope := range scopes {
+		if !containsString(account.Scopes, scope) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Scope not allowed for this service account: " + scope})
+		}
+	}
+	if msg := checkGrantableScopes(c, scopes); msg != "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": msg})
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	raw, key, err := middleware.APIKeys.Issue(c.Context(), account.ID, scopes, expiresAt, nil)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create API key"})
+	}
+	recordAudit(c, models.AuditAPIKeyCreate, "api_key", key.ID.Hex(), nil, key)
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"key": raw, "api_key": key})
+}
+
+func GetAPIKeys(c *fiber.Ctx) error {
+	accountID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid service account ID"})
+	}
+
+	keys, err := middleware.APIKeys.ListKeys(c.Context(), accountID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch API keys"})
+	}
+	return c.JSON(keys)
+}
+
+// RotateAPIKey replaces a key with a new one carrying the same scopes and
+// expiry, and revokes the old key.
+func RotateAPIKey(c *fiber.Ctx) error {
+	keyID, err := primitive.ObjectIDFromHex(c.Params("keyId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid API key ID"})
+	}
+
+	old, err := middleware.APIKeys.Key(c.Context(), keyID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "API key not found"})
+	}
+	if old.RevokedAt != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "API key already revoked"})
+	}
+	if msg := checkGrantableScopes(c, old.Scopes); msg != "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": msg})
+	}
+
+	raw, key, err := middleware.APIKeys.Issue(c.Context(), old.ServiceAccountID, old.Scopes, old.ExpiresAt, &old.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not rotate API key"})
+	}
+	if _, err := middleware.APIKeys.Revoke(c.Context(), old.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not revoke old API key"})
+	}
+	recordAudit(c, models.AuditAPIKeyRotate, "api_key", old.ID.Hex(), old, key)
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"key": raw, "api_key": key})
+}
+
+func RevokeAPIKey(c *fiber.Ctx) error {
+	keyID, err := primitive.ObjectIDFromHex(c.Params("keyId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid API key ID"})
+	}
+
+	revoked, err := middleware.APIKeys.Revoke(c.Context(), keyID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not revoke API key"})
+	}
+	if !revoked {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "API key not found or already revoked"})
+	}
+	recordAudit(c, models.AuditAPIKeyRevoke, "api_key", keyID.Hex(), nil, nil)
+	return c.JSON(fiber.Map{"message": "API key revoked"})
+}
+
+// checkGrantableScopes returns an error message if any scope is unknown or
+// not held by the caller, so keys can never outrank their creator.
+func checkGrantableScopes(c *fiber.Ctx, scopes []string) string {
+	_, permissions, err := middleware.Roles.List(context.TODO())
+	if err != nil {
+		return "Could not resolve permissions"
+	}
+	known := make(map[string]bool, len(permissions))
+	for _, p := range permissions {
+		known[p.Name] = true
+	}
+
+	principal, _ := middleware.GetPrincipal(c)
+	held, err := middleware.PrincipalPermissions(c.Context(), principal)
+	if err != nil {
+		return "Could not resolve permissions"
+	}
+
+	for _, scope := range scopes {
+		if !known[scope] {
+			return "Unknown scope: " + scope
+		}
+		if !held[scope] {
+			return "Cannot grant a scope you do not hold: " + scope
+		}
+	}
+	return ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
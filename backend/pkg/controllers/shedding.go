@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SheddingPolicy decides how much of each node's demand is served when the
+// grid cannot carry all of it. demand[i] is what grid.ChildNodes[i] can take;
+// the served amounts returned must not exceed it and must sum to at most
+// capacity. reasons[i] explains the decision for the schedule.
+type SheddingPolicy interface {
+	Name() string
+	Shed(nodes []models.ChildNode, demand []float64, capacity float64, history map[primitive.ObjectID]models.SheddingHistory) (served []float64, reasons []string)
+}
+
+var sheddingPolicies = map[string]SheddingPolicy{
+	models.SheddingProportional: ProportionalShedding{},
+	models.SheddingPriority:     PriorityShedding{},
+	models.SheddingRolling:      RollingShedding{},
+}
+
+func sheddingPolicy(name string) (SheddingPolicy, bool) {
+	if name == "" {
+		name = models.SheddingProportional
+	}
+	p, ok := sheddingPolicies[name]
+	return p, ok
+}
+
+// requestShedding resolves the policy for a balancing request: the shedding
+// query parameter, else the grid's configured policy.
+func requestShedding(c *fiber.Ctx, grid *models.GridNetwork) (SheddingPolicy, bool) {
+	return sheddingPolicy(c.Query("shedding", grid.SheddingPolicy))
+}
+
+// ProportionalShedding cuts every node by the same fraction.
+type ProportionalShedding struct{}
+
+func (ProportionalShedding) Name() string { return models.SheddingProportional }
+
+func (ProportionalShedding) Shed(nodes []models.ChildNode, demand []float64, capacity float64, _ map[primitive.ObjectID]models.SheddingHistory) ([]float64, []string) {
+	total := 0.0
+	for _, d := range demand {
+		total += d
+	}
+	ratio := 0.0
+	if total > 0 {
+		ratio = math.Min(capacity/total, 1)
+	}
+
+	served := make([]float64, len(nodes))
+	reasons := make([]string, len(nodes))
+	for i := range nodes {
+		served[i] = demand[i] * ratio
+		reasons[i] = fmt.Sprintf("proportional share %.1f%%", ratio*100)
+	}
+	return served, reasons
+}
+
+// PriorityShedding serves whole priority classes in order. The first class
+// that does not fit is shared proportionally and every later class is shed.
+type PriorityShedding struct{}
+
+func (PriorityShedding) Name() string { return models.SheddingPriority }
+
+func (PriorityShedding) Shed(nodes []models.ChildNode, demand []float64, capacity float64, _ map[primitive.ObjectID]models.SheddingHistory) ([]float64, []string) {
+	tiers := map[int][]int{}
+	var order []int
+	for i, node := range nodes {
+		tier := priorityRank(node)
+		if _, ok := tiers[tier]; !ok {
+			order = append(order, tier)
+		}
+		tiers[tier] = append(tiers[tier], i)
+	}
+	sort.Ints(order)
+
+	served := make([]float64, len(nodes))
+	reasons := make([]string, len(nodes))
+	remaining := math.Max(capacity, 0)
+	for _, tier := range order {
+		need := 0.0
+		for _, i := range tiers[tier] {
+			need += demand[i]
+		}
+
+		ratio, reason := 1.0, "served in full"
+		switch {
+		case need <= remaining:
+		case remaining > 0:
+			ratio = remaining / need
+			reason = fmt.Sprintf("shared %.1f%% of remaining capacity", ratio*100)
+		default:
+			ratio, reason = 0, "shed, no capacity left for this class"
+		}
+		for _, i := range tiers[tier] {
+			served[i] = demand[i] * ratio
+			reasons[i] = priorityClass(nodes[i]) + " class " + reason
+		}
+		remaining = math.Max(remaining-need*ratio, 0)
+	}
+	return served, reasons
+}
+
+func priorityClass(node models.ChildNode) string {
+	if node.PriorityClass == "" {
+		return models.PriorityNormal
+	}
+	return node.PriorityClass
+}
+
+func priorityRank(node models.ChildNode) int {
+	if rank, ok := models.PriorityRanks[priorityClass(node)]; ok {
+		return rank
+	}
+	return models.PriorityRanks[models.PriorityNormal]
+}
+
+// shedByPriority applies the rules every policy must honor before the policy
+// gets a say. Minimum guarantees are met first, by class if even they do not
+// fit. Critical firm loads are then served, then other firm loads, then
+// interruptible loads; the policy only splits the stage capacity runs out in.
+func shedByPriority(nodes []models.ChildNode, demand []float64, capacity float64, policy SheddingPolicy, history map[primitive.ObjectID]models.SheddingHistory) (served []float64, rules, reasons []string) {
+	n := len(nodes)
+	rules = make([]string, n)
+	reasons = make([]string, n)
+	remaining := math.Max(capacity, 0)
+
+	guarantee := make([]float64, n)
+	for i, node := range nodes {
+		guarantee[i] = math.Min(math.Max(node.MinGuaranteed, 0), demand[i])
+	}
+	served, _ = PriorityShedding{}.Shed(nodes, guarantee, remaining, nil)
+	for i := range nodes {
+		remaining -= served[i]
+	}
+	remaining = math.Max(remaining, 0)
+
+	var stages [3][]int
+	for i, node := range nodes {
+		switch {
+		case node.Interruptible:
+			stages[2] = append(stages[2], i)
+		case priorityClass(node) == models.PriorityCritical:
+			stages[0] = append(stages[0], i)
+		default:
+			stages[1] = append(stages[1], i)
+		}
+	}
+
+	for _, members := range stages {
+		if len(members) == 0 {
+			continue
+		}
+		subNodes := make([]models.ChildNode, len(members))
+		rest := make([]float64, len(members))
+		need := 0.0
+		for k, i := range members {
+			subNodes[k] = nodes[i]
+			rest[k] = demand[i] - served[i]
+			need += rest[k]
+		}
+
+		extra, policyReasons := rest, make([]string, len(members))
+		if need > remaining {
+			extra, policyReasons = policy.Shed(subNodes, rest, remaining, history)
+		}
+		for k, i := range members {
+			served[i] += extra[k]
+			remaining -= extra[k]
+			rules[i], reasons[i] = priorityDecision(nodes[i], demand[i], guarantee[i], served[i], policy, policyReasons[k])
+		}
+		remaining = math.Max(remaining, 0)
+	}
+	return served, rules, reasons
+}
+
+// priorityDecision names the rule that settled a node's allocation.
+func priorityDecision(node models.ChildNode, demand, guarantee, served float64, policy SheddingPolicy, policyReason string) (string, string) {
+	switch {
+	case served >= demand-violationTolerance && priorityClass(node) == models.PriorityCritical:
+		return models.RuleCriticalProtected, "critical load served in full"
+	case served >= demand-violationTolerance:
+		return models.RulePriorityClass, priorityClass(node) + " class served in full"
+	case guarantee > 0 && served <= guarantee+violationTolerance:
+		return models.RuleMinGuarantee, fmt.Sprintf("held at minimum guarantee of %.3f MW", guarantee)
+	case node.Interruptible:
+		return models.RuleInterruptibleFirst, "interruptible load shed before firm load: " + policyReason
+	case policy.Name() == models.SheddingPriority:
+		return models.RulePriorityClass, policyReason
+	}
+	return models.RuleSheddingPolicy, policyReason
+}
+
+// RollingShedding blacks out whole nodes, starting with those that have
+// lost the least load in earlier runs, so outages rotate across the grid.
+type RollingShedding struct{}
+
+func (RollingShedding) Name() string { return models.SheddingRolling }
+
+func (RollingShedding) Shed(nodes []models.ChildNode, demand []float64, capacity float64, history map[primitive.ObjectID]models.SheddingHistory) ([]float64, []string) {
+	served := append([]float64(nil), demand...)
+	reasons := make([]string, len(nodes))
+
+	excess := -math.Max(capacity, 0)
+	order := make([]int, len(nodes))
+	for i := range nodes {
+		excess += demand[i]
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ha, hb := history[nodes[order[a]].ID], history[nodes[order[b]].ID]
+		if ha.TotalShed != hb.TotalShed {
+			return ha.TotalShed < hb.TotalShed
+		}
+		return ha.LastShedAt.Before(hb.LastShedAt)
+	})
+
+	for _, i := range order {
+		h := history[nodes[i].ID]
+		if excess <= 0 {
+			reasons[i] = fmt.Sprintf("spared this rotation (%.3f MW shed over %d earlier runs)", h.TotalShed, h.Events)
+			continue
+		}
+		cut := math.Min(excess, demand[i])
+		served[i] -= cut
+		excess -= cut
+		if served[i] <= 0 {
+			reasons[i] = fmt.Sprintf("rolling blackout (%.3f MW shed over %d earlier runs)", h.TotalShed, h.Events)
+		} else {
+			reasons[i] = fmt.Sprintf("partial rolling cut (%.3f MW shed over %d earlier runs)", h.TotalShed, h.Events)
+		}
+	}
+	return served, reasons
+}
+
+func (h *GridDistributionHandler) loadSheddingHistory(ctx context.Context, gridID primitive.ObjectID) (map[primitive.ObjectID]models.SheddingHistory, error) {
+	cursor, err := h.db.Collection("shedding_history").Find(ctx, bson.M{"grid_id": gridID})
+	if err != nil {
+		return nil, err
+	}
+	var entries []models.SheddingHistory
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	history := make(map[primitive.ObjectID]models.SheddingHistory, len(entries))
+	for _, e := range entries {
+		history[e.NodeID] = e
+	}
+	return history, nil
+}
+
+// recordShedding stores a run's schedule and adds its cuts to each node's
+// history. It runs inside the balancing transaction.
+func (h *GridDistributionHandler) recordShedding(sc mongo.SessionContext, schedule *models.SheddingSchedule) error {
+	if _, err := h.db.Collection("shedding_schedules").InsertOne(sc, schedule); err != nil {
+		return fmt.Errorf("record shedding schedule: %w", err)
+	}
+	for _, e := range schedule.Entries {
+		if e.Shed <= violationTolerance {
+			continue
+		}
+		_, err := h.db.Collection("shedding_history").UpdateOne(sc,
+			bson.M{"grid_id": schedule.GridID, "node_id": e.NodeID},
+			bson.M{
+				"$inc": bson.M{"total_shed": e.Shed, "events": 1},
+				"$set": bson.M{"last_shed_at": schedule.CreatedAt},
+			},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return fmt.Errorf("update shedding history: %w", err)
+		}
+	}
+	return nil
+}
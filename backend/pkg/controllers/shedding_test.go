@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func sheddingNodes(classes ...string) []models.ChildNode {
+	nodes := make([]models.ChildNode, len(classes))
+	for i, class := range classes {
+		nodes[i] = models.ChildNode{ID: primitive.NewObjectID(), PriorityClass: class}
+	}
+	return nodes
+}
+
+func TestProportionalShedding(t *testing.T) {
+	nodes := sheddingNodes("", "")
+	served, _ := ProportionalShedding{}.Shed(nodes, []float64{30, 10}, 20, nil)
+	if !closeTo(served, []float64{15, 5}) {
+		t.Errorf("served %v, want [15 5]", served)
+	}
+	served, _ = ProportionalShedding{}.Shed(nodes, []float64{30, 10}, 100, nil)
+	if !closeTo(served, []float64{30, 10}) {
+		t.Errorf("served %v with spare capacity, want demand", served)
+	}
+}
+
+func TestPriorityShedding(t *testing.T) {
+	nodes := sheddingNodes(models.PriorityLow, models.PriorityCritical, models.PriorityNormal, models.PriorityNormal)
+	served, reasons := PriorityShedding{}.Shed(nodes, []float64{30, 20, 20, 10}, 35, nil)
+	// Critical first, then the normal class shares the 15 MW left; low
+	// gets nothing.
+	if !closeTo(served, []float64{0, 20, 10, 5}) {
+		t.Errorf("served %v, want [0 20 10 5]", served)
+	}
+	if reasons[0] != "low class shed, no capacity left for this class" {
+		t.Errorf("low class reason %q", reasons[0])
+	}
+}
+
+func TestRollingSheddingRotates(t *testing.T) {
+	nodes := sheddingNodes("", "", "")
+	now := time.Now()
+	history := map[primitive.ObjectID]models.SheddingHistory{
+		nodes[0].ID: {TotalShed: 10, Events: 1, LastShedAt: now},
+		nodes[1].ID: {LastShedAt: now.Add(-2 * time.Hour)},
+		nodes[2].ID: {LastShedAt: now.Add(-time.Hour)},
+	}
+	// 30 MW over: the node shed longest ago goes dark, the next one is cut
+	// partly and the node shed last run is spared.
+	served, _ := RollingShedding{}.Shed(nodes, []float64{20, 20, 20}, 30, history)
+	if !closeTo(served, []float64{20, 0, 10}) {
+		t.Errorf("served %v, want [20 0 10]", served)
+	}
+}
+
+func TestShedByPriorityStages(t *testing.T) {
+	nodes := sheddingNodes(models.PriorityCritical, models.PriorityNormal, models.PriorityHigh)
+	nodes[1].MinGuaranteed = 5
+	nodes[2].Interruptible = true
+	demand := []float64{10, 20, 20}
+
+	for _, policy := range []SheddingPolicy{ProportionalShedding{}, PriorityShedding{}, RollingShedding{}} {
+		served, rules, _ := shedByPriority(nodes, demand, 25, policy, nil)
+		// The guarantee and the critical load come first; the firm node
+		// takes what is left and the interruptible one is shed although
+		// its class ranks higher.
+		if !closeTo(served, []float64{10, 15, 0}) {
+			t.Errorf("%s: served %v, want [10 15 0]", policy.Name(), served)
+		}
+		if rules[0] != models.RuleCriticalProtected || rules[2] != models.RuleInterruptibleFirst {
+			t.Errorf("%s: rules %v", policy.Name(), rules)
+		}
+	}
+
+	// Below the guarantees, only the guarantees are shared, by class.
+	served, rules, _ := shedByPriority(nodes, demand, 3, ProportionalShedding{}, nil)
+	if !closeTo(served, []float64{0, 3, 0}) {
+		t.Errorf("served %v below the guarantees, want [0 3 0]", served)
+	}
+	if rules[1] != models.RuleMinGuarantee {
+		t.Errorf("guaranteed node settled by %q", rules[1])
+	}
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"errors"
+	"math"
+)
+
+const simplexEpsilon = 1e-9
+
+var (
+	errLPUnbounded    = errors.New("linear program is unbounded")
+	errLPIterationCap = errors.New("linear program did not converge within the iteration limit")
+)
+
+// maximizeLP solves: maximize c·x subject to A·x <= b, x >= 0, with b >= 0.
+// Because b is non-negative the origin is feasible and a single-phase
+// tableau simplex suffices. Bland's rule avoids cycling; a solve that still
+// runs past 50*(n+m) pivots fails with errLPIterationCap.
+func maximizeLP(c []float64, A [][]float64, b []float64) ([]float64, error) {
+	m, n := len(A), len(c)
+	if m == 0 || n == 0 {
+		return make([]float64, n), nil
+	}
+
+	// Tableau: m constraint rows plus the objective row, n decision
+	// columns, m slack columns and the right-hand side.
+	width := n + m + 1
+	t := make([][]float64, m+1)
+	for i := 0; i < m; i++ {
+		t[i] = make([]float64, width)
+		copy(t[i], A[i])
+		t[i][n+i] = 1
+		t[i][width-1] = b[i]
+	}
+	t[m] = make([]float64, width)
+	for j := 0; j < n; j++ {
+		t[m][j] = -c[j]
+	}
+
+	basis := make([]int, m)
+	for i := range basis {
+		basis[i] = n + i
+	}
+
+	for iter := 0; ; iter++ {
+		// Entering column: lowest index with a negative reduced cost.
+		col := -1
+		for j := 0; j < width-1; j++ {
+			if t[m][j] < -simplexEpsilon {
+				col = j
+				break
+			}
+		}
+		if col < 0 {
+			break
+		}
+		if iter == 50*(n+m) {
+			return nil, errLPIterationCap
+		}
+
+		// Leaving row: minimum ratio, ties broken by lowest basis index.
+		row := -1
+		best := math.Inf(1)
+		for i := 0; i < m; i++ {
+			if t[i][col] <= simplexEpsilon {
+				continue
+			}
+			ratio := t[i][width-1] / t[i][col]
+			if ratio < best-simplexEpsilon || (math.Abs(ratio-best) <= simplexEpsilon && basis[i] < basis[row]) {
+				best = ratio
+				row = i
+			}
+		}
+		if row < 0 {
+			return nil, errLPUnbounded
+		}
+
+		pivot := t[row][col]
+		for j := range t[row] {
+			t[row][j] /= pivot
+		}
+		for i := 0; i <= m; i++ {
+			if i == row || t[i][col] == 0 {
+				continue
+			}
+			factor := t[i][col]
+			for j := range t[i] {
+				t[i][j] -= factor * t[row][j]
+			}
+		}
+		basis[row] = col
+	}
+
+	x := make([]float64, n)
+	for i, v := range basis {
+		if v < n {
+			x[v] = math.Max(t[i][width-1], 0)
+		}
+	}
+	return x, nil
+}
@@ -0,0 +1,335 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// snapshotStored records the grid as stored inside a transaction, with the
+// cause and provenance given in meta.
+func (h *GridDistributionHandler) snapshotStored(sc mongo.SessionContext, gridID primitive.ObjectID, meta models.GridSnapshot) error {
+	var state models.GridNetwork
+	if err := h.db.Collection("grid_networks").FindOne(sc, bson.M{"_id": gridID}).Decode(&state); err != nil {
+		return fmt.Errorf("read grid for snapshot: %w", err)
+	}
+	meta.GridID = state.ID
+	meta.Enterprise = state.Enterprise
+	meta.Version = state.Version
+	meta.State = &state
+	if _, err := h.db.Collection("grid_snapshots").InsertOne(sc, meta); err != nil {
+		return fmt.Errorf("record grid snapshot: %w", err)
+	}
+	return nil
+}
+
+// editGrid runs write, an edit of grid by hand, in one transaction with the
+// snapshot of its result and audit, the event describing it, so no edit is
+// stored without its snapshot and audit trail. write
+// returns errGridConflict when grid changed since it was read. A deleted
+// grid has nothing left to read back; its last state is recorded instead,
+// marked deleted.
+func (h *GridDistributionHandler) editGrid(c *fiber.Ctx, grid *models.GridNetwork, audit models.AuditEvent, deleted bool, write func(sc mongo.SessionContext) error) error {
+	session, err := h.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(c.Context())
+
+	meta := models.GridSnapshot{
+		Cause:   models.SnapshotCauseManual,
+		Action:  audit.Action,
+		TakenAt: time.Now(),
+	}
+	if principal, ok := middleware.GetPrincipal(c); ok {
+		meta.Actor = principal.Username
+	}
+
+	_, err = session.WithTransaction(c.Context(), func(sc mongo.SessionContext) (interface{}, error) {
+		if err := write(sc); err != nil {
+			return nil, err
+		}
+		if err := insertAudit(sc, h.db.Collection("audit_events"), audit); err != nil {
+			logAuditFailure(audit, err)
+			return nil, fmt.Errorf("record audit event: %w", err)
+		}
+		if !deleted {
+			return nil, h.snapshotStored(sc, grid.ID, meta)
+		}
+		state := *grid
+		state.Version++
+		meta.GridID = state.ID
+		meta.Enterprise = state.Enterprise
+		meta.Version = state.Version
+		meta.Deleted = true
+		meta.State = &state
+		if _, err := h.db.Collection("grid_snapshots").InsertOne(sc, meta); err != nil {
+			return nil, fmt.Errorf("record grid snapshot: %w", err)
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// versionedUpdate is an editGrid write applying update to the grid document
+// filter matches. filter pins the version read, so no match is a conflict.
+func (h *GridDistributionHandler) versionedUpdate(filter, update bson.M) func(sc mongo.SessionContext) error {
+	return func(sc mongo.SessionContext) error {
+		res, err := h.db.Collection("grid_networks").UpdateOne(sc, filter, update)
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return errGridConflict
+		}
+		return nil
+	}
+}
+
+// seedGridSnapshots gives every grid without snapshots a baseline one, so
+// the state before its first recorded change can be looked up too.
+func seedGridSnapshots(ctx context.Context, database *mongo.Database) error {
+	snapshotted, err := database.Collection("grid_snapshots").Distinct(ctx, "grid_id", bson.M{})
+	if err != nil {
+		return err
+	}
+	cursor, err := database.Collection("grid_networks").Find(ctx, bson.M{"_id": bson.M{"$nin": snapshotted}})
+	if err != nil {
+		return err
+	}
+	var grids []models.GridNetwork
+	if err := cursor.All(ctx, &grids); err != nil {
+		return err
+	}
+	for i := range grids {
+		taken := grids[i].LastBalanced
+		if taken.IsZero() {
+			taken = grids[i].ID.Timestamp()
+		}
+		_, err := database.Collection("grid_snapshots").InsertOne(ctx, models.GridSnapshot{
+			GridID:     grids[i].ID,
+			Enterprise: grids[i].Enterprise,
+			Version:    grids[i].Version,
+			Cause:      models.SnapshotCauseBaseline,
+			TakenAt:    taken,
+			State:      &grids[i],
+		})
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotFilter scopes a grid_snapshots query to the gridId route parameter
+// and the caller's tenant. It does not need the grid to still exist.
+func snapshotFilter(c *fiber.Ctx) (bson.M, error) {
+	gridID, err := primitive.ObjectIDFromHex(c.Params("gridId"))
+	if err != nil {
+		return nil, errInvalidGridID
+	}
+	return scopeByEnterprise(c, bson.M{"grid_id": gridID})
+}
+
+// GetGridSnapshots pages through a grid's snapshots, newest first, without
+// their state. They can be filtered by cause and time range.
+func (h *GridDistributionHandler) GetGridSnapshots(c *fiber.Ctx) error {
+	filter, err := snapshotFilter(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	if cause := c.Query("cause"); cause != "" {
+		filter["cause"] = cause
+	}
+	from, to, msg := queryTimeRange(c)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	timeRangeFilter(filter, "taken_at", from, to)
+	page, limit, msg := pageParams(c)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	collection := h.db.Collection("grid_snapshots")
+	total, err := collection.CountDocuments(c.Context(), filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not fetch grid snapshots"})
+	}
+	opts := options.Find().SetSort(bson.M{"version": -1}).SetProjection(bson.M{"state": 0}).
+		SetSkip((page - 1) * limit).SetLimit(limit)
+	cursor, err := collection.Find(c.Context(), filter, opts)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not fetch grid snapshots"})
+	}
+	snapshots := []models.GridSnapshot{}
+	if err := cursor.All(c.Context(), &snapshots); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not fetch grid snapshots"})
+	}
+	return c.JSON(models.Page{Items: snapshots, Total: total, Page: page, Limit: limit})
+}
+
+func (h *GridDistributionHandler) GetGridSnapshot(c *fiber.Ctx) error {
+	filter, err := snapshotFilter(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	version, err := strconv.ParseInt(c.Params("version"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid version"})
+	}
+	snapshot, err := h.findSnapshot(c.Context(), filter, version)
+	if err != nil {
+		return snapshotError(c, err)
+	}
+	return c.JSON(snapshot)
+}
+
+// GetGridStateAt returns the snapshot in force at the given time: the last
+// one taken at or before it.
+func (h *GridDistributionHandler) GetGridStateAt(c *fiber.Ctx) error {
+	filter, err := snapshotFilter(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	at, err := time.Parse(time.RFC3339, c.Query("at"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid at time, expected RFC 3339"})
+	}
+	filter["taken_at"] = bson.M{"$lte": at}
+
+	var snapshot models.GridSnapshot
+	opts := options.FindOne().SetSort(bson.D{{Key: "taken_at", Value: -1}, {Key: "version", Value: -1}})
+	err = h.db.Collection("grid_snapshots").FindOne(c.Context(), filter, opts).Decode(&snapshot)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return c.Status(404).JSON(fiber.Map{"error": "No snapshot at or before that time"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not fetch grid snapshot"})
+	}
+	return c.JSON(snapshot)
+}
+
+// DiffGridSnapshots compares the snapshots of two versions of a grid.
+func (h *GridDistributionHandler) DiffGridSnapshots(c *fiber.Ctx) error {
+	filter, err := snapshotFilter(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	fromVersion, err1 := strconv.ParseInt(c.Query("from"), 10, 64)
+	toVersion, err2 := strconv.ParseInt(c.Query("to"), 10, 64)
+	if err1 != nil || err2 != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "from and to must be grid versions"})
+	}
+
+	from, err := h.findSnapshot(c.Context(), filter, fromVersion)
+	if err != nil {
+		return snapshotError(c, err)
+	}
+	to, err := h.findSnapshot(c.Context(), filter, toVersion)
+	if err != nil {
+		return snapshotError(c, err)
+	}
+	return c.JSON(diffGridSnapshots(from, to))
+}
+
+func (h *GridDistributionHandler) findSnapshot(ctx context.Context, filter bson.M, version int64) (*models.GridSnapshot, error) {
+	query := bson.M{"version": version}
+	for k, v := range filter {
+		query[k] = v
+	}
+	var snapshot models.GridSnapshot
+	if err := h.db.Collection("grid_snapshots").FindOne(ctx, query).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+func snapshotError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return c.Status(404).JSON(fiber.Map{"error": "Snapshot not found"})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": "Could not fetch grid snapshot"})
+}
+
+// diffGridSnapshots compares two snapshots field by field, with nodes and
+// lines matched by ID.
+func diffGridSnapshots(from, to *models.GridSnapshot) models.GridDiff {
+	diff := models.GridDiff{
+		GridID:      to.GridID,
+		FromVersion: from.Version,
+		ToVersion:   to.Version,
+		Nodes:       []models.ElementDiff{},
+		Lines:       []models.ElementDiff{},
+	}
+	before, after := toAuditDoc(from.State), toAuditDoc(to.State)
+	for _, field := range []string{"child_nodes", "lines"} {
+		delete(before, field)
+		delete(after, field)
+	}
+	diff.Fields = diffAuditDocs(before, after)
+
+	var fromState, toState models.GridNetwork
+	if from.State != nil {
+		fromState = *from.State
+	}
+	if to.State != nil {
+		toState = *to.State
+	}
+
+	oldNodes := map[primitive.ObjectID]models.ChildNode{}
+	for _, node := range fromState.ChildNodes {
+		oldNodes[node.ID] = node
+	}
+	for _, node := range toState.ChildNodes {
+		old, ok := oldNodes[node.ID]
+		delete(oldNodes, node.ID)
+		if change, changed := diffElement(node.ID, node.Name, old, node, ok); changed {
+			diff.Nodes = append(diff.Nodes, change)
+		}
+	}
+	for _, node := range fromState.ChildNodes {
+		if _, gone := oldNodes[node.ID]; gone {
+			diff.Nodes = append(diff.Nodes, models.ElementDiff{ID: node.ID, Name: node.Name, Change: "removed"})
+		}
+	}
+
+	oldLines := map[primitive.ObjectID]models.GridLine{}
+	for _, line := range fromState.Lines {
+		oldLines[line.ID] = line
+	}
+	for _, line := range toState.Lines {
+		old, ok := oldLines[line.ID]
+		delete(oldLines, line.ID)
+		if change, changed := diffElement(line.ID, "", old, line, ok); changed {
+			diff.Lines = append(diff.Lines, change)
+		}
+	}
+	for _, line := range fromState.Lines {
+		if _, gone := oldLines[line.ID]; gone {
+			diff.Lines = append(diff.Lines, models.ElementDiff{ID: line.ID, Change: "removed"})
+		}
+	}
+	return diff
+}
+
+func diffElement(id primitive.ObjectID, name string, before, after interface{}, existed bool) (models.ElementDiff, bool) {
+	if !existed {
+		return models.ElementDiff{ID: id, Name: name, Change: "added"}, true
+	}
+	fields := diffAuditDocs(toAuditDoc(before), toAuditDoc(after))
+	if len(fields) == 0 {
+		return models.ElementDiff{}, false
+	}
+	return models.ElementDiff{ID: id, Name: name, Change: "changed", Fields: fields}, true
+}
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestEditGridSnapshotsInTransaction(t *testing.T) {
+	database := testDatabase(t)
+	ctx := context.Background()
+	h := NewGridDistributionHandler(database)
+
+	grid := &models.GridNetwork{ID: primitive.NewObjectID(), Name: "edited", TotalCapacity: 10, Version: 1, ChildNodes: []models.ChildNode{}}
+	if _, err := database.Collection("grid_networks").InsertOne(ctx, grid); err != nil {
+		t.Fatal(err)
+	}
+	snapshots := func() int64 {
+		n, err := database.Collection("grid_snapshots").CountDocuments(ctx, bson.M{"grid_id": grid.ID})
+		if err != nil {
+			t.Error(err)
+		}
+		return n
+	}
+	rename := bson.M{"$set": bson.M{"name": "renamed"}, "$inc": bson.M{"version": 1}}
+	audits := func() int64 {
+		n, err := database.Collection("audit_events").CountDocuments(ctx, bson.M{"target_id": grid.ID.Hex()})
+		if err != nil {
+			t.Error(err)
+		}
+		return n
+	}
+
+	var errs [3]error
+	asCaller(t, servicePrincipal("acme"), func(c *fiber.Ctx) {
+		audit := newAuditEvent(c, models.AuditGridNetworkUpdate, "grid_network", grid.ID.Hex(), nil, bson.M{"name": "renamed"})
+		// A successful edit is stored with its snapshot.
+		errs[0] = h.editGrid(c, grid, audit, false, h.versionedUpdate(gridVersionFilter(grid.ID, 1), rename))
+		// A stale version conflicts and records nothing.
+		errs[1] = h.editGrid(c, grid, audit, false, h.versionedUpdate(gridVersionFilter(grid.ID, 1), rename))
+		// A snapshot that cannot be taken rolls the edit back: the grid
+		// is gone by the time it is read.
+		errs[2] = h.editGrid(c, grid, audit, false, func(sc mongo.SessionContext) error {
+			_, err := database.Collection("grid_networks").DeleteOne(sc, bson.M{"_id": grid.ID})
+			return err
+		})
+	})
+
+	if errs[0] != nil {
+		t.Fatalf("edit: %v", errs[0])
+	}
+	if !errors.Is(errs[1], errGridConflict) {
+		t.Errorf("stale edit: err = %v, want errGridConflict", errs[1])
+	}
+	if errs[2] == nil {
+		t.Error("edit without a snapshot succeeded")
+	}
+
+	var stored models.GridNetwork
+	if err := database.Collection("grid_networks").FindOne(ctx, bson.M{"_id": grid.ID}).Decode(&stored); err != nil {
+		t.Fatalf("grid was not restored: %v", err)
+	}
+	if stored.Version != 2 || stored.Name != "renamed" {
+		t.Errorf("stored grid v%d %q, want v2 \"renamed\"", stored.Version, stored.Name)
+	}
+	if n := snapshots(); n != 1 {
+		t.Errorf("%d snapshots recorded, want 1", n)
+	}
+	if n := audits(); n != 1 {
+		t.Errorf("%d audit events recorded, want 1", n)
+	}
+
+	var snapshot models.GridSnapshot
+	if err := database.Collection("grid_snapshots").FindOne(ctx, bson.M{"grid_id": grid.ID}).Decode(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Version != 2 || snapshot.Cause != models.SnapshotCauseManual || snapshot.Actor != "svc" {
+		t.Errorf("snapshot = v%d cause %q actor %q", snapshot.Version, snapshot.Cause, snapshot.Actor)
+	}
+}
+
+func TestDiffGridSnapshots(t *testing.T) {
+	kept := models.ChildNode{ID: primitive.NewObjectID(), Name: "kept", CurrentDemand: 10}
+	gone := models.ChildNode{ID: primitive.NewObjectID(), Name: "gone"}
+	added := models.ChildNode{ID: primitive.NewObjectID(), Name: "added"}
+	line := models.GridLine{ID: primitive.NewObjectID(), FromNodeID: kept.ID, ToNodeID: gone.ID, InService: true}
+
+	from := &models.GridSnapshot{Version: 1, State: &models.GridNetwork{
+		Name: "before", ChildNodes: []models.ChildNode{kept, gone}, Lines: []models.GridLine{line},
+	}}
+	changed := kept
+	changed.CurrentDemand = 15
+	to := &models.GridSnapshot{Version: 2, State: &models.GridNetwork{
+		Name: "after", ChildNodes: []models.ChildNode{changed, added}, Lines: []models.GridLine{line},
+	}}
+
+	diff := diffGridSnapshots(from, to)
+	if diff.FromVersion != 1 || diff.ToVersion != 2 {
+		t.Errorf("versions %d..%d, want 1..2", diff.FromVersion, diff.ToVersion)
+	}
+	// Nodes and lines are reported element by element, not as grid fields.
+	if len(diff.Fields) != 1 || diff.Fields["name"].After != "after" {
+		t.Errorf("fields = %v, want only the name", diff.Fields)
+	}
+	if len(diff.Lines) != 0 {
+		t.Errorf("unchanged line reported: %v", diff.Lines)
+	}
+
+	changes := map[primitive.ObjectID]models.ElementDiff{}
+	for _, node := range diff.Nodes {
+		changes[node.ID] = node
+	}
+	if len(changes) != 3 {
+		t.Fatalf("nodes = %v, want three changes", diff.Nodes)
+	}
+	if c := changes[kept.ID]; c.Change != "changed" || len(c.Fields) != 1 || c.Fields["current_demand"].After != 15.0 {
+		t.Errorf("kept node: %+v", c)
+	}
+	if changes[added.ID].Change != "added" || changes[gone.ID].Change != "removed" {
+		t.Errorf("added %q, gone %q", changes[added.ID].Change, changes[gone.ID].Change)
+	}
+}
@@ -0,0 +1,326 @@
+package controllers
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// storageHorizon is how long a storage dispatch is expected to hold: until
+// the scheduler's next regular balance.
+func storageHorizon() time.Duration {
+	return envDuration("BALANCE_INTERVAL", 15*time.Minute)
+}
+
+// storageEfficiency is the one-way efficiency; charging and discharging each
+// lose the same share of the round trip.
+func storageEfficiency(asset models.StorageAsset) float64 {
+	return math.Sqrt(asset.RoundTripEfficiency)
+}
+
+// storageSoCAfter is the state of charge after running at power MW
+// (positive charging) for d, within the asset's capacity.
+func storageSoCAfter(asset models.StorageAsset, soc, power float64, d time.Duration) float64 {
+	eff := storageEfficiency(asset)
+	hours := d.Hours()
+	if power >= 0 {
+		soc += power * eff * hours
+	} else if eff > 0 {
+		soc += power / eff * hours
+	}
+	return math.Min(math.Max(soc, 0), asset.CapacityMWh)
+}
+
+// currentSoC is the asset's state of charge at now, having run its last
+// dispatch since it was recorded.
+func currentSoC(asset models.StorageAsset, now time.Time) float64 {
+	elapsed := now.Sub(asset.SoCUpdatedAt)
+	if asset.SoCUpdatedAt.IsZero() || elapsed < 0 {
+		elapsed = 0
+	}
+	return storageSoCAfter(asset, asset.StateOfCharge, asset.Dispatch, elapsed)
+}
+
+// dispatchStorage splits a shortfall (gap > 0) or spare supply (gap < 0)
+// across the grid's storage, in proportion to what each asset can give or
+// take over the storage horizon. It returns a dispatch for every asset and
+// the net MW storage adds to supply, negative while charging.
+func dispatchStorage(grid *models.GridNetwork, gap float64, now time.Time) ([]models.StorageDispatch, float64) {
+	if len(grid.Storage) == 0 {
+		return nil, 0
+	}
+	hours := storageHorizon().Hours()
+
+	socs := make([]float64, len(grid.Storage))
+	limits := make([]float64, len(grid.Storage))
+	total := 0.0
+	for i, asset := range grid.Storage {
+		socs[i] = currentSoC(asset, now)
+		eff := storageEfficiency(asset)
+		switch {
+		case gap > 0:
+			limits[i] = math.Min(asset.MaxDischargeMW, socs[i]*eff/hours)
+		case gap < 0 && eff > 0:
+			limits[i] = math.Min(asset.MaxChargeMW, (asset.CapacityMWh-socs[i])/(eff*hours))
+		}
+		limits[i] = math.Max(limits[i], 0)
+		total += limits[i]
+	}
+
+	share := 0.0
+	if total > 0 {
+		share = math.Min(math.Abs(gap)/total, 1)
+	}
+	dispatches := make([]models.StorageDispatch, len(grid.Storage))
+	net := 0.0
+	for i, asset := range grid.Storage {
+		power := limits[i] * share
+		mode := models.StorageIdle
+		switch {
+		case power > minTransferAmount && gap > 0:
+			mode = models.StorageDischarge
+			power = -power
+		case power > minTransferAmount:
+			mode = models.StorageCharge
+		default:
+			power = 0
+		}
+		net -= power
+		dispatches[i] = models.StorageDispatch{
+			StorageID: asset.ID,
+			NodeID:    asset.NodeID,
+			Mode:      mode,
+			Power:     power,
+			SoCBefore: socs[i],
+			SoCAfter:  storageSoCAfter(asset, socs[i], power, storageHorizon()),
+		}
+	}
+	return dispatches, net
+}
+
+// appliedStorage returns the grid's storage with the plan's dispatch taking
+// effect at now, and the state of charge records to keep.
+func appliedStorage(grid *models.GridNetwork, plan *models.BalancePlan, planID primitive.ObjectID, now time.Time) ([]models.StorageAsset, []interface{}) {
+	dispatch := map[primitive.ObjectID]models.StorageDispatch{}
+	for _, d := range plan.Storage {
+		dispatch[d.StorageID] = d
+	}
+	storage := append([]models.StorageAsset(nil), grid.Storage...)
+	var records []interface{}
+	for i := range storage {
+		d, ok := dispatch[storage[i].ID]
+		if !ok {
+			continue
+		}
+		storage[i].StateOfCharge = currentSoC(storage[i], now)
+		storage[i].Dispatch = d.Power
+		storage[i].SoCUpdatedAt = now
+		records = append(records, models.StorageSoCRecord{
+			GridID:        grid.ID,
+			StorageID:     storage[i].ID,
+			PlanID:        planID,
+			StateOfCharge: storage[i].StateOfCharge,
+			Mode:          d.Mode,
+			Dispatch:      d.Power,
+			RecordedAt:    now,
+		})
+	}
+	return storage, records
+}
+
+func (h *GridDistributionHandler) GetStorageAssets(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	now := time.Now()
+	storage := []models.StorageAsset{}
+	for _, asset := range grid.Storage {
+		asset.StateOfCharge = currentSoC(asset, now)
+		asset.SoCUpdatedAt = now
+		storage = append(storage, asset)
+	}
+	return c.JSON(storage)
+}
+
+func (h *GridDistributionHandler) CreateStorageAsset(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+
+	asset, msg := parseStorageAsset(c, grid)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	asset.ID = primitive.NewObjectID()
+	asset.SoCUpdatedAt = time.Now()
+
+	audit := newAuditEvent(c, models.AuditStorageCreate, "grid_network", grid.ID.Hex(), nil, asset)
+	err = h.editGrid(c, grid, audit, false, h.versionedUpdate(
+		gridVersionFilter(grid.ID, grid.Version),
+		bson.M{"$push": bson.M{"storage": asset}, "$inc": bson.M{"version": 1}},
+	))
+	if err != nil {
+		return editError(c, err, "Could not add storage asset")
+	}
+
+	grid.Storage = append(grid.Storage, asset)
+	grid.Version++
+
+	return c.Status(fiber.StatusCreated).JSON(asset)
+}
+
+// UpdateStorageAsset replaces an asset's settings. Its state of charge and
+// dispatch carry over.
+func (h *GridDistributionHandler) UpdateStorageAsset(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	index, ok := findStorageAsset(grid, c.Params("storageId"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Storage asset not found"})
+	}
+	previous := grid.Storage[index]
+
+	asset, msg := parseStorageAsset(c, grid)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	now := time.Now()
+	asset.ID = previous.ID
+	asset.StateOfCharge = math.Min(currentSoC(previous, now), asset.CapacityMWh)
+	asset.Dispatch = previous.Dispatch
+	asset.SoCUpdatedAt = now
+
+	filter := gridVersionFilter(grid.ID, grid.Version)
+	filter["storage._id"] = asset.ID
+	audit := newAuditEvent(c, models.AuditStorageUpdate, "grid_network", grid.ID.Hex(), previous, asset)
+	err = h.editGrid(c, grid, audit, false, h.versionedUpdate(
+		filter,
+		bson.M{"$set": bson.M{"storage.$": asset}, "$inc": bson.M{"version": 1}},
+	))
+	if err != nil {
+		return editError(c, err, "Could not update storage asset")
+	}
+
+	grid.Storage[index] = asset
+	grid.Version++
+
+	return c.JSON(asset)
+}
+
+func (h *GridDistributionHandler) DeleteStorageAsset(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	index, ok := findStorageAsset(grid, c.Params("storageId"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Storage asset not found"})
+	}
+	previous := grid.Storage[index]
+
+	audit := newAuditEvent(c, models.AuditStorageDelete, "grid_network", grid.ID.Hex(), previous, nil)
+	err = h.editGrid(c, grid, audit, false, h.versionedUpdate(
+		gridVersionFilter(grid.ID, grid.Version),
+		bson.M{"$pull": bson.M{"storage": bson.M{"_id": previous.ID}}, "$inc": bson.M{"version": 1}},
+	))
+	if err != nil {
+		return editError(c, err, "Could not delete storage asset")
+	}
+
+	grid.Storage = append(grid.Storage[:index:index], grid.Storage[index+1:]...)
+	grid.Version++
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetStorageHistory returns an asset's state of charge records, newest
+// first, optionally within a time range. It is paged like the grid lists.
+func (h *GridDistributionHandler) GetStorageHistory(c *fiber.Ctx) error {
+	grid, err := h.findGrid(c)
+	if err != nil {
+		return gridError(c, err)
+	}
+	storageID, err := primitive.ObjectIDFromHex(c.Params("storageId"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Storage asset not found"})
+	}
+	from, to, msg := queryTimeRange(c)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	page, limit, msg := pageParams(c)
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+
+	filter := bson.M{"grid_id": grid.ID, "storage_id": storageID}
+	timeRangeFilter(filter, "recorded_at", from, to)
+	opts := options.Find().SetSort(bson.M{"recorded_at": -1}).SetSkip((page - 1) * limit).SetLimit(limit)
+	cursor, err := h.db.Collection("storage_soc_history").Find(c.Context(), filter, opts)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not fetch storage history"})
+	}
+	records := []models.StorageSoCRecord{}
+	if err := cursor.All(c.Context(), &records); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Could not fetch storage history"})
+	}
+	return c.JSON(records)
+}
+
+// parseStorageAsset reads a storage asset body and checks it can sit on one
+// of grid's nodes. The string is empty when the asset is acceptable.
+func parseStorageAsset(c *fiber.Ctx, grid *models.GridNetwork) (models.StorageAsset, string) {
+	var req models.StorageAssetRequest
+	if err := c.BodyParser(&req); err != nil {
+		return models.StorageAsset{}, "Invalid input"
+	}
+
+	asset := models.StorageAsset{
+		Name:                strings.TrimSpace(req.Name),
+		NodeID:              req.NodeID,
+		CapacityMWh:         req.CapacityMWh,
+		StateOfCharge:       req.StateOfCharge,
+		MaxChargeMW:         req.MaxChargeMW,
+		MaxDischargeMW:      req.MaxDischargeMW,
+		RoundTripEfficiency: req.RoundTripEfficiency,
+	}
+
+	switch {
+	case asset.Name == "":
+		return asset, "name is required"
+	case !hasChildNode(grid, asset.NodeID):
+		return asset, "node_id must be a node of this grid"
+	case !finite(asset.CapacityMWh) || asset.CapacityMWh <= 0:
+		return asset, "capacity_mwh must be positive"
+	case !finite(asset.StateOfCharge) || asset.StateOfCharge < 0 || asset.StateOfCharge > asset.CapacityMWh:
+		return asset, "state_of_charge must be between 0 and capacity_mwh"
+	case !finite(asset.MaxChargeMW) || asset.MaxChargeMW < 0 || !finite(asset.MaxDischargeMW) || asset.MaxDischargeMW < 0:
+		return asset, "Charge and discharge limits must not be negative"
+	case !finite(asset.RoundTripEfficiency) || asset.RoundTripEfficiency <= 0 || asset.RoundTripEfficiency > 1:
+		return asset, "round_trip_efficiency must be above 0 and at most 1"
+	}
+	return asset, ""
+}
+
+func findStorageAsset(grid *models.GridNetwork, hexID string) (int, bool) {
+	id, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		return 0, false
+	}
+	for i, asset := range grid.Storage {
+		if asset.ID == id {
+			return i, true
+		}
+	}
+	return 0, false
+}
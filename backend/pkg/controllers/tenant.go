@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var errNoTenant = errors.New("caller is not bound to an enterprise")
+
+// callerTenant returns the enterprise the caller is confined to. crossTenant
+// is true for roles holding tenant:all, in which case no scoping applies.
+func callerTenant(c *fiber.Ctx) (enterprise string, crossTenant bool, err error) {
+	if middleware.HasPermission(c, models.PermTenantAll) {
+		return "", true, nil
+	}
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok || principal.Enterprise == "" {
+		return "", false, errNoTenant
+	}
+	return principal.Enterprise, false, nil
+}
+
+// scopeByEnterprise restricts a filter on an enterprise-owned collection
+// (grids, meters, wards, grid networks) to the caller's tenant.
+func scopeByEnterprise(c *fiber.Ctx, filter bson.M) (bson.M, error) {
+	enterprise, crossTenant, err := callerTenant(c)
+	if err != nil {
+		return nil, err
+	}
+	if !crossTenant {
+		filter["enterprise"] = enterprise
+	}
+	return filter, nil
+}
+
+// scopeByZone restricts a filter on a zone-keyed collection to the zones
+// owned by the caller's tenant. A zone_id already in the filter is kept only
+// if the tenant owns it.
+func scopeByZone(c *fiber.Ctx, database *mongo.Database, filter bson.M) (bson.M, error) {
+	enterprise, crossTenant, err := callerTenant(c)
+	if err != nil {
+		return nil, err
+	}
+	if crossTenant {
+		return filter, nil
+	}
+
+	zoneIDs, err := tenantZoneIDs(c.Context(), database, enterprise)
+	if err != nil {
+		return nil, err
+	}
+
+	if zoneID, ok := filter["zone_id"].(string); ok {
+		owned := false
+		for _, id := range zoneIDs {
+			if id == zoneID {
+				owned = true
+				break
+			}
+		}
+		if !owned {
+			zoneIDs = []string{}
+		} else {
+			zoneIDs = []string{zoneID}
+		}
+	}
+	filter["zone_id"] = bson.M{"$in": zoneIDs}
+	return filter, nil
+}
+
+func tenantZoneIDs(ctx context.Context, database *mongo.Database, enterprise string) ([]string, error) {
+	cursor, err := database.Collection("zones").Find(ctx, bson.M{"enterprise": enterprise})
+	if err != nil {
+		return nil, err
+	}
+	var zones []models.Zone
+	if err := cursor.All(ctx, &zones); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(zones))
+	for _, z := range zones {
+		ids = append(ids, z.ZoneID)
+	}
+	return ids, nil
+}
+
+func tenantError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errNoTenant) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "No enterprise assigned to this account"})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not resolve tenant"})
+}
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Service principals carry their permissions as scopes, so these tests do
+// not need the role store.
+func servicePrincipal(enterprise string, scopes ...string) *middleware.Principal {
+	return &middleware.Principal{Kind: middleware.PrincipalService, Username: "svc", Enterprise: enterprise, Scopes: scopes}
+}
+
+// asCaller runs fn inside a request made by principal, or an anonymous one
+// when principal is nil. fn runs on the server's goroutine, so it reports
+// failures with t.Errorf rather than t.Fatal.
+func asCaller(t *testing.T, principal *middleware.Principal, fn func(c *fiber.Ctx)) {
+	t.Helper()
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		if principal != nil {
+			c.Locals(middleware.PrincipalKey, principal)
+		}
+		fn(c)
+		return nil
+	})
+	if _, err := app.Test(httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCallerTenant(t *testing.T) {
+	tests := []struct {
+		name        string
+		principal   *middleware.Principal
+		enterprise  string
+		crossTenant bool
+		err         error
+	}{
+		{name: "anonymous", err: errNoTenant},
+		{name: "no enterprise", principal: servicePrincipal(""), err: errNoTenant},
+		{name: "tenant", principal: servicePrincipal("acme", models.PermGridRead), enterprise: "acme"},
+		{name: "cross tenant", principal: servicePrincipal("acme", models.PermTenantAll), crossTenant: true},
+		{name: "cross tenant without enterprise", principal: servicePrincipal("", models.PermTenantAll), crossTenant: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asCaller(t, tt.principal, func(c *fiber.Ctx) {
+				enterprise, crossTenant, err := callerTenant(c)
+				if !errors.Is(err, tt.err) {
+					t.Errorf("err = %v, want %v", err, tt.err)
+				}
+				if enterprise != tt.enterprise || crossTenant != tt.crossTenant {
+					t.Errorf("got (%q, %v), want (%q, %v)", enterprise, crossTenant, tt.enterprise, tt.crossTenant)
+				}
+			})
+		})
+	}
+}
+
+func TestScopeByEnterprise(t *testing.T) {
+	asCaller(t, servicePrincipal("acme"), func(c *fiber.Ctx) {
+		// A caller asking for another tenant's data gets its own instead.
+		filter, err := scopeByEnterprise(c, bson.M{"enterprise": "globex", "tier": "feeder"})
+		if err != nil {
+			t.Errorf("scopeByEnterprise: %v", err)
+			return
+		}
+		if want := (bson.M{"enterprise": "acme", "tier": "feeder"}); !reflect.DeepEqual(filter, want) {
+			t.Errorf("filter = %v, want %v", filter, want)
+		}
+	})
+
+	asCaller(t, servicePrincipal("acme", models.PermTenantAll), func(c *fiber.Ctx) {
+		filter, err := scopeByEnterprise(c, bson.M{"enterprise": "globex"})
+		if err != nil {
+			t.Errorf("scopeByEnterprise: %v", err)
+			return
+		}
+		if want := (bson.M{"enterprise": "globex"}); !reflect.DeepEqual(filter, want) {
+			t.Errorf("cross-tenant filter = %v, want %v", filter, want)
+		}
+	})
+
+	asCaller(t, nil, func(c *fiber.Ctx) {
+		if _, err := scopeByEnterprise(c, bson.M{}); !errors.Is(err, errNoTenant) {
+			t.Errorf("anonymous caller: err = %v, want errNoTenant", err)
+		}
+	})
+}
+
+func TestScopeByZoneCrossTenant(t *testing.T) {
+	// Neither path reaches the database.
+	asCaller(t, servicePrincipal("", models.PermTenantAll), func(c *fiber.Ctx) {
+		filter, err := scopeByZone(c, nil, bson.M{"zone_id": "z9"})
+		if err != nil {
+			t.Errorf("scopeByZone: %v", err)
+			return
+		}
+		if want := (bson.M{"zone_id": "z9"}); !reflect.DeepEqual(filter, want) {
+			t.Errorf("filter = %v, want %v", filter, want)
+		}
+	})
+	asCaller(t, servicePrincipal(""), func(c *fiber.Ctx) {
+		if _, err := scopeByZone(c, nil, bson.M{}); !errors.Is(err, errNoTenant) {
+			t.Errorf("err = %v, want errNoTenant", err)
+		}
+	})
+}
+
+func TestScopeByZone(t *testing.T) {
+	database := testDatabase(t)
+	zones := []interface{}{
+		models.Zone{ZoneID: "z1", Enterprise: "acme"},
+		models.Zone{ZoneID: "z2", Enterprise: "acme"},
+		models.Zone{ZoneID: "z3", Enterprise: "globex"},
+	}
+	if _, err := database.Collection("zones").InsertMany(context.Background(), zones); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter bson.M
+		want   []string
+	}{
+		{name: "all owned zones", filter: bson.M{}, want: []string{"z1", "z2"}},
+		{name: "owned zone", filter: bson.M{"zone_id": "z2"}, want: []string{"z2"}},
+		{name: "other tenant's zone", filter: bson.M{"zone_id": "z3"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asCaller(t, servicePrincipal("acme"), func(c *fiber.Ctx) {
+				filter, err := scopeByZone(c, database, tt.filter)
+				if err != nil {
+					t.Errorf("scopeByZone: %v", err)
+					return
+				}
+				if want := (bson.M{"zone_id": bson.M{"$in": tt.want}}); !reflect.DeepEqual(filter, want) {
+					t.Errorf("filter = %v, want %v", filter, want)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Topology describes how power can move between the nodes of a grid.
+type Topology interface {
+	// Routes returns candidate routes between two nodes, lowest loss first.
+	// It is empty when the nodes are not connected.
+	Routes(from, to models.ChildNode) []TransferRoute
+	// LineRatings returns the thermal rating in MW of every line a route
+	// may use. Nil means routes are not capacity constrained.
+	LineRatings() map[primitive.ObjectID]float64
+	// TransferLoss is the rate route loses carrying load MW. Routes are
+	// planned at a fixed load, so the rate a transfer is recorded with
+	// comes from here.
+	TransferLoss(route TransferRoute, load float64) float64
+	// FlowLoss is the rate route loses when each of its lines carries
+	// flows[line] MW in total, its own load included. Lines missing from
+	// flows carry load alone.
+	FlowLoss(route TransferRoute, load float64, flows map[primitive.ObjectID]float64) float64
+}
+
+// gridTopology routes over the grid's lines when it has any and falls back
+// to straight-line distance for grids that were never given a topology.
+// Losses are priced with model.
+func gridTopology(grid *models.GridNetwork, model LossModel) Topology {
+	if len(grid.Lines) == 0 {
+		return straightLineTopology{model: model, load: planningLoad(model)}
+	}
+	return newLineTopology(grid.Lines, model)
+}
+
+// straightLineTopology is the original model: haversine distance between
+// the two nodes, and no line limits. Routes are planned at load MW.
+type straightLineTopology struct {
+	model LossModel
+	load  float64
+}
+
+func (t straightLineTopology) Routes(from, to models.ChildNode) []TransferRoute {
+	distance := calculateDistance(from.Location, to.Location)
+	return []TransferRoute{{Distance: distance, LossRate: t.model.DistanceLoss(distance, t.load)}}
+}
+
+func (straightLineTopology) LineRatings() map[primitive.ObjectID]float64 { return nil }
+
+func (t straightLineTopology) TransferLoss(route TransferRoute, load float64) float64 {
+	return t.model.DistanceLoss(route.Distance, load)
+}
+
+// FlowLoss is TransferLoss: straight-line routes share no lines.
+func (t straightLineTopology) FlowLoss(route TransferRoute, load float64, _ map[primitive.ObjectID]float64) float64 {
+	return t.TransferLoss(route, load)
+}
+
+type lineEdge struct {
+	to   primitive.ObjectID
+	line models.GridLine
+	// Loss fraction at the thermal rating, the worst case the line can see.
+	lossRate float64
+}
+
+// lineTopology routes transfers over in-service lines. Losses grow with the
+// power a line carries, so paths are planned with each line's loss at its
+// thermal rating; the solver may under-use a line but never overestimates
+// what it can deliver. Transfers are then priced at the total power each
+// line carries across the plan.
+type lineTopology struct {
+	model     LossModel
+	lines     map[primitive.ObjectID]models.GridLine
+	adjacency map[primitive.ObjectID][]lineEdge
+	ratings   map[primitive.ObjectID]float64
+	routes    map[[2]primitive.ObjectID][]TransferRoute
+}
+
+func newLineTopology(lines []models.GridLine, model LossModel) *lineTopology {
+	t := &lineTopology{
+		model:     model,
+		lines:     map[primitive.ObjectID]models.GridLine{},
+		adjacency: map[primitive.ObjectID][]lineEdge{},
+		ratings:   map[primitive.ObjectID]float64{},
+		routes:    map[[2]primitive.ObjectID][]TransferRoute{},
+	}
+	for _, line := range lines {
+		if !line.InService {
+			continue
+		}
+		rate := model.LineLoss(line, line.ThermalRating)
+		if rate >= 1 {
+			continue
+		}
+		t.adjacency[line.FromNodeID] = append(t.adjacency[line.FromNodeID], lineEdge{to: line.ToNodeID, line: line, lossRate: rate})
+		t.adjacency[line.ToNodeID] = append(t.adjacency[line.ToNodeID], lineEdge{to: line.FromNodeID, line: line, lossRate: rate})
+		t.lines[line.ID] = line
+		t.ratings[line.ID] = line.ThermalRating
+	}
+	return t
+}
+
+func (t *lineTopology) LineRatings() map[primitive.ObjectID]float64 { return t.ratings }
+
+func (t *lineTopology) TransferLoss(route TransferRoute, load float64) float64 {
+	kept := 1.0
+	for _, id := range route.Lines {
+		kept *= 1 - t.model.LineLoss(t.lines[id], load)
+	}
+	return 1 - kept
+}
+
+func (t *lineTopology) FlowLoss(route TransferRoute, load float64, flows map[primitive.ObjectID]float64) float64 {
+	kept := 1.0
+	for _, id := range route.Lines {
+		flow, ok := flows[id]
+		if !ok {
+			flow = load
+		}
+		kept *= 1 - t.model.LineLoss(t.lines[id], flow)
+	}
+	return 1 - kept
+}
+
+// Routes returns the lowest-loss path plus, for each line on it, the best
+// path avoiding that line. The alternates let a transfer spill onto a
+// parallel feeder once the preferred one reaches its thermal rating.
+func (t *lineTopology) Routes(from, to models.ChildNode) []TransferRoute {
+	if from.ID == to.ID {
+		return nil
+	}
+	key := [2]primitive.ObjectID{from.ID, to.ID}
+	if routes, ok := t.routes[key]; ok {
+		return routes
+	}
+
+	best, ok := t.shortestRoute(from.ID, to.ID, primitive.NilObjectID)
+	if !ok {
+		t.routes[key] = nil
+		return nil
+	}
+	routes := []TransferRoute{best}
+	seen := map[string]bool{routeKey(best): true}
+	for _, avoid := range best.Lines {
+		alt, ok := t.shortestRoute(from.ID, to.ID, avoid)
+		if !ok || seen[routeKey(alt)] {
+			continue
+		}
+		seen[routeKey(alt)] = true
+		routes = append(routes, alt)
+	}
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].LossRate < routes[j].LossRate })
+
+	t.routes[key] = routes
+	return routes
+}
+
+// shortestRoute runs Dijkstra from source, skipping the avoided line.
+// Minimising the sum of -ln(1-loss) over a path maximises the power that
+// arrives.
+func (t *lineTopology) shortestRoute(source, target, avoid primitive.ObjectID) (TransferRoute, bool) {
+	cost := map[primitive.ObjectID]float64{source: 0}
+	routes := map[primitive.ObjectID]TransferRoute{source: {}}
+	done := map[primitive.ObjectID]bool{}
+
+	queue := &routeQueue{{node: source}}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(routeItem)
+		if done[item.node] {
+			continue
+		}
+		if item.node == target {
+			return routes[target], true
+		}
+		done[item.node] = true
+
+		current := routes[item.node]
+		for _, edge := range t.adjacency[item.node] {
+			if edge.line.ID == avoid {
+				continue
+			}
+			next := item.cost - math.Log(1-edge.lossRate)
+			if known, ok := cost[edge.to]; ok && known <= next {
+				continue
+			}
+			cost[edge.to] = next
+			routes[edge.to] = TransferRoute{
+				Distance: current.Distance + edge.line.Length,
+				LossRate: 1 - (1-current.LossRate)*(1-edge.lossRate),
+				Lines:    append(append([]primitive.ObjectID(nil), current.Lines...), edge.line.ID),
+			}
+			heap.Push(queue, routeItem{node: edge.to, cost: next})
+		}
+	}
+	return TransferRoute{}, false
+}
+
+func routeKey(r TransferRoute) string {
+	key := ""
+	for _, id := range r.Lines {
+		key += id.Hex()
+	}
+	return key
+}
+
+type routeItem struct {
+	node primitive.ObjectID
+	cost float64
+}
+
+type routeQueue []routeItem
+
+func (q routeQueue) Len() int            { return len(q) }
+func (q routeQueue) Less(i, j int) bool  { return q[i].cost < q[j].cost }
+func (q routeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *routeQueue) Push(x interface{}) { *q = append(*q, x.(routeItem)) }
+func (q *routeQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -2,15 +2,21 @@ package controllers
 
 import (
 	"context"
+	"errors"
 
 	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/db"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func UpdateRole(c *fiber.Ctx) error {
-	id, _ := primitive.ObjectIDFromHex(c.Params("id"))
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
 	var req struct {
 		Role       string `json:"role"`
 		Enterprise string `json:"enterprise,omitempty"`
@@ -20,15 +26,112 @@ func UpdateRole(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
 	}
 
+	principal, _ := middleware.GetPrincipal(c)
+
+	allowed, err := middleware.Roles.CanGrant(c.Context(), principal.Role, req.Role)
+	if errors.Is(err, middleware.ErrUnknownRole) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown role"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update role"})
+	}
+	if !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Cannot grant a role with permissions you do not hold"})
+	}
+
+	var target models.User
+	if err := db.GetCollection("users").FindOne(context.TODO(), bson.M{"_id": id}).Decode(&target); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	// Changing someone's role also means taking their current one away.
+	if target.Role != "" {
+		allowed, err := middleware.Roles.CanGrant(c.Context(), principal.Role, target.Role)
+		if err != nil && !errors.Is(err, middleware.ErrUnknownRole) {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update role"})
+		}
+		if err == nil && !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Cannot change the role of a more privileged user"})
+		}
+	}
+
+	if req.Role == models.RoleEnterpriseCustomer && req.Enterprise == "" && target.Enterprise == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Enterprise name required"})
+	}
+
 	updateData := bson.M{"role": req.Role}
-	if req.Role == "enterprise_customer" && req.Enterprise != "" {
+	if req.Role == models.RoleEnterpriseCustomer && req.Enterprise != "" {
 		updateData["enterprise"] = req.Enterprise
 	}
 
-	_, err := db.GetCollection("users").UpdateOne(context.TODO(), bson.M{"_id": id}, bson.M{"$set": updateData})
+	_, err = db.GetCollection("users").UpdateOne(context.TODO(), bson.M{"_id": id}, bson.M{"$set": updateData})
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update role"})
 	}
 
+	recordAudit(c, models.AuditUserRoleUpdate, "user", id.Hex(),
+		bson.M{"role": target.Role, "enterprise": target.Enterprise}, updateData)
 	return c.JSON(fiber.Map{"message": "Role updated successfully"})
 }
+
+func GetRoles(c *fiber.Ctx) error {
+	roles, permissions, err := middleware.Roles.List(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch roles"})
+	}
+	return c.JSON(fiber.Map{"roles": roles, "permissions": permissions})
+}
+
+// SaveRole creates or redefines a role. Callers can neither define nor edit
+// a role that would carry permissions they do not hold themselves.
+func SaveRole(c *fiber.Ctx) error {
+	var role models.Role
+	if err := c.BodyParser(&role); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	role.Name = c.Params("name")
+	if role.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Role name required"})
+	}
+
+	principal, _ := middleware.GetPrincipal(c)
+	actorPerms, err := middleware.Roles.Permissions(c.Context(), principal.Role)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Access denied: Insufficient permissions"})
+	}
+
+	for _, p := range role.Permissions {
+		if !actorPerms[p] {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Cannot grant a permission you do not hold: " + p})
+		}
+	}
+	for _, parent := range role.Inherits {
+		allowed, err := middleware.Roles.CanGrant(c.Context(), principal.Role, parent)
+		if err != nil || !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Cannot inherit from role: " + parent})
+		}
+	}
+	if allowed, err := middleware.Roles.CanGrant(c.Context(), principal.Role, role.Name); err == nil && !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Cannot edit a role more privileged than your own"})
+	}
+
+	previous, err := middleware.Roles.Get(c.Context(), role.Name)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not save role"})
+	}
+
+	err = middleware.Roles.Save(c.Context(), role)
+	if errors.Is(err, middleware.ErrUnknownRole) || errors.Is(err, middleware.ErrUnknownPermission) || errors.Is(err, middleware.ErrRoleCycle) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not save role"})
+	}
+
+	var before interface{}
+	if previous != nil {
+		before = previous
+	}
+	recordAudit(c, models.AuditRoleSave, "role", role.Name, before, role)
+	return c.JSON(fiber.Map{"message": "Role saved successfully", "role": role})
+}
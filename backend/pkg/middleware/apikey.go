@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const apiKeyPrefix = "du_"
+
+// A key's last_used_at is only rewritten once it is this old, so a busy
+// key does not cost a write on every request.
+const apiKeyUsageResolution = time.Minute
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid api key")
+
+	// APIKeys is the process-wide API key store, set up by SetupAPIKeyStore.
+	APIKeys *APIKeyStore
+)
+
+type APIKeyStore struct {
+	accounts *mongo.Collection
+	keys     *mongo.Collection
+}
+
+func NewAPIKeyStore(db *mongo.Database) *APIKeyStore {
+	return &APIKeyStore{
+		accounts: db.Collection("service_accounts"),
+		keys:     db.Collection("api_keys"),
+	}
+}
+
+func SetupAPIKeyStore(db *mongo.Database) {
+	APIKeys = NewAPIKeyStore(db)
+
+	_, err := APIKeys.keys.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "prefix", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("Could not create api key indexes:", err)
+	}
+}
+
+// Issue creates a key for a service account and returns the full secret.
+// The secret is never stored and cannot be retrieved again.
+func (s *APIKeyStore) Issue(ctx context.Context, accountID primitive.ObjectID, scopes []string, expiresAt *time.Time, rotatedFrom *primitive.ObjectID) (string, *models.APIKey, error) {
+	idBytes := make([]byte, 6)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", nil, err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", nil, err
+	}
+	prefix := hex.EncodeToString(idBytes)
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	key := &models.APIKey{
+		ID:               primitive.NewObjectID(),
+		ServiceAccountID: accountID,
+		Prefix:           prefix,
+		KeyHash:          HashToken(secret),
+		Scopes:           scopes,
+		CreatedAt:        time.Now(),
+		ExpiresAt:        expiresAt,
+		RotatedFrom:      rotatedFrom,
+	}
+	if _, err := s.keys.InsertOne(ctx, key); err != nil {
+		return "", nil, err
+	}
+	return apiKeyPrefix + prefix + "." + secret, key, nil
+}
+
+// Authenticate resolves a presented key to a service principal.
+func (s *APIKeyStore) Authenticate(ctx context.Context, raw string) (*Principal, error) {
+	if !strings.HasPrefix(raw, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+	prefix, secret, ok := strings.Cut(strings.TrimPrefix(raw, apiKeyPrefix), ".")
+	if !ok || prefix == "" || secret == "" {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var key models.APIKey
+	err := s.keys.FindOne(ctx, bson.M{"prefix": prefix}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	expected := []byte(key.KeyHash)
+	presented := []byte(HashToken(secret))
+	if subtle.ConstantTimeCompare(expected, presented) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && key.ExpiresAt.Before(now)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var account models.ServiceAccount
+	if err := s.accounts.FindOne(ctx, bson.M{"_id": key.ServiceAccountID}).Decode(&account); err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	if account.Disabled {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyUsageResolution {
+		if _, err := s.keys.UpdateOne(ctx, bson.M{"_id": key.ID}, bson.M{"$set": bson.M{"last_used_at": now}}); err != nil {
+			log.Printf("api key %s: could not record use: %v", key.Prefix, err)
+		}
+	}
+
+	return &Principal{
+		Kind:       PrincipalService,
+		UserID:     account.ID.Hex(),
+		Username:   account.Name,
+		Enterprise: account.Enterprise,
+		KeyID:      key.ID.Hex(),
+		Scopes:     key.Scopes,
+	}, nil
+}
+
+func (s *APIKeyStore) CreateAccount(ctx context.Context, account *models.ServiceAccount) error {
+	account.ID = primitive.NewObjectID()
+	account.CreatedAt = time.Now()
+	_, err := s.accounts.InsertOne(ctx, account)
+	return err
+}
+
+func (s *APIKeyStore) Account(ctx context.Context, id primitive.ObjectID) (*models.ServiceAccount, error) {
+	var account models.ServiceAccount
+	if err := s.accounts.FindOne(ctx, bson.M{"_id": id}).Decode(&account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (s *APIKeyStore) ListAccounts(ctx context.Context) ([]models.ServiceAccount, error) {
+	accounts := []models.ServiceAccount{}
+	cursor, err := s.accounts.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+func (s *APIKeyStore) Key(ctx context.Context, id primitive.ObjectID) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := s.keys.FindOne(ctx, bson.M{"_id": id}).Decode(&key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (s *APIKeyStore) ListKeys(ctx context.Context, accountID primitive.ObjectID) ([]models.APIKey, error) {
+	keys := []models.APIKey{}
+	cursor, err := s.keys.Find(ctx, bson.M{"service_account_id": accountID}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Revoke disables a key immediately. It reports false if the key was not
+// found or was already revoked.
+func (s *APIKeyStore) Revoke(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	result, err := s.keys.UpdateOne(ctx,
+		bson.M{"_id": id, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAPIKeyMalformedRejectedWithoutLookup(t *testing.T) {
+	// The store has no collections: a lookup would panic.
+	store := &APIKeyStore{}
+	for _, raw := range []string{"", "abc.def", "du_", "du_abc", "du_.secret", "du_abc."} {
+		if _, err := store.Authenticate(context.Background(), raw); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("%q: err = %v, want ErrInvalidAPIKey", raw, err)
+		}
+	}
+}
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	database := testDatabase(t, "apikeys_test_")
+	store := NewAPIKeyStore(database)
+	ctx := context.Background()
+
+	account := &models.ServiceAccount{Name: "gateway", Enterprise: "acme", Scopes: []string{models.PermMeterWrite}}
+	if err := store.CreateAccount(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+	issue := func(expiresAt *time.Time) (string, *models.APIKey) {
+		t.Helper()
+		raw, key, err := store.Issue(ctx, account.ID, account.Scopes, expiresAt, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw, key
+	}
+
+	raw, key := issue(nil)
+	principal, err := store.Authenticate(ctx, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.Kind != PrincipalService || principal.UserID != account.ID.Hex() || principal.Enterprise != "acme" || principal.KeyID != key.ID.Hex() {
+		t.Errorf("principal = %+v", principal)
+	}
+
+	// A second use within the resolution leaves last_used_at alone.
+	used, err := store.Key(ctx, key.ID)
+	if err != nil || used.LastUsedAt == nil {
+		t.Fatalf("last_used_at not recorded: %v", err)
+	}
+	if _, err := store.Authenticate(ctx, raw); err != nil {
+		t.Fatal(err)
+	}
+	again, err := store.Key(ctx, key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.LastUsedAt.Equal(*used.LastUsedAt) {
+		t.Errorf("last_used_at rewritten from %v to %v within %v", used.LastUsedAt, again.LastUsedAt, apiKeyUsageResolution)
+	}
+
+	if _, err := store.Authenticate(ctx, raw+"x"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("wrong secret: err = %v", err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	expired, _ := issue(&past)
+	if _, err := store.Authenticate(ctx, expired); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("expired key: err = %v", err)
+	}
+
+	revoked, revokedKey := issue(nil)
+	if ok, err := store.Revoke(ctx, revokedKey.ID); err != nil || !ok {
+		t.Fatalf("revoke: %v, %v", ok, err)
+	}
+	if _, err := store.Authenticate(ctx, revoked); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("revoked key: err = %v", err)
+	}
+
+	if _, err := database.Collection("service_accounts").UpdateOne(ctx,
+		bson.M{"_id": account.ID}, bson.M{"$set": bson.M{"disabled": true}},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Authenticate(ctx, raw); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("key of a disabled account: err = %v", err)
+	}
+}
@@ -1,73 +1,92 @@
 package middleware
 
 import (
-	"context"
-	"fmt"
-	"log"
-	"net/http"
-	"os"
-	"strconv"
+	"errors"
 	"strings"
-	"time"
 
-	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const PrincipalKey = "principal"
 
-var jwtSecret = []byte(os.Getenv("JWTSECRET"))
-
-const UserKey = "userID"
-
-func CreateJWT(userID string) (string, error) {
-	expirationString := os.Getenv("JWTEXPINSEC")
-	expirationSeconds, err := strconv.Atoi(expirationString)
-	if err != nil {
-		log.Println("Error parsing JWT expiration time:", err)
-		expirationSeconds = 3600 
-	}
-
-	expiration := time.Duration(expirationSeconds) * time.Second
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID":    userID,
-		"expiredAt": time.Now().Add(expiration).Unix(),
-	})
-
-	tokenString, err := token.SignedString(jwtSecret)
-	if err != nil {
-		return "", err
-	}
+const (
+	PrincipalUser    = "user"
+	PrincipalService = "service"
+)
 
-	log.Printf("Created Token: %s", tokenString)
-	return tokenString, nil
+// Principal is the authenticated caller, stored in the request context by
+// WithJWTAuth. Users are authorised by Role; service accounts by the Scopes
+// of the API key they presented, and have no session.
+type Principal struct {
+	Kind       string   `json:"kind"`
+	UserID     string   `json:"user_id"`
+	Username   string   `json:"username"`
+	Role       string   `json:"role,omitempty"`
+	Enterprise string   `json:"enterprise,omitempty"`
+	SessionID  string   `json:"session_id,omitempty"`
+	MFA        bool     `json:"mfa"`
+	KeyID      string   `json:"key_id,omitempty"`
+	Scopes     []string `json:"scopes,omitempty"`
 }
 
+// WithJWTAuth authenticates a Bearer access token or, for service accounts,
+// an API key sent as "X-API-Key" or "Authorization: ApiKey <key>".
 func WithJWTAuth() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		authHeader := c.Get("Authorization")
-		if !strings.HasPrefix(authHeader, "Bearer ") {
+		if apiKey := getAPIKeyFromRequest(c); apiKey != "" {
+			principal, err := APIKeys.Authenticate(c.Context(), apiKey)
+			if errors.Is(err, ErrInvalidAPIKey) {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid API key"})
+			}
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not verify API key"})
+			}
+			c.Locals(PrincipalKey, principal)
+			return c.Next()
+		}
+
+		tokenString := getTokenFromRequest(c)
+		if tokenString == "" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or invalid token"})
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+		claims, err := Tokens.Validate(tokenString)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+		}
 
-		if err != nil || !token.Valid {
+		sessionID, err := primitive.ObjectIDFromHex(claims.SessionID)
+		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
 		}
+		active, err := Sessions.IsActive(c.Context(), sessionID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not verify session"})
+		}
+		if !active {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Session revoked or expired"})
+		}
 
-		claims, _ := token.Claims.(jwt.MapClaims)
-		c.Locals("role", claims["role"])
-		c.Locals("enterprise", claims["enterprise"])
+		c.Locals(PrincipalKey, &Principal{
+			Kind:       PrincipalUser,
+			UserID:     claims.Subject,
+			Username:   claims.Username,
+			Role:       claims.Role,
+			Enterprise: claims.Enterprise,
+			SessionID:  claims.SessionID,
+			MFA:        claims.MFA,
+		})
 
 		return c.Next()
 	}
 }
 
+// GetPrincipal returns the caller set by WithJWTAuth.
+func GetPrincipal(c *fiber.Ctx) (*Principal, bool) {
+	p, ok := c.Locals(PrincipalKey).(*Principal)
+	return p, ok && p != nil
+}
 
 func getTokenFromRequest(c *fiber.Ctx) string {
 	authHeader := c.Get("Authorization")
@@ -75,34 +94,22 @@ func getTokenFromRequest(c *fiber.Ctx) string {
 		return ""
 	}
 
-	parts := strings.Split(authHeader, " ")
+	parts := strings.SplitN(authHeader, " ", 2)
 	if len(parts) != 2 || parts[0] != "Bearer" {
 		return ""
 	}
 
-	return parts[1]
+	return strings.TrimSpace(parts[1])
 }
 
-func validateJWT(tokenString string) (*jwt.Token, error) {
-	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	})
-}
-
-func permissionDenied(c *fiber.Ctx) error {
-	return c.Status(http.StatusUnauthorized).JSON(&models.Response{
-		Status:  "fail",
-		Message: "Unauthorized",
-	})
-}
+func getAPIKeyFromRequest(c *fiber.Ctx) string {
+	if key := c.Get("X-API-Key"); key != "" {
+		return strings.TrimSpace(key)
+	}
 
-func GetUserIDFromContext(ctx context.Context) string {
-	userID, ok := ctx.Value(UserKey).(string)
-	if !ok {
-		return "nil"
+	parts := strings.SplitN(c.Get("Authorization"), " ", 2)
+	if len(parts) == 2 && parts[0] == "ApiKey" {
+		return strings.TrimSpace(parts[1])
 	}
-	return userID
+	return ""
 }
@@ -0,0 +1,489 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	jwksRefreshInterval = time.Hour
+	// Unknown key IDs refetch the JWKS at most this often, so tokens with
+	// made-up kids cannot make every request hit the provider.
+	jwksMinRefetch = time.Minute
+	oidcStateTTL   = 10 * time.Minute
+)
+
+// OIDC is the configured identity provider, or nil when OIDC_ISSUER is unset
+// and only local accounts are available.
+var OIDC *OIDCProvider
+
+// OIDCIdentity is what the API takes from a verified ID token.
+type OIDCIdentity struct {
+	Issuer   string
+	Subject  string
+	Username string
+	Email    string
+	Name     string
+	Groups   []string
+	MFA      bool
+}
+
+type groupMapping struct {
+	group string
+	value string
+}
+
+// OIDCProvider runs the authorization-code flow with PKCE against one
+// identity provider and verifies its RS256 ID tokens against the JWKS.
+type OIDCProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	groupsClaim  string
+	groupRoles   []groupMapping
+	groupTenants []groupMapping
+	client       *http.Client
+	states       *mongo.Collection
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+	jwksAttemptAt time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	GroupsClaim  string
+	// GroupRoles and GroupEnterprises are "group=value" pairs. The first
+	// pair whose group the user belongs to wins, so list the most
+	// privileged roles first.
+	GroupRoles       []string
+	GroupEnterprises []string
+	HTTPClient       *http.Client
+}
+
+// oidcState is the server-side half of an in-flight login, keyed by a hash
+// of the state parameter and deleted when the callback redeems it.
+type oidcState struct {
+	StateHash    string    `bson:"_id"`
+	Nonce        string    `bson:"nonce"`
+	CodeVerifier string    `bson:"code_verifier"`
+	ExpiresAt    time.Time `bson:"expires_at"`
+}
+
+func NewOIDCProvider(db *mongo.Database, cfg OIDCConfig) *OIDCProvider {
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	groupsClaim := cfg.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+
+	return &OIDCProvider{
+		issuer:       strings.TrimSuffix(cfg.Issuer, "/"),
+		clientID:     cfg.ClientID,
+		clientSecret: cfg.ClientSecret,
+		redirectURL:  cfg.RedirectURL,
+		scopes:       scopes,
+		groupsClaim:  groupsClaim,
+		groupRoles:   parseGroupMappings(cfg.GroupRoles),
+		groupTenants: parseGroupMappings(cfg.GroupEnterprises),
+		client:       client,
+		states:       db.Collection("oidc_states"),
+	}
+}
+
+// SetupOIDC configures OIDC from the environment. Without OIDC_ISSUER the
+// OIDC endpoints answer 404.
+func SetupOIDC(db *mongo.Database) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		OIDC = nil
+		return
+	}
+
+	OIDC = NewOIDCProvider(db, OIDCConfig{
+		Issuer:           issuer,
+		ClientID:         os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:     os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:      os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:           splitList(os.Getenv("OIDC_SCOPES"), " "),
+		GroupsClaim:      os.Getenv("OIDC_GROUPS_CLAIM"),
+		GroupRoles:       splitList(os.Getenv("OIDC_GROUP_ROLES"), ","),
+		GroupEnterprises: splitList(os.Getenv("OIDC_GROUP_ENTERPRISES"), ","),
+	})
+
+	_, err := OIDC.states.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		log.Println("Could not create oidc state indexes:", err)
+	}
+}
+
+// Begin starts a login: it stores a fresh state, nonce and PKCE verifier
+// and returns the provider URL to redirect the browser to.
+func (p *OIDCProvider) Begin(ctx context.Context) (string, error) {
+	state, err := randomURLString(32)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomURLString(32)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomURLString(48)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = p.states.InsertOne(ctx, oidcState{
+		StateHash:    HashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	return p.AuthCodeURL(ctx, state, nonce, base64.RawURLEncoding.EncodeToString(challenge[:]))
+}
+
+// Complete redeems the state from the callback exactly once and exchanges
+// the code for a verified identity.
+func (p *OIDCProvider) Complete(ctx context.Context, state, code string) (*OIDCIdentity, error) {
+	var pending oidcState
+	err := p.states.FindOneAndDelete(ctx, bson.M{
+		"_id":        HashToken(state),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&pending)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("%w: unknown or expired state", ErrInvalidToken)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p.Exchange(ctx, code, pending.CodeVerifier, pending.Nonce)
+}
+
+// AuthCodeURL builds the redirect to the provider's authorization endpoint.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.clientID)
+	q.Set("redirect_uri", p.redirectURL)
+	q.Set("scope", strings.Join(p.scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified identity.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*OIDCIdentity, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	form.Set("client_id", p.clientID)
+	form.Set("code_verifier", codeVerifier)
+	if p.clientSecret != "" {
+		form.Set("client_secret", p.clientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc token endpoint returned %d", resp.StatusCode)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc token response has no id_token")
+	}
+	return p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// VerifyIDToken checks signature, issuer, audience, expiry and nonce.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, raw, nonce string) (*OIDCIdentity, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+	token, err := parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if !claims.VerifyIssuer(p.issuer, true) {
+		return nil, fmt.Errorf("%w: issuer", ErrInvalidToken)
+	}
+	if !claims.VerifyAudience(p.clientID, true) {
+		return nil, fmt.Errorf("%w: audience", ErrInvalidToken)
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, fmt.Errorf("%w: nonce", ErrInvalidToken)
+	}
+	if aud, ok := claims["aud"].([]interface{}); ok && len(aud) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.clientID {
+			return nil, fmt.Errorf("%w: azp", ErrInvalidToken)
+		}
+	}
+
+	identity := &OIDCIdentity{Issuer: p.issuer}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	identity.Username, _ = claims["preferred_username"].(string)
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("%w: subject", ErrInvalidToken)
+	}
+	identity.Groups = stringList(claims[p.groupsClaim])
+	for _, method := range stringList(claims["amr"]) {
+		if method == "mfa" || method == "otp" || method == "hwk" {
+			identity.MFA = true
+		}
+	}
+	return identity, nil
+}
+
+// MapGroups picks the role and enterprise for a set of IdP groups.
+func (p *OIDCProvider) MapGroups(groups []string) (role, enterprise string) {
+	member := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		member[g] = true
+	}
+	for _, m := range p.groupRoles {
+		if member[m.group] {
+			role = m.value
+			break
+		}
+	}
+	for _, m := range p.groupTenants {
+		if member[m.group] {
+			enterprise = m.value
+			break
+		}
+	}
+	return role, enterprise
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d oidcDiscovery
+	if err := p.getJSON(ctx, p.issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("oidc discovery issuer %q does not match %q", d.Issuer, p.issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("oidc discovery document is incomplete")
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// key returns the signing key for kid, refetching the JWKS when the key is
+// unknown (the provider rotated) or the cached set is stale. Fetches,
+// failed ones included, are at least jwksMinRefetch apart; in between an
+// unknown kid fails without asking the provider.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.keys[kid]; ok && time.Since(p.keysFetchedAt) < jwksRefreshInterval {
+		return k, nil
+	}
+	if time.Since(p.jwksAttemptAt) < jwksMinRefetch {
+		if k, ok := p.keys[kid]; ok {
+			return k, nil
+		}
+		return nil, fmt.Errorf("oidc signing key %q not found", kid)
+	}
+	p.jwksAttemptAt = time.Now()
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	k, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("oidc signing key %q not found", kid)
+	}
+	return k, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, endpoint string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func randomURLString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func parseGroupMappings(pairs []string) []groupMapping {
+	var mappings []groupMapping
+	for _, pair := range pairs {
+		group, value, ok := strings.Cut(pair, "=")
+		if !ok || group == "" || value == "" {
+			continue
+		}
+		mappings = append(mappings, groupMapping{group: strings.TrimSpace(group), value: strings.TrimSpace(value)})
+	}
+	return mappings
+}
+
+func splitList(raw, sep string) []string {
+	var out []string
+	for _, part := range strings.Split(raw, sep) {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func stringList(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		out := make([]string, 0, len(t))
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const testClientID = "grid-api"
+
+// stubIdP is a local identity provider: discovery, a JWKS with one RSA key
+// and a token endpoint that answers with idToken.
+type stubIdP struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	kid      string
+	idToken  string
+	verifier string
+	jwksHits atomic.Int32
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &stubIdP{key: key, kid: "key-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.jwksHits.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": idp.kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good-code" || r.FormValue("code_verifier") != idp.verifier {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.idToken})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// sign issues an ID token for the stub with claims on top of valid defaults.
+func (idp *stubIdP) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	base := jwt.MapClaims{
+		"iss":   idp.server.URL,
+		"aud":   testClientID,
+		"sub":   "user-42",
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+		"nonce": "n-1",
+	}
+	for k, v := range claims {
+		base[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, base)
+	token.Header["kid"] = kid
+	raw, err := token.SignedString(idp.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// provider points an OIDCProvider at idp. Its state collection is never
+// used by these tests, so the client does not need a running server.
+func (idp *stubIdP) provider(t *testing.T) *OIDCProvider {
+	t.Helper()
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return NewOIDCProvider(client.Database("oidc_test"), OIDCConfig{
+		Issuer:      idp.server.URL,
+		ClientID:    testClientID,
+		RedirectURL: "http://localhost/callback",
+		GroupRoles:  []string{"ops=grid_operator", "staff=analyst"},
+		HTTPClient:  idp.server.Client(),
+	})
+}
+
+func TestOIDCExchange(t *testing.T) {
+	idp := newStubIdP(t)
+	p := idp.provider(t)
+	idp.verifier = "verifier-1"
+	idp.idToken = idp.sign(t, idp.kid, jwt.MapClaims{
+		"preferred_username": "ada",
+		"email":              "ada@example.com",
+		"groups":             []string{"staff", "ops"},
+		"amr":                []string{"pwd", "mfa"},
+	})
+
+	identity, err := p.Exchange(context.Background(), "good-code", "verifier-1", "n-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Subject != "user-42" || identity.Username != "ada" || identity.Email != "ada@example.com" || !identity.MFA {
+		t.Errorf("identity = %+v", identity)
+	}
+	if role, _ := p.MapGroups(identity.Groups); role != "grid_operator" {
+		t.Errorf("role = %q, want grid_operator", role)
+	}
+
+	if _, err := p.Exchange(context.Background(), "good-code", "wrong-verifier", "n-1"); err == nil {
+		t.Error("exchange with the wrong PKCE verifier succeeded")
+	}
+}
+
+func TestOIDCVerifyIDTokenRejects(t *testing.T) {
+	idp := newStubIdP(t)
+	p := idp.provider(t)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": idp.server.URL, "aud": testClientID, "sub": "user-42", "nonce": "n-1",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	forged.Header["kid"] = idp.kid
+	forgedRaw, err := forged.SignedString(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"wrong nonce":    idp.sign(t, idp.kid, jwt.MapClaims{"nonce": "n-2"}),
+		"wrong audience": idp.sign(t, idp.kid, jwt.MapClaims{"aud": "someone-else"}),
+		"wrong issuer":   idp.sign(t, idp.kid, jwt.MapClaims{"iss": "https://evil.example"}),
+		"expired":        idp.sign(t, idp.kid, jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}),
+		"no subject":     idp.sign(t, idp.kid, jwt.MapClaims{"sub": ""}),
+		"foreign azp":    idp.sign(t, idp.kid, jwt.MapClaims{"aud": []string{testClientID, "other"}, "azp": "other"}),
+		"forged":         forgedRaw,
+	}
+	for name, raw := range tests {
+		if _, err := p.VerifyIDToken(context.Background(), raw, "n-1"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
+
+func TestOIDCUnknownKidRefetchIsLimited(t *testing.T) {
+	idp := newStubIdP(t)
+	p := idp.provider(t)
+	ctx := context.Background()
+
+	if _, err := p.VerifyIDToken(ctx, idp.sign(t, idp.kid, nil), "n-1"); err != nil {
+		t.Fatal(err)
+	}
+	if hits := idp.jwksHits.Load(); hits != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", hits)
+	}
+
+	// A burst of tokens with unknown kids asks the provider at most once.
+	for i := 0; i < 5; i++ {
+		if _, err := p.VerifyIDToken(ctx, idp.sign(t, "made-up", nil), "n-1"); err == nil {
+			t.Fatal("token with an unknown kid verified")
+		}
+	}
+	if hits := idp.jwksHits.Load(); hits != 1 {
+		t.Errorf("JWKS fetched %d times for unknown kids within %v, want 1", hits, jwksMinRefetch)
+	}
+
+	// Once the interval has passed a rotated key is picked up.
+	idp.kid = "key-2"
+	p.mu.Lock()
+	p.jwksAttemptAt = time.Now().Add(-jwksMinRefetch)
+	p.mu.Unlock()
+	if _, err := p.VerifyIDToken(ctx, idp.sign(t, "key-2", nil), "n-1"); err != nil {
+		t.Fatalf("rotated key: %v", err)
+	}
+	if hits := idp.jwksHits.Load(); hits != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", hits)
+	}
+}
@@ -0,0 +1,320 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LimiterStore keeps fixed-window counters. A window opens on the first hit
+// of a key and lasts for the window passed with that hit.
+type LimiterStore interface {
+	Hit(ctx context.Context, key string, window time.Duration) (count int, resetAt time.Time, err error)
+	Peek(ctx context.Context, key string) (count int, resetAt time.Time, err error)
+	Reset(ctx context.Context, key string) error
+}
+
+var (
+	// Limiter backs every rate limit and the login lockout. It is set up by
+	// SetupRateLimiter and defaults to in-process memory.
+	Limiter LimiterStore = NewMemoryLimiterStore()
+
+	// Lockout guards /api/login against password guessing.
+	Lockout = NewLoginLockout(Limiter, 5, 30*time.Second, time.Hour)
+)
+
+// SetupRateLimiter picks the limiter store from RATE_LIMIT_STORE. "mongo"
+// shares counters between API instances; anything else keeps them in memory.
+func SetupRateLimiter(db *mongo.Database) {
+	if os.Getenv("RATE_LIMIT_STORE") == "mongo" {
+		store := NewMongoLimiterStore(db)
+		_, err := store.counters.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+			Keys:    bson.D{{Key: "reset_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		})
+		if err != nil {
+			log.Println("Could not create rate limit indexes:", err)
+		}
+		Limiter = store
+	} else {
+		Limiter = NewMemoryLimiterStore()
+	}
+
+	Lockout = NewLoginLockout(Limiter,
+		envInt("LOGIN_LOCKOUT_THRESHOLD", 5),
+		time.Duration(envInt("LOGIN_LOCKOUT_BASE_SECONDS", 30))*time.Second,
+		time.Duration(envInt("LOGIN_LOCKOUT_MAX_SECONDS", 3600))*time.Second,
+	)
+}
+
+// RateLimit allows max requests per window for each key returned by keyFn.
+// Limits are read from env as "<max>/<duration>", e.g. LOGIN_IP_RATE_LIMIT=10/1m.
+// If the store is unavailable requests are let through rather than failing
+// the whole API.
+func RateLimit(name, env string, max int, window time.Duration, keyFn func(*fiber.Ctx) string) fiber.Handler {
+	max, window = limitFromEnv(env, max, window)
+
+	return func(c *fiber.Ctx) error {
+		key := keyFn(c)
+		if key == "" {
+			return c.Next()
+		}
+
+		count, resetAt, err := Limiter.Hit(c.Context(), "rl:"+name+":"+key, window)
+		if err != nil {
+			log.Printf("rate limit %s: %v", name, err)
+			return c.Next()
+		}
+		if count > max {
+			return tooManyRequests(c, time.Until(resetAt))
+		}
+		return c.Next()
+	}
+}
+
+// ByIP keys a limit on the client address.
+func ByIP(c *fiber.Ctx) string {
+	return c.IP()
+}
+
+// ByPrincipal keys a limit on the authenticated caller, falling back to the
+// client address.
+func ByPrincipal(c *fiber.Ctx) string {
+	if p, ok := GetPrincipal(c); ok {
+		return p.Kind + ":" + p.UserID
+	}
+	return c.IP()
+}
+
+// ByUsername keys a limit on the username in a JSON body.
+func ByUsername(c *fiber.Ctx) string {
+	var body struct {
+		Username string `json:"username"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return ""
+	}
+	return strings.ToLower(body.Username)
+}
+
+func tooManyRequests(c *fiber.Ctx, retryAfter time.Duration) error {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "Too many requests"})
+}
+
+// LoginLockout locks a username after threshold consecutive failures. Each
+// further failure after a lock expires doubles the lock, up to max.
+type LoginLockout struct {
+	store     LimiterStore
+	threshold int
+	base      time.Duration
+	max       time.Duration
+}
+
+func NewLoginLockout(store LimiterStore, threshold int, base, max time.Duration) *LoginLockout {
+	return &LoginLockout{store: store, threshold: threshold, base: base, max: max}
+}
+
+// Locked returns how long the username stays locked, or 0.
+func (l *LoginLockout) Locked(ctx context.Context, username string) (time.Duration, error) {
+	count, resetAt, err := l.store.Peek(ctx, "lock:"+strings.ToLower(username))
+	if err != nil || count == 0 {
+		return 0, err
+	}
+	return time.Until(resetAt), nil
+}
+
+func (l *LoginLockout) RecordFailure(ctx context.Context, username string) error {
+	username = strings.ToLower(username)
+	// Failures are forgotten a day after the first one in a streak.
+	failures, _, err := l.store.Hit(ctx, "fail:"+username, 24*time.Hour)
+	if err != nil || failures < l.threshold {
+		return err
+	}
+
+	lock := l.base << uint(failures-l.threshold)
+	if lock <= 0 || lock > l.max {
+		lock = l.max
+	}
+	_, _, err = l.store.Hit(ctx, "lock:"+username, lock)
+	return err
+}
+
+func (l *LoginLockout) RecordSuccess(ctx context.Context, username string) error {
+	username = strings.ToLower(username)
+	if err := l.store.Reset(ctx, "fail:"+username); err != nil {
+		return err
+	}
+	return l.store.Reset(ctx, "lock:"+username)
+}
+
+// RetryAfter writes a 429 for a locked account.
+func RetryAfter(c *fiber.Ctx, wait time.Duration) error {
+	return tooManyRequests(c, wait)
+}
+
+type limiterEntry struct {
+	count   int
+	resetAt time.Time
+}
+
+type MemoryLimiterStore struct {
+	mu      sync.Mutex
+	entries map[string]*limiterEntry
+	hits    int
+}
+
+func NewMemoryLimiterStore() *MemoryLimiterStore {
+	return &MemoryLimiterStore{entries: make(map[string]*limiterEntry)}
+}
+
+func (s *MemoryLimiterStore) Hit(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.hits++
+	if s.hits%1000 == 0 {
+		for k, e := range s.entries {
+			if !e.resetAt.After(now) {
+				delete(s.entries, k)
+			}
+		}
+	}
+
+	e, ok := s.entries[key]
+	if !ok || !e.resetAt.After(now) {
+		e = &limiterEntry{resetAt: now.Add(window)}
+		s.entries[key] = e
+	}
+	e.count++
+	return e.count, e.resetAt, nil
+}
+
+func (s *MemoryLimiterStore) Peek(ctx context.Context, key string) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || !e.resetAt.After(time.Now()) {
+		return 0, time.Time{}, nil
+	}
+	return e.count, e.resetAt, nil
+}
+
+func (s *MemoryLimiterStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	delete(s.entries, key)
+	s.mu.Unlock()
+	return nil
+}
+
+// MongoLimiterStore shares counters between API instances through the
+// rate_limits collection. Expired windows are removed by a TTL index.
+type MongoLimiterStore struct {
+	counters *mongo.Collection
+}
+
+func NewMongoLimiterStore(db *mongo.Database) *MongoLimiterStore {
+	return &MongoLimiterStore{counters: db.Collection("rate_limits")}
+}
+
+type limiterDoc struct {
+	Key     string    `bson:"_id"`
+	Count   int       `bson:"count"`
+	ResetAt time.Time `bson:"reset_at"`
+}
+
+func (s *MongoLimiterStore) Hit(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		now := time.Now()
+
+		var doc limiterDoc
+		err := s.counters.FindOneAndUpdate(ctx,
+			bson.M{"_id": key, "reset_at": bson.M{"$gt": now}},
+			bson.M{"$inc": bson.M{"count": 1}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&doc)
+		if err == nil {
+			return doc.Count, doc.ResetAt, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return 0, time.Time{}, err
+		}
+
+		// No open window: start one. If another instance opened it first
+		// the upsert hits a duplicate key and we retry the increment.
+		resetAt := now.Add(window)
+		_, err = s.counters.UpdateOne(ctx,
+			bson.M{"_id": key, "reset_at": bson.M{"$lte": now}},
+			bson.M{"$set": bson.M{"count": 1, "reset_at": resetAt}},
+			options.Update().SetUpsert(true),
+		)
+		if err == nil {
+			return 1, resetAt, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return 0, time.Time{}, err
+		}
+	}
+	return 0, time.Time{}, mongo.ErrNoDocuments
+}
+
+func (s *MongoLimiterStore) Peek(ctx context.Context, key string) (int, time.Time, error) {
+	var doc limiterDoc
+	err := s.counters.FindOne(ctx, bson.M{"_id": key, "reset_at": bson.M{"$gt": time.Now()}}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return 0, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return doc.Count, doc.ResetAt, nil
+}
+
+func (s *MongoLimiterStore) Reset(ctx context.Context, key string) error {
+	_, err := s.counters.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+func limitFromEnv(env string, max int, window time.Duration) (int, time.Duration) {
+	raw := os.Getenv(env)
+	if raw == "" {
+		return max, window
+	}
+	countPart, windowPart, ok := strings.Cut(raw, "/")
+	n, err1 := strconv.Atoi(countPart)
+	d, err2 := time.ParseDuration(windowPart)
+	if !ok || err1 != nil || err2 != nil || n <= 0 || d <= 0 {
+		log.Printf("Error parsing %s=%q, using %d/%s", env, raw, max, window)
+		return max, window
+	}
+	return n, d
+}
+
+func envInt(key string, fallback int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		log.Printf("Error parsing %s=%q, using %d", key, raw, fallback)
+		return fallback
+	}
+	return n
+}
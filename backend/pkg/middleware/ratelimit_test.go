@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLoginLockoutDoublesUpToMax(t *testing.T) {
+	ctx := context.Background()
+	base, max := 40*time.Millisecond, 200*time.Millisecond
+	lockout := NewLoginLockout(NewMemoryLimiterStore(), 3, base, max)
+	fail := func() {
+		t.Helper()
+		if err := lockout.RecordFailure(ctx, "Ann"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	locked := func() time.Duration {
+		t.Helper()
+		wait, err := lockout.Locked(ctx, "ann")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wait
+	}
+
+	fail()
+	fail()
+	if wait := locked(); wait != 0 {
+		t.Fatalf("locked for %v below the threshold", wait)
+	}
+	fail()
+	if wait := locked(); wait <= 0 || wait > base {
+		t.Fatalf("first lock %v, want up to %v", wait, base)
+	}
+	// A failure while locked counts but does not extend the lock.
+	fail()
+	if wait := locked(); wait > base {
+		t.Fatalf("lock grew to %v while locked", wait)
+	}
+
+	// The fifth failure in a row locks for base doubled twice.
+	time.Sleep(base + 10*time.Millisecond)
+	fail()
+	if wait := locked(); wait <= 2*base || wait > 4*base {
+		t.Fatalf("lock after five failures %v, want up to %v", wait, 4*base)
+	}
+	time.Sleep(4*base + 10*time.Millisecond)
+	fail()
+	if wait := locked(); wait <= 4*base || wait > max {
+		t.Fatalf("lock after six failures %v, want capped at %v", wait, max)
+	}
+
+	if err := lockout.RecordSuccess(ctx, "ANN"); err != nil {
+		t.Fatal(err)
+	}
+	if wait := locked(); wait != 0 {
+		t.Errorf("still locked for %v after a successful login", wait)
+	}
+	fail()
+	if wait := locked(); wait != 0 {
+		t.Errorf("a success did not reset the failure streak: locked for %v", wait)
+	}
+}
+
+type failingStore struct{ err error }
+
+func (s failingStore) Hit(context.Context, string, time.Duration) (int, time.Time, error) {
+	return 0, time.Time{}, s.err
+}
+func (s failingStore) Peek(context.Context, string) (int, time.Time, error) {
+	return 0, time.Time{}, s.err
+}
+func (s failingStore) Reset(context.Context, string) error { return s.err }
+
+func TestLoginLockoutReportsStoreErrors(t *testing.T) {
+	down := errors.New("store down")
+	lockout := NewLoginLockout(failingStore{down}, 3, time.Second, time.Minute)
+	ctx := context.Background()
+	if err := lockout.RecordFailure(ctx, "ann"); !errors.Is(err, down) {
+		t.Errorf("RecordFailure err = %v, want the store error", err)
+	}
+	if _, err := lockout.Locked(ctx, "ann"); !errors.Is(err, down) {
+		t.Errorf("Locked err = %v, want the store error", err)
+	}
+}
+
+func TestRateLimitRejectsOverMax(t *testing.T) {
+	previous := Limiter
+	Limiter = NewMemoryLimiterStore()
+	t.Cleanup(func() { Limiter = previous })
+
+	app := fiber.New()
+	app.Get("/", RateLimit("test", "", 2, time.Minute, ByIP), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	for i, want := range []int{fiber.StatusNoContent, fiber.StatusNoContent, fiber.StatusTooManyRequests} {
+		res, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != want {
+			t.Errorf("request %d: status %d, want %d", i+1, res.StatusCode, want)
+		}
+		if want == fiber.StatusTooManyRequests && res.Header.Get(fiber.HeaderRetryAfter) == "" {
+			t.Error("429 without Retry-After")
+		}
+	}
+}
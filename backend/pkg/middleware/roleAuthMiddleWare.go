@@ -1,26 +1,386 @@
 package middleware
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
 	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func RoleMiddleware(requiredRole string) fiber.Handler {
+const roleCacheTTL = 30 * time.Second
+
+var (
+	ErrUnknownRole       = errors.New("unknown role")
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrRoleCycle         = errors.New("role inheritance cycle")
+
+	// Roles is the process-wide role store, set up by SetupRoleStore.
+	Roles *RoleStore
+)
+
+// RoleStore resolves roles, including inherited ones, to permission sets.
+// Definitions live in MongoDB and are cached briefly in memory.
+type RoleStore struct {
+	roles       *mongo.Collection
+	permissions *mongo.Collection
+
+	mu          sync.RWMutex
+	resolved    map[string]map[string]bool
+	mfaRequired map[string]bool
+	loadedAt    time.Time
+}
+
+func NewRoleStore(db *mongo.Database) *RoleStore {
+	return &RoleStore{
+		roles:       db.Collection("roles"),
+		permissions: db.Collection("permissions"),
+	}
+}
+
+// SetupRoleStore builds Roles and seeds the default roles and permissions.
+// Defaults are only written when missing, so edited roles keep their
+// changes across restarts, removed permissions included.
+func SetupRoleStore(db *mongo.Database) {
+	Roles = NewRoleStore(db)
+
+	ctx := context.TODO()
+	upsert := options.Update().SetUpsert(true)
+	for _, p := range models.DefaultPermissions {
+		_, err := Roles.permissions.UpdateOne(ctx, bson.M{"_id": p.Name}, bson.M{
+			"$setOnInsert": bson.M{"description": p.Description, "require_mfa": p.RequireMFA},
+		}, upsert)
+		if err != nil {
+			log.Fatal("Could not seed permissions:", err)
+		}
+	}
+	for _, r := range models.DefaultRoles {
+		_, err := Roles.roles.UpdateOne(ctx, bson.M{"_id": r.Name}, bson.M{
+			"$setOnInsert": bson.M{"description": r.Description, "inherits": r.Inherits, "permissions": r.Permissions},
+		}, upsert)
+		if err != nil {
+			log.Fatal("Could not seed roles:", err)
+		}
+	}
+}
+
+// Permissions returns the effective permission set of a role.
+func (s *RoleStore) Permissions(ctx context.Context, role string) (map[string]bool, error) {
+	if err := s.ensureLoaded(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	perms, ok := s.resolved[role]
+	if !ok {
+		return nil, ErrUnknownRole
+	}
+	return perms, nil
+}
+
+// RequiresMFA reports whether any of perms may only be used after MFA.
+func (s *RoleStore) RequiresMFA(ctx context.Context, perms ...string) (bool, error) {
+	if err := s.ensureLoaded(ctx); err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, p := range perms {
+		if s.mfaRequired[p] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetPermissionMFA toggles the MFA requirement of a permission.
+func (s *RoleStore) SetPermissionMFA(ctx context.Context, name string, required bool) (bool, error) {
+	result, err := s.permissions.UpdateOne(ctx, bson.M{"_id": name}, bson.M{"$set": bson.M{"require_mfa": required}})
+	if err != nil {
+		return false, err
+	}
+	s.Invalidate()
+	return result.MatchedCount > 0, nil
+}
+
+// CanGrant reports whether a holder of actorRole may hand out targetRole.
+// Nobody can grant a role that carries permissions they do not have.
+func (s *RoleStore) CanGrant(ctx context.Context, actorRole, targetRole string) (bool, error) {
+	actorPerms, err := s.Permissions(ctx, actorRole)
+	if err != nil {
+		return false, nil
+	}
+	targetPerms, err := s.Permissions(ctx, targetRole)
+	if err != nil {
+		return false, err
+	}
+	return isSubset(targetPerms, actorPerms), nil
+}
+
+func (s *RoleStore) List(ctx context.Context) ([]models.Role, []models.Permission, error) {
+	var roles []models.Role
+	cursor, err := s.roles.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cursor.All(ctx, &roles); err != nil {
+		return nil, nil, err
+	}
+
+	var permissions []models.Permission
+	cursor, err = s.permissions.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cursor.All(ctx, &permissions); err != nil {
+		return nil, nil, err
+	}
+	return roles, permissions, nil
+}
+
+// Get returns a stored role definition, or nil if it does not exist.
+func (s *RoleStore) Get(ctx context.Context, name string) (*models.Role, error) {
+	var role models.Role
+	err := s.roles.FindOne(ctx, bson.M{"_id": name}).Decode(&role)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// Save validates and stores a role definition, then drops the cache.
+func (s *RoleStore) Save(ctx context.Context, role models.Role) error {
+	roles, permissions, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	knownPerms := make(map[string]bool, len(permissions))
+	for _, p := range permissions {
+		knownPerms[p.Name] = true
+	}
+	for _, p := range role.Permissions {
+		if !knownPerms[p] {
+			return fmt.Errorf("%w: %s", ErrUnknownPermission, p)
+		}
+	}
+
+	defs := make(map[string]models.Role, len(roles)+1)
+	for _, r := range roles {
+		defs[r.Name] = r
+	}
+	defs[role.Name] = role
+	for _, parent := range role.Inherits {
+		if _, ok := defs[parent]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownRole, parent)
+		}
+	}
+	if _, err := resolveRoles(defs); err != nil {
+		return err
+	}
+
+	_, err = s.roles.ReplaceOne(ctx, bson.M{"_id": role.Name}, role, options.Replace().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	s.Invalidate()
+	return nil
+}
+
+func (s *RoleStore) Invalidate() {
+	s.mu.Lock()
+	s.loadedAt = time.Time{}
+	s.mu.Unlock()
+}
+
+func (s *RoleStore) ensureLoaded(ctx context.Context) error {
+	s.mu.RLock()
+	fresh := time.Since(s.loadedAt) < roleCacheTTL
+	s.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	var roles []models.Role
+	cursor, err := s.roles.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(ctx, &roles); err != nil {
+		return err
+	}
+
+	defs := make(map[string]models.Role, len(roles))
+	for _, r := range roles {
+		defs[r.Name] = r
+	}
+	resolved, err := resolveRoles(defs)
+	if err != nil {
+		return err
+	}
+
+	var permissions []models.Permission
+	cursor, err = s.permissions.Find(ctx, bson.M{"require_mfa": true})
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(ctx, &permissions); err != nil {
+		return err
+	}
+	mfaRequired := make(map[string]bool, len(permissions))
+	for _, p := range permissions {
+		mfaRequired[p.Name] = true
+	}
+
+	s.mu.Lock()
+	s.resolved = resolved
+	s.mfaRequired = mfaRequired
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// resolveRoles flattens inheritance into one permission set per role.
+func resolveRoles(defs map[string]models.Role) (map[string]map[string]bool, error) {
+	resolved := make(map[string]map[string]bool, len(defs))
+	visiting := make(map[string]bool)
+
+	var resolve func(name string) (map[string]bool, error)
+	resolve = func(name string) (map[string]bool, error) {
+		if perms, ok := resolved[name]; ok {
+			return perms, nil
+		}
+		if visiting[name] {
+			return nil, fmt.Errorf("%w: %s", ErrRoleCycle, name)
+		}
+		def, ok := defs[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownRole, name)
+		}
+
+		visiting[name] = true
+		perms := make(map[string]bool)
+		for _, parent := range def.Inherits {
+			inherited, err := resolve(parent)
+			if err != nil {
+				return nil, err
+			}
+			for p := range inherited {
+				perms[p] = true
+			}
+		}
+		for _, p := range def.Permissions {
+			perms[p] = true
+		}
+		visiting[name] = false
+
+		resolved[name] = perms
+		return perms, nil
+	}
+
+	for name := range defs {
+		if _, err := resolve(name); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
+func isSubset(sub, super map[string]bool) bool {
+	for p := range sub {
+		if !super[p] {
+			return false
+		}
+	}
+	return true
+}
+
+// PrincipalPermissions returns what the caller may do: the resolved role for
+// users, the key scopes for service accounts.
+func PrincipalPermissions(ctx context.Context, principal *Principal) (map[string]bool, error) {
+	if principal.Kind == PrincipalService {
+		perms := make(map[string]bool, len(principal.Scopes))
+		for _, scope := range principal.Scopes {
+			perms[scope] = true
+		}
+		return perms, nil
+	}
+	if principal.Role == "" {
+		return map[string]bool{}, nil
+	}
+	perms, err := Roles.Permissions(ctx, principal.Role)
+	if errors.Is(err, ErrUnknownRole) {
+		return map[string]bool{}, nil
+	}
+	return perms, err
+}
+
+// HasPermission reports whether the authenticated caller holds perm.
+func HasPermission(c *fiber.Ctx, perm string) bool {
+	principal, ok := GetPrincipal(c)
+	if !ok {
+		return false
+	}
+	perms, err := PrincipalPermissions(c.Context(), principal)
+	if err != nil {
+		return false
+	}
+	return perms[perm]
+}
+
+// RequirePermission lets the request through only if the caller holds every
+// listed permission, and has used MFA if any of them demands it. It must run
+// after WithJWTAuth.
+func RequirePermission(required ...string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		userRole, ok := c.Locals("userRole").(string)
-		if !ok || userRole == "" {
+		principal, ok := GetPrincipal(c)
+		if !ok {
 			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Unauthorized: User role not found",
+				"error": "Unauthorized",
 			})
 		}
 
-		if userRole != requiredRole && userRole != "admin" {
-			return c.Status(http.StatusForbidden).JSON(fiber.Map{
-				"error": "Access denied: Insufficient permissions",
+		perms, err := PrincipalPermissions(c.Context(), principal)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not resolve permissions",
 			})
 		}
 
+		for _, p := range required {
+			if !perms[p] {
+				return c.Status(http.StatusForbidden).JSON(fiber.Map{
+					"error": "Access denied: Insufficient permissions",
+				})
+			}
+		}
+
+		if !principal.MFA {
+			needsMFA, err := Roles.RequiresMFA(c.Context(), required...)
+			if err != nil {
+				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Could not resolve permissions",
+				})
+			}
+			if needsMFA {
+				return c.Status(http.StatusForbidden).JSON(fiber.Map{
+					"error":        "Multi-factor authentication required",
+					"mfa_required": true,
+				})
+			}
+		}
+
 		return c.Next()
 	}
 }
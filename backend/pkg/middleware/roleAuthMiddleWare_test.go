@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestSetupRoleStoreKeepsEdits(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; this test needs MongoDB")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+	database := client.Database("roles_test_" + primitive.NewObjectID().Hex())
+	defer database.Drop(context.Background())
+
+	SetupRoleStore(database)
+	role := models.DefaultRoles[0]
+	removed := role.Permissions[0]
+	if _, err := database.Collection("roles").UpdateOne(ctx,
+		bson.M{"_id": role.Name}, bson.M{"$pull": bson.M{"permissions": removed}},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart seeds again and must not bring the permission back.
+	SetupRoleStore(database)
+	var stored models.Role
+	if err := database.Collection("roles").FindOne(ctx, bson.M{"_id": role.Name}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range stored.Permissions {
+		if p == removed {
+			t.Fatalf("%s regained %s after a restart", role.Name, removed)
+		}
+	}
+	if len(stored.Permissions) != len(role.Permissions)-1 {
+		t.Errorf("%s has %d permissions, want %d", role.Name, len(stored.Permissions), len(role.Permissions)-1)
+	}
+}
+
+func TestResolveRolesInherits(t *testing.T) {
+	defs := map[string]models.Role{}
+	for _, r := range models.DefaultRoles {
+		defs[r.Name] = r
+	}
+	resolved, err := resolveRoles(defs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// admin inherits grid_operator, which inherits analyst.
+	for _, p := range []string{models.PermUserManage, models.PermGridBalance, models.PermGridRead} {
+		if !resolved[models.RoleAdmin][p] {
+			t.Errorf("admin lacks %s", p)
+		}
+	}
+	if resolved[models.RoleAnalyst][models.PermGridWrite] {
+		t.Error("analyst inherited grid:write from a role below it")
+	}
+	if !isSubset(resolved[models.RoleAnalyst], resolved[models.RoleAdmin]) {
+		t.Error("admin does not hold every analyst permission")
+	}
+
+	_, err = resolveRoles(map[string]models.Role{
+		"a": {Name: "a", Inherits: []string{"b"}},
+		"b": {Name: "b", Inherits: []string{"a"}},
+	})
+	if !errors.Is(err, ErrRoleCycle) {
+		t.Errorf("cycle: err = %v, want ErrRoleCycle", err)
+	}
+	_, err = resolveRoles(map[string]models.Role{"a": {Name: "a", Inherits: []string{"missing"}}})
+	if !errors.Is(err, ErrUnknownRole) {
+		t.Errorf("unknown parent: err = %v, want ErrUnknownRole", err)
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	// A store whose cache is already loaded never reaches MongoDB.
+	previous := Roles
+	Roles = &RoleStore{
+		resolved: map[string]map[string]bool{
+			"viewer":   {models.PermGridRead: true},
+			"operator": {models.PermGridRead: true, models.PermGridBalance: true},
+		},
+		mfaRequired: map[string]bool{models.PermGridBalance: true},
+		loadedAt:    time.Now().Add(time.Hour),
+	}
+	t.Cleanup(func() { Roles = previous })
+
+	status := func(principal *Principal, required ...string) int {
+		t.Helper()
+		app := fiber.New()
+		app.Get("/", func(c *fiber.Ctx) error {
+			if principal != nil {
+				c.Locals(PrincipalKey, principal)
+			}
+			return c.Next()
+		}, RequirePermission(required...), func(c *fiber.Ctx) error {
+			return c.SendStatus(fiber.StatusNoContent)
+		})
+		res, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode
+	}
+
+	cases := []struct {
+		name      string
+		principal *Principal
+		required  []string
+		want      int
+	}{
+		{"anonymous", nil, []string{models.PermGridRead}, fiber.StatusUnauthorized},
+		{"granted", &Principal{Kind: PrincipalUser, Role: "viewer"}, []string{models.PermGridRead}, fiber.StatusNoContent},
+		{"missing one", &Principal{Kind: PrincipalUser, Role: "viewer"}, []string{models.PermGridRead, models.PermGridWrite}, fiber.StatusForbidden},
+		{"unknown role", &Principal{Kind: PrincipalUser, Role: "ghost"}, []string{models.PermGridRead}, fiber.StatusForbidden},
+		{"no role", &Principal{Kind: PrincipalUser}, []string{models.PermGridRead}, fiber.StatusForbidden},
+		{"mfa required", &Principal{Kind: PrincipalUser, Role: "operator"}, []string{models.PermGridBalance}, fiber.StatusForbidden},
+		{"mfa used", &Principal{Kind: PrincipalUser, Role: "operator", MFA: true}, []string{models.PermGridBalance}, fiber.StatusNoContent},
+		{"service scope", &Principal{Kind: PrincipalService, Role: "operator", Scopes: []string{models.PermGridRead}}, []string{models.PermGridRead}, fiber.StatusNoContent},
+		{"service ignores role", &Principal{Kind: PrincipalService, Role: "operator", Scopes: []string{models.PermGridRead}}, []string{models.PermGridWrite}, fiber.StatusForbidden},
+	}
+	for _, tc := range cases {
+		if got := status(tc.principal, tc.required...); got != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
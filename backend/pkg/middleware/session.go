@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultRefreshTTL = 7 * 24 * time.Hour
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+
+	// Sessions is the process-wide session store, set up by SetupSessionStore.
+	Sessions *SessionStore
+)
+
+// SessionStore keeps login sessions and their rotating refresh tokens in
+// MongoDB.
+type SessionStore struct {
+	sessions      *mongo.Collection
+	refreshTokens *mongo.Collection
+	ttl           time.Duration
+}
+
+func NewSessionStore(db *mongo.Database, ttl time.Duration) *SessionStore {
+	return &SessionStore{
+		sessions:      db.Collection("sessions"),
+		refreshTokens: db.Collection("refresh_tokens"),
+		ttl:           ttl,
+	}
+}
+
+func SetupSessionStore(db *mongo.Database) {
+	ttl := defaultRefreshTTL
+	if raw := os.Getenv("REFRESHEXPINSEC"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds <= 0 {
+			log.Println("Error parsing refresh token expiration time:", raw)
+		} else {
+			ttl = time.Duration(seconds) * time.Second
+		}
+	}
+
+	Sessions = NewSessionStore(db, ttl)
+
+	_, err := Sessions.refreshTokens.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		log.Println("Could not create refresh token indexes:", err)
+	}
+}
+
+// Create starts a new session for a user. mfa records whether the login
+// included a second factor; every token rotated from it inherits that.
+func (s *SessionStore) Create(ctx context.Context, userID primitive.ObjectID, userAgent, ip string, mfa bool) (*models.Session, error) {
+	now := time.Now()
+	session := &models.Session{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		UserAgent: userAgent,
+		IP:        ip,
+		MFA:       mfa,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	}
+	if _, err := s.sessions.InsertOne(ctx, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// IssueRefreshToken adds a fresh refresh token to the session's family.
+// Refresh tokens never outlive their session.
+func (s *SessionStore) IssueRefreshToken(ctx context.Context, session *models.Session) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	raw := base64.RawURLEncoding.EncodeToString(buf)
+
+	token := models.RefreshToken{
+		ID:        primitive.NewObjectID(),
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		TokenHash: HashToken(raw),
+		CreatedAt: time.Now(),
+		ExpiresAt: session.ExpiresAt,
+	}
+	if _, err := s.refreshTokens.InsertOne(ctx, token); err != nil {
+		return "", time.Time{}, err
+	}
+	return raw, token.ExpiresAt, nil
+}
+
+// Consume redeems a refresh token exactly once and returns its session.
+// Presenting a token that was already redeemed revokes the whole family,
+// since either the legitimate client or an attacker is holding a stale copy.
+func (s *SessionStore) Consume(ctx context.Context, raw string) (*models.Session, error) {
+	hash := HashToken(raw)
+	now := time.Now()
+
+	var token models.RefreshToken
+	err := s.refreshTokens.FindOneAndUpdate(ctx,
+		bson.M{"token_hash": hash, "used_at": nil},
+		bson.M{"$set": bson.M{"used_at": now}},
+	).Decode(&token)
+	if err == mongo.ErrNoDocuments {
+		var reused models.RefreshToken
+		if s.refreshTokens.FindOne(ctx, bson.M{"token_hash": hash}).Decode(&reused) == nil {
+			if err := s.Revoke(ctx, reused.SessionID, "refresh token reuse"); err != nil {
+				return nil, err
+			}
+			return nil, ErrRefreshTokenReused
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token.ExpiresAt.Before(now) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	session, err := s.active(ctx, token.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	return session, nil
+}
+
+// IsActive reports whether a session exists, is unexpired and not revoked.
+func (s *SessionStore) IsActive(ctx context.Context, sessionID primitive.ObjectID) (bool, error) {
+	session, err := s.active(ctx, sessionID)
+	return session != nil, err
+}
+
+func (s *SessionStore) Revoke(ctx context.Context, sessionID primitive.ObjectID, reason string) error {
+	_, err := s.sessions.UpdateOne(ctx,
+		bson.M{"_id": sessionID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now(), "revoked_reason": reason}},
+	)
+	return err
+}
+
+// RevokeAllForUser ends every session of a user, optionally sparing one.
+func (s *SessionStore) RevokeAllForUser(ctx context.Context, userID primitive.ObjectID, except primitive.ObjectID, reason string) error {
+	filter := bson.M{"user_id": userID, "revoked_at": nil}
+	if !except.IsZero() {
+		filter["_id"] = bson.M{"$ne": except}
+	}
+	_, err := s.sessions.UpdateMany(ctx, filter,
+		bson.M{"$set": bson.M{"revoked_at": time.Now(), "revoked_reason": reason}},
+	)
+	return err
+}
+
+func (s *SessionStore) active(ctx context.Context, sessionID primitive.ObjectID) (*models.Session, error) {
+	var session models.Session
+	err := s.sessions.FindOne(ctx, bson.M{
+		"_id":        sessionID,
+		"revoked_at": nil,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// HashToken is the hex SHA-256 stored in place of an opaque secret such as
+// a refresh token, API key secret or password reset token. The secrets are
+// random, so a fast unsalted hash is enough.
+func HashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
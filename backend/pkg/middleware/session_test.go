@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testDatabase returns a throwaway database on the MongoDB at
+// MONGO_TEST_URI, skipping the test when none is configured.
+func testDatabase(t *testing.T, prefix string) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set; this test needs MongoDB")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	database := client.Database(prefix + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		database.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+	return database
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	store := NewSessionStore(testDatabase(t, "sessions_test_"), time.Hour)
+	ctx := context.Background()
+
+	session, err := store.Create(ctx, primitive.NewObjectID(), "test", "127.0.0.1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _, err := store.IssueRefreshToken(ctx, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Consume(ctx, first); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	// The client rotates to a new token; a stale copy of the old one then
+	// shows up again.
+	second, _, err := store.IssueRefreshToken(ctx, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Consume(ctx, first); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reuse: err = %v, want ErrRefreshTokenReused", err)
+	}
+
+	if active, err := store.IsActive(ctx, session.ID); err != nil || active {
+		t.Errorf("session active = %v, %v after reuse; want revoked", active, err)
+	}
+	if _, err := store.Consume(ctx, second); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("rotated token after reuse: err = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := store.Consume(ctx, "unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("unknown token: err = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestHashTokenHidesSecret(t *testing.T) {
+	if HashToken("secret") != HashToken("secret") {
+		t.Error("HashToken is not deterministic")
+	}
+	if HashToken("secret") == HashToken("secreT") || HashToken("secret") == "secret" {
+		t.Error("HashToken does not hide the secret")
+	}
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	defaultIssuer   = "django-unchained"
+	defaultAudience = "django-unchained-api"
+	defaultTokenTTL = 15 * time.Minute
+
+	// MFA challenge tokens prove the password step of a two-step login.
+	// Their own audience keeps them from being used as access tokens.
+	mfaChallengeSuffix = ":mfa-challenge"
+	mfaChallengeTTL    = 5 * time.Minute
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+
+	// Tokens is the process-wide token service, set up by SetupTokenService.
+	Tokens *TokenService
+)
+
+// TokenService issues and validates the API's access tokens. It is the only
+// place that knows the signing key, algorithm, issuer and audience.
+type TokenService struct {
+	secret   []byte
+	issuer   string
+	audience string
+	ttl      time.Duration
+}
+
+func NewTokenService(secret []byte, issuer, audience string, ttl time.Duration) *TokenService {
+	return &TokenService{secret: secret, issuer: issuer, audience: audience, ttl: ttl}
+}
+
+// SetupTokenService builds Tokens from the environment. It must run after
+// the .env file is loaded.
+func SetupTokenService() {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		log.Fatal("JWT_SECRET must be set")
+	}
+
+	ttl := defaultTokenTTL
+	if raw := os.Getenv("JWTEXPINSEC"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds <= 0 {
+			log.Println("Error parsing JWT expiration time:", raw)
+		} else {
+			ttl = time.Duration(seconds) * time.Second
+		}
+	}
+
+	Tokens = NewTokenService([]byte(secret), envOr("JWT_ISSUER", defaultIssuer), envOr("JWT_AUDIENCE", defaultAudience), ttl)
+}
+
+// Issue signs an access token for the given principal.
+func (s *TokenService) Issue(p Principal) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(s.ttl)
+	claims := &models.Claims{
+		Username:   p.Username,
+		Role:       p.Role,
+		Enterprise: p.Enterprise,
+		SessionID:  p.SessionID,
+		MFA:        p.MFA,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   p.UserID,
+			Issuer:    s.issuer,
+			Audience:  jwt.ClaimStrings{s.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// IssueMFAChallenge signs a short-lived token for a user who passed the
+// password check but still owes a second factor.
+func (s *TokenService) IssueMFAChallenge(userID string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(mfaChallengeTTL)
+	claims := &jwt.RegisteredClaims{
+		Subject:   userID,
+		Issuer:    s.issuer,
+		Audience:  jwt.ClaimStrings{s.audience + mfaChallengeSuffix},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// ValidateMFAChallenge returns the user ID of a valid MFA challenge token.
+func (s *TokenService) ValidateMFAChallenge(tokenString string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	token, err := parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	})
+	if err != nil || !token.Valid {
+		return "", ErrInvalidToken
+	}
+	if !claims.VerifyExpiresAt(time.Now(), true) ||
+		!claims.VerifyIssuer(s.issuer, true) ||
+		!claims.VerifyAudience(s.audience+mfaChallengeSuffix, true) ||
+		claims.Subject == "" {
+		return "", ErrInvalidToken
+	}
+	return claims.Subject, nil
+}
+
+// Validate parses a token and enforces HS256, expiry, issuer and audience.
+func (s *TokenService) Validate(tokenString string) (*models.Claims, error) {
+	claims := &models.Claims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	token, err := parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	if !claims.VerifyExpiresAt(now, true) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if !claims.VerifyIssuer(s.issuer, true) {
+		return nil, fmt.Errorf("%w: issuer", ErrInvalidToken)
+	}
+	if !claims.VerifyAudience(s.audience, true) {
+		return nil, fmt.Errorf("%w: audience", ErrInvalidToken)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: subject", ErrInvalidToken)
+	}
+	if claims.SessionID == "" {
+		return nil, fmt.Errorf("%w: session", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
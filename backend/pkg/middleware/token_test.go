@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func testTokens() *TokenService {
+	return NewTokenService([]byte("test-secret"), "issuer", "audience", time.Minute)
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	tokens := testTokens()
+	token, expiresAt, err := tokens.Issue(Principal{UserID: "u1", Username: "ann", Role: "admin", Enterprise: "acme", SessionID: "s1", MFA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(expiresAt); until <= 0 || until > time.Minute {
+		t.Errorf("token expires in %v, want within the minute TTL", until)
+	}
+
+	claims, err := tokens.Validate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "u1" || claims.Username != "ann" || claims.Enterprise != "acme" || claims.SessionID != "s1" || !claims.MFA {
+		t.Errorf("claims = %+v", claims)
+	}
+}
+
+func TestTokenValidateRejects(t *testing.T) {
+	tokens := testTokens()
+	principal := Principal{UserID: "u1", Username: "ann", SessionID: "s1"}
+	issued := func(s *TokenService, p Principal) string {
+		token, _, err := s.Issue(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	signed := func(method jwt.SigningMethod, key interface{}) string {
+		now := time.Now()
+		token, err := jwt.NewWithClaims(method, &models.Claims{
+			SessionID: "s1",
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject: "u1", Issuer: "issuer", Audience: jwt.ClaimStrings{"audience"},
+				IssuedAt: jwt.NewNumericDate(now), ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			},
+		}).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	challenge, _, err := tokens.IssueMFAChallenge("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"other secret":   issued(NewTokenService([]byte("other"), "issuer", "audience", time.Minute), principal),
+		"other issuer":   issued(NewTokenService([]byte("test-secret"), "other", "audience", time.Minute), principal),
+		"other audience": issued(NewTokenService([]byte("test-secret"), "issuer", "other", time.Minute), principal),
+		"expired":        issued(NewTokenService([]byte("test-secret"), "issuer", "audience", -time.Minute), principal),
+		"no subject":     issued(tokens, Principal{SessionID: "s1"}),
+		"no session":     issued(tokens, Principal{UserID: "u1"}),
+		"HS512":          signed(jwt.SigningMethodHS512, []byte("test-secret")),
+		"alg none":       signed(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType),
+		"mfa challenge":  challenge,
+		"garbage":        "not.a.token",
+	}
+	for name, token := range cases {
+		if _, err := tokens.Validate(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
+
+func TestMFAChallengeIsNotAnAccessToken(t *testing.T) {
+	tokens := testTokens()
+	challenge, _, err := tokens.IssueMFAChallenge("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, err := tokens.ValidateMFAChallenge(challenge); err != nil || user != "u1" {
+		t.Errorf("challenge = %q, %v; want u1", user, err)
+	}
+
+	access, _, err := tokens.Issue(Principal{UserID: "u1", SessionID: "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.ValidateMFAChallenge(access); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("access token accepted as an MFA challenge: err = %v", err)
+	}
+}
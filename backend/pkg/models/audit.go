@@ -0,0 +1,75 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditEvent records one state-changing action. Events are only ever
+// inserted; nothing in the API updates or deletes them.
+type AuditEvent struct {
+	ID         primitive.ObjectID     `json:"id" bson:"_id,omitempty"`
+	Timestamp  time.Time              `json:"timestamp" bson:"timestamp"`
+	ActorID    string                 `json:"actor_id" bson:"actor_id"`
+	ActorName  string                 `json:"actor_name" bson:"actor_name"`
+	ActorKind  string                 `json:"actor_kind" bson:"actor_kind"`
+	Action     string                 `json:"action" bson:"action"`
+	TargetType string                 `json:"target_type" bson:"target_type"`
+	TargetID   string                 `json:"target_id" bson:"target_id"`
+	Before     bson.M                 `json:"before,omitempty" bson:"before,omitempty"`
+	After      bson.M                 `json:"after,omitempty" bson:"after,omitempty"`
+	Diff       map[string]AuditChange `json:"diff,omitempty" bson:"diff,omitempty"`
+	RequestID  string                 `json:"request_id" bson:"request_id"`
+	SourceIP   string                 `json:"source_ip" bson:"source_ip"`
+}
+
+type AuditChange struct {
+	Before interface{} `json:"before" bson:"before"`
+	After  interface{} `json:"after" bson:"after"`
+}
+
+const (
+	AuditUserOIDCProvision    = "user.oidc.provision"
+	AuditUserOIDCSync         = "user.oidc.sync"
+	AuditUserRegister         = "user.register"
+	AuditUserRoleUpdate       = "user.role.update"
+	AuditUserDisable          = "user.disabled.update"
+	AuditPasswordChange       = "user.password.change"
+	AuditPasswordResetIssue   = "user.password.reset_issue"
+	AuditPasswordReset        = "user.password.reset"
+	AuditMFAEnable            = "user.mfa.enable"
+	AuditMFADisable           = "user.mfa.disable"
+	AuditMFARecoveryCodes     = "user.mfa.recovery_codes"
+	AuditPermissionMFA        = "permission.require_mfa"
+	AuditLogin                = "session.login"
+	AuditRefresh              = "session.refresh"
+	AuditLogout               = "session.logout"
+	AuditLogoutAll            = "session.logout_all"
+	AuditRoleSave             = "role.save"
+	AuditServiceAccountCreate = "service_account.create"
+	AuditAPIKeyCreate         = "api_key.create"
+	AuditAPIKeyRotate         = "api_key.rotate"
+	AuditAPIKeyRevoke         = "api_key.revoke"
+	AuditMeterIngest          = "meter.ingest"
+	AuditForecastIngest       = "forecast.ingest"
+	AuditGridUpdate           = "grid.update"
+	AuditGridBalance          = "grid_network.balance"
+	AuditGridNetworkCreate    = "grid_network.create"
+	AuditGridNetworkUpdate    = "grid_network.update"
+	AuditGridNetworkDelete    = "grid_network.delete"
+	AuditGridLineCreate       = "grid_network.line.create"
+	AuditGridLineUpdate       = "grid_network.line.update"
+	AuditGridLineDelete       = "grid_network.line.delete"
+	AuditStorageCreate        = "grid_network.storage.create"
+	AuditStorageUpdate        = "grid_network.storage.update"
+	AuditStorageDelete        = "grid_network.storage.delete"
+	AuditNodeCreate           = "grid_network.node.create"
+	AuditNodeUpdate           = "grid_network.node.update"
+	AuditNodeDelete           = "grid_network.node.delete"
+	AuditNodePriority         = "grid_network.node.priority"
+	AuditScheduleUpdate       = "grid_network.schedule.update"
+	AuditSchedulePause        = "grid_network.schedule.pause"
+	AuditScheduleResume       = "grid_network.schedule.resume"
+)
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BalancePlan is the outcome of a balancing run that has not been applied
+// yet. It can be committed until it expires, provided the grid has not
+// changed since the plan was computed.
+type BalancePlan struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	GridID      primitive.ObjectID `json:"grid_id" bson:"grid_id"`
+	Enterprise  string             `json:"enterprise,omitempty" bson:"enterprise,omitempty"`
+	Strategy    string             `json:"strategy" bson:"strategy"`
+	GridVersion int64              `json:"grid_version" bson:"grid_version"` // grid version the plan was computed from
+	Allocations []NodeAllocation   `json:"allocations" bson:"allocations"`
+	Transfers   []EnergyTransfer   `json:"transfers" bson:"transfers"`
+	TotalLoss   float64            `json:"total_loss" bson:"total_loss"` // in MW
+	Violations  []PlanViolation    `json:"violations" bson:"violations"`
+	Shedding    *SheddingSchedule  `json:"shedding,omitempty" bson:"shedding,omitempty"`
+	Storage     []StorageDispatch  `json:"storage,omitempty" bson:"storage,omitempty"`
+	Curtailment []Curtailment      `json:"curtailment,omitempty" bson:"curtailment,omitempty"`
+
+	// Escalation to sibling grids. Earlier imports into this grid are
+	// released and replaced by Interchanges; InterchangeDelta is the change
+	// to this grid's Interchange and Siblings the change to each sibling's.
+	Interchanges         []GridInterchange    `json:"interchanges,omitempty" bson:"interchanges,omitempty"`
+	ReleasedInterchanges []primitive.ObjectID `json:"released_interchanges,omitempty" bson:"released_interchanges,omitempty"`
+	InterchangeDelta     float64              `json:"interchange_delta" bson:"interchange_delta"`
+	Siblings             []SiblingUpdate      `json:"siblings,omitempty" bson:"siblings,omitempty"`
+
+	CreatedBy   string     `json:"created_by" bson:"created_by"`
+	CreatedAt   time.Time  `json:"created_at" bson:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at" bson:"expires_at"`
+	CommittedAt *time.Time `json:"committed_at,omitempty" bson:"committed_at,omitempty"`
+}
+
+// SiblingUpdate is a change a plan makes to a sibling grid. It only applies
+// while the sibling is still at Version.
+type SiblingUpdate struct {
+	GridID           primitive.ObjectID `json:"grid_id" bson:"grid_id"`
+	Version          int64              `json:"version" bson:"version"`
+	InterchangeDelta float64            `json:"interchange_delta" bson:"interchange_delta"`
+}
+
+// NodeAllocation is one node's allocation before and after a plan.
+type NodeAllocation struct {
+	NodeID        primitive.ObjectID `json:"node_id" bson:"node_id"`
+	Name          string             `json:"name" bson:"name"`
+	CurrentDemand float64            `json:"current_demand" bson:"current_demand"`
+	Before        float64            `json:"before" bson:"before"`                             // in MW
+	Base          float64            `json:"base" bson:"base"`                                 // in MW, from supply alone, without transfers
+	After         float64            `json:"after" bson:"after"`                               // in MW
+	Generation    float64            `json:"generation,omitempty" bson:"generation,omitempty"` // in MW, the node's own output
+}
+
+// PlanViolation is a constraint a plan leaves unmet.
+type PlanViolation struct {
+	Kind      string             `json:"kind" bson:"kind"`
+	ElementID primitive.ObjectID `json:"element_id,omitempty" bson:"element_id,omitempty"`
+	Amount    float64            `json:"amount" bson:"amount"` // MW over the limit or short of demand
+	Detail    string             `json:"detail" bson:"detail"`
+}
+
+const (
+	ViolationUnservedDemand   = "unserved_demand"
+	ViolationNodeOverCapacity = "node_over_capacity"
+	ViolationGridOverCapacity = "grid_over_capacity"
+	ViolationLineOverload     = "line_overload"
+)
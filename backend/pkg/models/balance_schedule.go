@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BalanceSchedule is the scheduler's per-grid settings and state. Zero
+// interval or threshold fall back to the process-wide defaults.
+type BalanceSchedule struct {
+	GridID             primitive.ObjectID `json:"grid_id" bson:"_id"`
+	Paused             bool               `json:"paused" bson:"paused"`
+	PausedBy           string             `json:"paused_by,omitempty" bson:"paused_by,omitempty"`
+	PausedAt           *time.Time         `json:"paused_at,omitempty" bson:"paused_at,omitempty"`
+	IntervalSeconds    int                `json:"interval_seconds" bson:"interval_seconds"`
+	ImbalanceThreshold float64            `json:"imbalance_threshold" bson:"imbalance_threshold"` // in MW
+	ResidualImbalance  float64            `json:"residual_imbalance" bson:"residual_imbalance"`   // MW left after the last run
+	LastRunAt          *time.Time         `json:"last_run_at,omitempty" bson:"last_run_at,omitempty"`
+	NextRunAt          *time.Time         `json:"next_run_at,omitempty" bson:"next_run_at,omitempty"`
+}
+
+type BalanceScheduleRequest struct {
+	IntervalSeconds    int     `json:"interval_seconds"`
+	ImbalanceThreshold float64 `json:"imbalance_threshold"`
+}
+
+const (
+	TriggerInterval  = "interval"
+	TriggerImbalance = "imbalance"
+
+	RunSucceeded = "succeeded"
+	RunConflict  = "conflict"
+	RunFailed    = "failed"
+)
+
+// BalanceRun is one scheduled balancing attempt.
+type BalanceRun struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	GridID     primitive.ObjectID `json:"grid_id" bson:"grid_id"`
+	Trigger    string             `json:"trigger" bson:"trigger"`
+	Instance   string             `json:"instance" bson:"instance"`
+	Imbalance  float64            `json:"imbalance" bson:"imbalance"` // MW at the time of the run
+	StartedAt  time.Time          `json:"started_at" bson:"started_at"`
+	DurationMS int64              `json:"duration_ms" bson:"duration_ms"`
+	Outcome    string             `json:"outcome" bson:"outcome"`
+	Error      string             `json:"error,omitempty" bson:"error,omitempty"`
+	Transfers  int                `json:"transfers" bson:"transfers"`
+	TotalLoss  float64            `json:"total_loss" bson:"total_loss"` // in MW
+	TotalShed  float64            `json:"total_shed" bson:"total_shed"` // in MW
+}
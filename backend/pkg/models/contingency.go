@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ContingencyResult is how a grid fares with one element tripped. Severity
+// is the MW of demand the remaining nodes go without plus the MW by which
+// nodes and lines are overloaded; results are ranked by it. The tripped
+// node's own demand is reported apart as LostLoad. Nodes the trip cuts off
+// from the grid's supply node are listed in CutOffNodes and their demand
+// counts as unserved.
+type ContingencyResult struct {
+	ElementType     string               `json:"element_type" bson:"element_type"` // one of the Element* constants
+	ElementID       primitive.ObjectID   `json:"element_id,omitempty" bson:"element_id,omitempty"`
+	Name            string               `json:"name,omitempty" bson:"name,omitempty"`
+	LostLoad        float64              `json:"lost_load" bson:"lost_load"` // in MW, demand of a tripped node
+	Unserved        float64              `json:"unserved" bson:"unserved"`   // in MW, shortfall at the remaining nodes
+	NodeOverload    float64              `json:"node_overload" bson:"node_overload"`
+	LineOverload    float64              `json:"line_overload" bson:"line_overload"`
+	OverloadedNodes []primitive.ObjectID `json:"overloaded_nodes,omitempty" bson:"overloaded_nodes,omitempty"`
+	CutOffNodes     []primitive.ObjectID `json:"cut_off_nodes,omitempty" bson:"cut_off_nodes,omitempty"`
+	TotalLoss       float64              `json:"total_loss" bson:"total_loss"`           // in MW
+	WorstLossRate   float64              `json:"worst_loss_rate" bson:"worst_loss_rate"` // highest LossEstimate of any transfer, in %
+	Severity        float64              `json:"severity" bson:"severity"`
+	Violations      []PlanViolation      `json:"violations" bson:"violations"`
+}
+
+const (
+	ElementNone = "none" // the intact grid
+	ElementNode = "node"
+	ElementLine = "line"
+)
+
+// ContingencyReport is an N-1 analysis of a grid at one version. Secure is
+// set when no single trip leaves demand unserved or anything overloaded
+// beyond what the intact grid already does.
+type ContingencyReport struct {
+	GridID      primitive.ObjectID  `json:"grid_id" bson:"grid_id"`
+	GridVersion int64               `json:"grid_version" bson:"grid_version"`
+	Strategy    string              `json:"strategy" bson:"strategy"`
+	Shedding    string              `json:"shedding" bson:"shedding"`
+	Base        ContingencyResult   `json:"base" bson:"base"`
+	Results     []ContingencyResult `json:"results" bson:"results"`
+	WorstLoss   float64             `json:"worst_loss" bson:"worst_loss"` // highest TotalLoss of any contingency, in MW
+	Secure      bool                `json:"secure" bson:"secure"`
+}
+
+// ContingencyJob runs an analysis too large to answer inline.
+type ContingencyJob struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	GridID      primitive.ObjectID `json:"grid_id" bson:"grid_id"`
+	Enterprise  string             `json:"enterprise,omitempty" bson:"enterprise,omitempty"`
+	Status      string             `json:"status" bson:"status"` // one of the Job* constants
+	Active      bool               `json:"-" bson:"active"`      // set while queued or running; one per grid
+	Elements    int                `json:"elements" bson:"elements"`
+	Completed   int                `json:"completed" bson:"completed"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	Report      *ContingencyReport `json:"report,omitempty" bson:"report,omitempty"`
+	CreatedBy   string             `json:"created_by" bson:"created_by"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+	HeartbeatAt time.Time          `json:"heartbeat_at" bson:"heartbeat_at"` // refreshed while the owning process is alive
+	FinishedAt  *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
+
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AllocationSnapshot records the node allocations an applied balance left
+// behind. The ledger is reconciled against it: each node's After minus Base
+// must equal what the plan's transfers delivered to it minus what they took.
+type AllocationSnapshot struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	GridID      primitive.ObjectID `json:"grid_id" bson:"grid_id"`
+	PlanID      primitive.ObjectID `json:"plan_id" bson:"plan_id"`
+	GridVersion int64              `json:"grid_version" bson:"grid_version"` // version the balance produced
+	TakenAt     time.Time          `json:"taken_at" bson:"taken_at"`
+	Allocations []NodeAllocation   `json:"allocations" bson:"allocations"`
+}
+
+// LedgerSummary aggregates transfers by node, by day or both. Losses are
+// charged to the sending node.
+type LedgerSummary struct {
+	NodeID    primitive.ObjectID `json:"node_id,omitempty" bson:"node_id,omitempty"`
+	Day       string             `json:"day,omitempty" bson:"day,omitempty"` // YYYY-MM-DD
+	Sent      float64            `json:"sent" bson:"sent"`                   // in MW
+	Received  float64            `json:"received" bson:"received"`           // in MW, after losses
+	Loss      float64            `json:"loss" bson:"loss"`                   // in MW
+	Transfers int                `json:"transfers" bson:"transfers"`
+}
+
+// LedgerReconciliation is the outcome of checking the ledger against the
+// allocation snapshots taken in a time range.
+type LedgerReconciliation struct {
+	GridID        primitive.ObjectID  `json:"grid_id"`
+	From          *time.Time          `json:"from,omitempty"`
+	To            *time.Time          `json:"to,omitempty"`
+	Snapshots     int                 `json:"snapshots"`
+	Transfers     int                 `json:"transfers"`
+	Discrepancies []LedgerDiscrepancy `json:"discrepancies"`
+}
+
+// LedgerDiscrepancy is one place where the ledger and the snapshots
+// disagree. Expected comes from the snapshot, Recorded from the ledger.
+type LedgerDiscrepancy struct {
+	Kind       string             `json:"kind"`
+	PlanID     primitive.ObjectID `json:"plan_id,omitempty"`
+	NodeID     primitive.ObjectID `json:"node_id,omitempty"`
+	TransferID primitive.ObjectID `json:"transfer_id,omitempty"`
+	Expected   float64            `json:"expected"` // in MW
+	Recorded   float64            `json:"recorded"` // in MW
+	Detail     string             `json:"detail"`
+}
+
+const (
+	DiscrepancyAllocationMismatch = "allocation_mismatch" // net transfers differ from the snapshot's change
+	DiscrepancyMissingSnapshot    = "missing_snapshot"    // transfers of a balance with no snapshot
+	DiscrepancyUnknownNode        = "unknown_node"        // transfer endpoint missing from the snapshot
+	DiscrepancyOutOfBand          = "out_of_band_change"  // allocation changed between two balances
+)
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NodeGeneration is generation behind a child node, such as rooftop solar
+// or a wind turbine. It first covers the node's own demand; the rest is
+// exported to the grid. OutputMW is refreshed from Source on every balance.
+type NodeGeneration struct {
+	Kind       string    `json:"kind" bson:"kind"`                           // one of the Generation* kinds
+	CapacityMW float64   `json:"capacity_mw" bson:"capacity_mw"`             // nameplate
+	Source     string    `json:"source" bson:"source"`                       // one of the GenerationSource* constants
+	ZoneID     string    `json:"zone_id,omitempty" bson:"zone_id,omitempty"` // forecast zone, for GenerationSourceForecast
+	OutputMW   float64   `json:"output_mw" bson:"output_mw"`
+	UpdatedAt  time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+const (
+	GenerationSolar = "solar"
+	GenerationWind  = "wind"
+)
+
+// Where a node's output comes from. Measured output scales the latest
+// system-wide solar or wind figure in power_generation; forecast output
+// scales the renewable share of the zone's supply forecast. Both are taken
+// relative to their recent peak. Manual output is whatever was last set on
+// the node.
+const (
+	GenerationSourceMeasured = "power_generation"
+	GenerationSourceForecast = "forecast"
+	GenerationSourceManual   = "manual"
+)
+
+// Curtailment is export from a node's generation that a balance could not
+// use. Stored is the part that charged storage instead.
+type Curtailment struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	GridID     primitive.ObjectID `json:"grid_id" bson:"grid_id"`
+	PlanID     primitive.ObjectID `json:"plan_id" bson:"plan_id"`
+	NodeID     primitive.ObjectID `json:"node_id" bson:"node_id"`
+	Name       string             `json:"name" bson:"name"`
+	Kind       string             `json:"kind" bson:"kind"`
+	Output     float64            `json:"output" bson:"output"`       // in MW
+	Export     float64            `json:"export" bson:"export"`       // in MW, output above the node's own demand
+	Stored     float64            `json:"stored" bson:"stored"`       // in MW
+	Curtailed  float64            `json:"curtailed" bson:"curtailed"` // in MW
+	Reason     string             `json:"reason" bson:"reason"`       // one of the Curtail* constants
+	RecordedAt time.Time          `json:"recorded_at" bson:"recorded_at"`
+}
+
+const (
+	// CurtailConnectionLimit is export above the node's own capacity.
+	CurtailConnectionLimit = "connection_limit"
+	// CurtailNoAbsorption is export no node or storage could take.
+	CurtailNoAbsorption = "no_absorption"
+)
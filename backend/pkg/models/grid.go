@@ -10,4 +10,5 @@ type Grid struct {
 	Consumption float64            `json:"consumption"`
 	Predicted   float64            `json:"predicted"`
 	UpdatedAt   int64              `json:"updated_at"`
+	Enterprise  string             `json:"enterprise,omitempty" bson:"enterprise,omitempty"`
 }
@@ -7,14 +7,50 @@ import (
 )
 
 type GridNetwork struct {
-	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ID   primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name string             `json:"name" bson:"name"`
+	// ParentNodeID is the node of the next grid up the hierarchy that this
+	// grid sits behind, e.g. the substation node feeding a feeder grid.
 	ParentNodeID  primitive.ObjectID `json:"parent_node_id" bson:"parent_node_id"`
+	Tier          string             `json:"tier,omitempty" bson:"tier,omitempty"` // one of the Tier* constants
 	ChildNodes    []ChildNode        `json:"child_nodes" bson:"child_nodes"`
 	TotalCapacity float64            `json:"total_capacity" bson:"total_capacity"` // in MW
 	CurrentLoad   float64            `json:"current_load" bson:"current_load"`     // in MW
 	LastBalanced  time.Time          `json:"last_balanced" bson:"last_balanced"`
+	Enterprise    string             `json:"enterprise,omitempty" bson:"enterprise,omitempty"`
+	Lines         []GridLine         `json:"lines,omitempty" bson:"lines,omitempty"`
+	Storage       []StorageAsset     `json:"storage,omitempty" bson:"storage,omitempty"`
+	// Interchange is the net MW this grid currently imports from (positive)
+	// or exports to (negative) sibling grids through its parent. It adds to
+	// TotalCapacity when balancing.
+	Interchange float64 `json:"interchange" bson:"interchange"`
+	// LossModel names the model transfers are priced with: linear,
+	// resistive or a configured table:<name>. Empty means resistive for
+	// grids with lines and linear otherwise.
+	LossModel string `json:"loss_model,omitempty" bson:"loss_model,omitempty"`
+	// SheddingPolicy applies when demand exceeds TotalCapacity. Empty means
+	// proportional.
+	SheddingPolicy string `json:"shedding_policy,omitempty" bson:"shedding_policy,omitempty"`
+	// Version is bumped on every write. Updates match on it so concurrent
+	// writers cannot overwrite each other.
+	Version int64 `json:"version" bson:"version"`
 }
 
+const (
+	LossModelLinear      = "linear"
+	LossModelResistive   = "resistive"
+	LossModelTablePrefix = "table:"
+)
+
+const (
+	TierRegional   = "regional"
+	TierSubstation = "substation"
+	TierFeeder     = "feeder"
+)
+
+// ChildNode is a point of supply in a grid. AllocatedPower is what the grid
+// delivers to it; a node whose own generation exceeds its demand sends power
+// out instead, and AllocatedPower is negative.
 type ChildNode struct {
 	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	Name           string             `json:"name" bson:"name"`
@@ -23,13 +59,116 @@ type ChildNode struct {
 	CurrentDemand  float64            `json:"current_demand" bson:"current_demand"`   // in MW
 	AllocatedPower float64            `json:"allocated_power" bson:"allocated_power"` // in MW
 	Distance       float64            `json:"distance" bson:"distance"`               // distance from parent in km
+	Generation     *NodeGeneration    `json:"generation,omitempty" bson:"generation,omitempty"`
+
+	// PriorityClass is one of the Priority* constants; empty means normal.
+	// MinGuaranteed is served before any policy-driven shedding, and
+	// interruptible loads are shed before firm ones.
+	PriorityClass string  `json:"priority_class,omitempty" bson:"priority_class,omitempty"`
+	MinGuaranteed float64 `json:"min_guaranteed" bson:"min_guaranteed"` // in MW
+	Interruptible bool    `json:"interruptible" bson:"interruptible"`
+}
+
+const (
+	PriorityCritical = "critical" // hospitals, water, emergency services
+	PriorityHigh     = "high"
+	PriorityNormal   = "normal"
+	PriorityLow      = "low"
+)
+
+// PriorityRanks orders the priority classes; lower ranks are served first.
+var PriorityRanks = map[string]int{
+	PriorityCritical: 1,
+	PriorityHigh:     2,
+	PriorityNormal:   3,
+	PriorityLow:      4,
+}
+
+// NodePriority is the body for changing a node's priority settings.
+type NodePriority struct {
+	PriorityClass string  `json:"priority_class"`
+	MinGuaranteed float64 `json:"min_guaranteed"`
+	Interruptible bool    `json:"interruptible"`
+}
+
+// Rules that can decide a node's allocation or a transfer's recipient.
+const (
+	RuleMinGuarantee       = "min_guarantee"
+	RuleCriticalProtected  = "critical_protected"
+	RulePriorityClass      = "priority_class"
+	RuleInterruptibleFirst = "interruptible_shed_first"
+	RuleSheddingPolicy     = "shedding_policy"
+)
+
+// GridLine is a feeder or transformer segment between two child nodes. When a
+// grid has lines, transfers are routed over them instead of straight lines.
+type GridLine struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	FromNodeID    primitive.ObjectID `json:"from_node_id" bson:"from_node_id"`
+	ToNodeID      primitive.ObjectID `json:"to_node_id" bson:"to_node_id"`
+	Length        float64            `json:"length" bson:"length"`                 // in km
+	Resistance    float64            `json:"resistance" bson:"resistance"`         // in ohms, whole segment
+	VoltageKV     float64            `json:"voltage_kv" bson:"voltage_kv"`         // nominal line-to-line voltage
+	ThermalRating float64            `json:"thermal_rating" bson:"thermal_rating"` // in MW
+	InService     bool               `json:"in_service" bson:"in_service"`
+}
+
+// GridLineRequest is the body for creating or replacing a line. InService
+// defaults to true when omitted.
+type GridLineRequest struct {
+	FromNodeID    primitive.ObjectID `json:"from_node_id"`
+	ToNodeID      primitive.ObjectID `json:"to_node_id"`
+	Length        float64            `json:"length"`
+	Resistance    float64            `json:"resistance"`
+	VoltageKV     float64            `json:"voltage_kv"`
+	ThermalRating float64            `json:"thermal_rating"`
+	InService     *bool              `json:"in_service"`
+}
+
+// GridNetworkRequest is the body for creating or editing a grid network.
+// ChildNodes is only read on create; nodes are managed through their own
+// endpoints afterwards. Enterprise is only honoured for cross-tenant callers.
+type GridNetworkRequest struct {
+	Name           string             `json:"name"`
+	ParentNodeID   primitive.ObjectID `json:"parent_node_id"`
+	Tier           string             `json:"tier"`
+	TotalCapacity  float64            `json:"total_capacity"`
+	SheddingPolicy string             `json:"shedding_policy"`
+	LossModel      string             `json:"loss_model"`
+	Enterprise     string             `json:"enterprise"`
+	ChildNodes     []ChildNodeRequest `json:"child_nodes"`
+}
+
+// ChildNodeRequest is the body for creating or replacing a child node.
+type ChildNodeRequest struct {
+	Name           string          `json:"name"`
+	Location       Location        `json:"location"`
+	Capacity       float64         `json:"capacity"`
+	CurrentDemand  float64         `json:"current_demand"`
+	AllocatedPower float64         `json:"allocated_power"`
+	Distance       float64         `json:"distance"`
+	PriorityClass  string          `json:"priority_class"`
+	MinGuaranteed  float64         `json:"min_guaranteed"`
+	Interruptible  bool            `json:"interruptible"`
+	Generation     *NodeGeneration `json:"generation"`
 }
 
+// EnergyTransfer is one entry of the energy_transfers ledger. PlanID groups
+// the transfers of one applied balance with its allocation snapshot.
 type EnergyTransfer struct {
-	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	FromNodeID   primitive.ObjectID `json:"from_node_id" bson:"from_node_id"`
-	ToNodeID     primitive.ObjectID `json:"to_node_id" bson:"to_node_id"`
-	Amount       float64            `json:"amount" bson:"amount"` // in MW
-	TransferTime time.Time          `json:"transfer_time" bson:"transfer_time"`
-	LossEstimate float64            `json:"loss_estimate" bson:"loss_estimate"` // in %
+	ID           primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	GridID       primitive.ObjectID   `json:"grid_id" bson:"grid_id"`
+	PlanID       primitive.ObjectID   `json:"plan_id" bson:"plan_id"`
+	FromNodeID   primitive.ObjectID   `json:"from_node_id" bson:"from_node_id"`
+	ToNodeID     primitive.ObjectID   `json:"to_node_id" bson:"to_node_id"`
+	Amount       float64              `json:"amount" bson:"amount"` // in MW
+	TransferTime time.Time            `json:"transfer_time" bson:"transfer_time"`
+	LossEstimate float64              `json:"loss_estimate" bson:"loss_estimate"`     // in %
+	Strategy     string               `json:"strategy" bson:"strategy"`               // balancing strategy that planned it
+	Lines        []primitive.ObjectID `json:"lines,omitempty" bson:"lines,omitempty"` // lines the transfer was routed over
+	PriorityRule string               `json:"priority_rule" bson:"priority_rule"`     // rule that ranked the recipient
+	// LossModel and LossParams are the model and settings that produced
+	// LossEstimate.
+	LossModel  string                 `json:"loss_model" bson:"loss_model"`
+	LossParams map[string]interface{} `json:"loss_params,omitempty" bson:"loss_params,omitempty"`
 }
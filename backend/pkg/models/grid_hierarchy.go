@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GridInterchange is power a grid sends a sibling through their common
+// parent when the sibling cannot cover its own demand. It stays active until
+// the receiving grid is balanced again.
+type GridInterchange struct {
+	ID           primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	ParentGridID primitive.ObjectID   `json:"parent_grid_id" bson:"parent_grid_id"`
+	FromGridID   primitive.ObjectID   `json:"from_grid_id" bson:"from_grid_id"`
+	ToGridID     primitive.ObjectID   `json:"to_grid_id" bson:"to_grid_id"`
+	Sent         float64              `json:"sent" bson:"sent"`                   // in MW
+	Delivered    float64              `json:"delivered" bson:"delivered"`         // in MW
+	LossEstimate float64              `json:"loss_estimate" bson:"loss_estimate"` // in %
+	Lines        []primitive.ObjectID `json:"lines,omitempty" bson:"lines,omitempty"`
+	Active       bool                 `json:"active" bson:"active"`
+	CreatedAt    time.Time            `json:"created_at" bson:"created_at"`
+	ReleasedAt   *time.Time           `json:"released_at,omitempty" bson:"released_at,omitempty"`
+}
+
+// GridMetrics aggregates a grid's nodes. Supply is what enters the grid
+// from above (TotalCapacity plus Interchange); NodeCapacity sums the nodes'
+// own limits.
+type GridMetrics struct {
+	Nodes        int     `json:"nodes"`
+	Supply       float64 `json:"supply"`        // in MW
+	NodeCapacity float64 `json:"node_capacity"` // in MW
+	Demand       float64 `json:"demand"`        // in MW
+	Generation   float64 `json:"generation"`    // in MW, from the nodes themselves
+	Allocated    float64 `json:"allocated"`     // in MW
+	Unserved     float64 `json:"unserved"`      // in MW
+}
+
+// GridTreeNode is one grid in the hierarchy. Metrics covers the grid as
+// stored; Rollup replaces every node that has a grid behind it with that
+// grid's own rollup.
+type GridTreeNode struct {
+	GridID       primitive.ObjectID `json:"grid_id"`
+	ParentNodeID primitive.ObjectID `json:"parent_node_id"`
+	Tier         string             `json:"tier,omitempty"`
+	Metrics      GridMetrics        `json:"metrics"`
+	Rollup       GridMetrics        `json:"rollup"`
+	Children     []GridTreeNode     `json:"children,omitempty"`
+}
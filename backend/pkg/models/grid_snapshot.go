@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GridSnapshot is a grid network as it was right after one change. There is
+// one per grid version; a deleted grid gets a final snapshot of its last
+// state with Deleted set.
+type GridSnapshot struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	GridID     primitive.ObjectID `json:"grid_id" bson:"grid_id"`
+	Enterprise string             `json:"enterprise,omitempty" bson:"enterprise,omitempty"`
+	Version    int64              `json:"version" bson:"version"`
+	Cause      string             `json:"cause" bson:"cause"`   // one of the SnapshotCause* constants
+	Action     string             `json:"action" bson:"action"` // audit action or SnapshotActionInterchange
+	Actor      string             `json:"actor,omitempty" bson:"actor,omitempty"`
+	PlanID     primitive.ObjectID `json:"plan_id,omitempty" bson:"plan_id,omitempty"`
+	Deleted    bool               `json:"deleted,omitempty" bson:"deleted,omitempty"`
+	TakenAt    time.Time          `json:"taken_at" bson:"taken_at"`
+	State      *GridNetwork       `json:"state,omitempty" bson:"state,omitempty"`
+}
+
+const (
+	SnapshotCauseBalance   = "balance"   // balance requested through the API
+	SnapshotCauseScheduler = "scheduler" // balance run by the background scheduler
+	SnapshotCauseManual    = "manual"    // grid, node or line edited through the API
+	SnapshotCauseBaseline  = "baseline"  // first snapshot of a grid that predates snapshots
+)
+
+// SnapshotActionInterchange marks a sibling grid changed by another grid's
+// balance escalating to it.
+const SnapshotActionInterchange = "grid_network.interchange"
+
+// GridDiff is what changed between two snapshots of a grid. Fields covers
+// the grid's own fields; nodes and lines are compared by ID.
+type GridDiff struct {
+	GridID      primitive.ObjectID     `json:"grid_id"`
+	FromVersion int64                  `json:"from_version"`
+	ToVersion   int64                  `json:"to_version"`
+	Fields      map[string]AuditChange `json:"fields,omitempty"`
+	Nodes       []ElementDiff          `json:"nodes"`
+	Lines       []ElementDiff          `json:"lines"`
+}
+
+// ElementDiff is one node or line added, removed or changed between two
+// snapshots.
+type ElementDiff struct {
+	ID     primitive.ObjectID     `json:"id"`
+	Name   string                 `json:"name,omitempty"`
+	Change string                 `json:"change"` // added, removed or changed
+	Fields map[string]AuditChange `json:"fields,omitempty"`
+}
@@ -8,4 +8,5 @@ type Meter struct {
 	WardID     primitive.ObjectID `bson:"ward_id"`
 	Status     string             `bson:"status"`
 	LastUpdate int64              `bson:"last_update"`
+	Enterprise string             `bson:"enterprise,omitempty"`
 }
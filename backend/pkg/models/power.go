@@ -56,6 +56,7 @@ type Incident struct {
 	Timestamp       time.Time            `json:"timestamp" bson:"timestamp"`
 	Description     string               `json:"description" bson:"description"`
 	Severity        string               `json:"severity" bson:"severity"`
+	ZoneID          string               `json:"zone_id,omitempty" bson:"zone_id,omitempty"`
 	AffectedSources []primitive.ObjectID `json:"affected_sources" bson:"affected_sources"`
 }
 
@@ -65,6 +66,7 @@ type AIRecommendation struct {
 	Recommendation string             `json:"recommendation" bson:"recommendation"`
 	TargetArea     string             `json:"target_area" bson:"target_area"`
 	ExpectedImpact string             `json:"expected_impact" bson:"expected_impact"`
+	ZoneID         string             `json:"zone_id,omitempty" bson:"zone_id,omitempty"`
 }
 
 const (
@@ -0,0 +1,81 @@
+package models
+
+const (
+	PermPowerRead     = "power:read"
+	PermHistoryRead   = "history:read"
+	PermMeterRead     = "meter:read"
+	PermGridRead      = "grid:read"
+	PermGridWrite     = "grid:write"
+	PermGridBalance   = "grid:balance"
+	PermIncidentRead  = "incident:read"
+	PermIncidentWrite = "incident:write"
+	PermUserManage    = "user:manage"
+	PermMeterWrite    = "meter:write"
+	PermForecastWrite = "forecast:write"
+	// PermTenantAll lifts enterprise scoping so the caller sees every tenant.
+	PermTenantAll = "tenant:all"
+)
+
+const (
+	RoleAnalyst            = "analyst"
+	RoleEnterpriseCustomer = "enterprise_customer"
+	RoleGridOperator       = "grid_operator"
+	RoleAdmin              = "admin"
+)
+
+// Permission is a grantable capability. RequireMFA permissions are only
+// usable from sessions opened with a second factor.
+type Permission struct {
+	Name        string `json:"name" bson:"_id"`
+	Description string `json:"description" bson:"description"`
+	RequireMFA  bool   `json:"require_mfa" bson:"require_mfa"`
+}
+
+// Role grants its own permissions plus everything granted by the roles it
+// inherits from.
+type Role struct {
+	Name        string   `json:"name" bson:"_id"`
+	Description string   `json:"description" bson:"description"`
+	Inherits    []string `json:"inherits" bson:"inherits"`
+	Permissions []string `json:"permissions" bson:"permissions"`
+}
+
+var DefaultPermissions = []Permission{
+	{Name: PermPowerRead, Description: "Read generation, demand, forecast and outage data"},
+	{Name: PermHistoryRead, Description: "Read historical consumption"},
+	{Name: PermMeterRead, Description: "Read meter inventory"},
+	{Name: PermGridRead, Description: "Read grids and grid networks"},
+	{Name: PermGridWrite, Description: "Edit grids and grid networks"},
+	{Name: PermGridBalance, Description: "Rebalance energy across a grid network", RequireMFA: true},
+	{Name: PermIncidentRead, Description: "Read incidents and AI recommendations"},
+	{Name: PermIncidentWrite, Description: "Record and update incidents"},
+	{Name: PermUserManage, Description: "Manage users, roles, service accounts and credentials", RequireMFA: true},
+	{Name: PermMeterWrite, Description: "Ingest meter consumption readings"},
+	{Name: PermForecastWrite, Description: "Publish demand and supply forecasts"},
+	{Name: PermTenantAll, Description: "Read and act on data of every enterprise"},
+}
+
+var DefaultRoles = []Role{
+	{
+		Name:        RoleEnterpriseCustomer,
+		Description: "Customer with read access to its own enterprise",
+		Permissions: []string{PermPowerRead, PermHistoryRead, PermMeterRead, PermGridRead, PermIncidentRead},
+	},
+	{
+		Name:        RoleAnalyst,
+		Description: "Read-only access across the network",
+		Permissions: []string{PermPowerRead, PermHistoryRead, PermMeterRead, PermGridRead, PermIncidentRead, PermTenantAll},
+	},
+	{
+		Name:        RoleGridOperator,
+		Description: "Operates and rebalances the grid",
+		Inherits:    []string{RoleAnalyst},
+		Permissions: []string{PermGridWrite, PermGridBalance, PermIncidentWrite},
+	},
+	{
+		Name:        RoleAdmin,
+		Description: "Full access including user management",
+		Inherits:    []string{RoleGridOperator},
+		Permissions: []string{PermUserManage, PermMeterWrite, PermForecastWrite},
+	},
+}
@@ -5,3 +5,11 @@ type Response struct {
 	Message string      `json:"message"`
 	Data    interface{} `json:"data"`
 }
+
+// Page is the Data of a paginated list response.
+type Page struct {
+	Items interface{} `json:"items"`
+	Total int64       `json:"total"`
+	Page  int64       `json:"page"`
+	Limit int64       `json:"limit"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ServiceAccount is a non-human caller such as a meter gateway, SCADA bridge
+// or the forecasting service. It authenticates with API keys.
+type ServiceAccount struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description" bson:"description"`
+	Enterprise  string             `json:"enterprise,omitempty" bson:"enterprise,omitempty"`
+	Scopes      []string           `json:"scopes" bson:"scopes"`
+	Disabled    bool               `json:"disabled" bson:"disabled"`
+	CreatedBy   string             `json:"created_by" bson:"created_by"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// APIKey is looked up by its public prefix; only a SHA-256 of the secret
+// part is stored. Its scopes are a subset of the owning account's.
+type APIKey struct {
+	ID               primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	ServiceAccountID primitive.ObjectID  `json:"service_account_id" bson:"service_account_id"`
+	Prefix           string              `json:"prefix" bson:"prefix"`
+	KeyHash          string              `json:"-" bson:"key_hash"`
+	Scopes           []string            `json:"scopes" bson:"scopes"`
+	CreatedAt        time.Time           `json:"created_at" bson:"created_at"`
+	ExpiresAt        *time.Time          `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	RevokedAt        *time.Time          `json:"revoked_at,omitempty" bson:"revoked_at"`
+	LastUsedAt       *time.Time          `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	RotatedFrom      *primitive.ObjectID `json:"rotated_from,omitempty" bson:"rotated_from,omitempty"`
+}
+
+type APIKeyRequest struct {
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is one login and every refresh token rotated from it. Revoking
+// the session invalidates the whole token family and any access token that
+// carries its ID.
+type Session struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID        primitive.ObjectID `json:"user_id" bson:"user_id"`
+	UserAgent     string             `json:"user_agent" bson:"user_agent"`
+	IP            string             `json:"ip" bson:"ip"`
+	MFA           bool               `json:"mfa" bson:"mfa"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt     time.Time          `json:"expires_at" bson:"expires_at"`
+	RevokedAt     *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at"`
+	RevokedReason string             `json:"revoked_reason,omitempty" bson:"revoked_reason,omitempty"`
+}
+
+type RefreshToken struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	SessionID primitive.ObjectID `json:"session_id" bson:"session_id"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	TokenHash string             `json:"-" bson:"token_hash"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time          `json:"expires_at" bson:"expires_at"`
+	UsedAt    *time.Time         `json:"used_at,omitempty" bson:"used_at"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
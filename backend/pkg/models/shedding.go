@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	SheddingProportional = "proportional"
+	SheddingPriority     = "priority"
+	SheddingRolling      = "rolling"
+)
+
+// SheddingSchedule records how a balancing run cut load because demand
+// exceeded the grid's capacity. It is stored with the run's transfers.
+type SheddingSchedule struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	GridID    primitive.ObjectID `json:"grid_id" bson:"grid_id"`
+	PlanID    primitive.ObjectID `json:"plan_id,omitempty" bson:"plan_id,omitempty"`
+	Policy    string             `json:"policy" bson:"policy"`
+	Capacity  float64            `json:"capacity" bson:"capacity"`     // in MW
+	Demand    float64            `json:"demand" bson:"demand"`         // in MW
+	TotalShed float64            `json:"total_shed" bson:"total_shed"` // in MW
+	Entries   []SheddingEntry    `json:"entries" bson:"entries"`       // one per child node
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+type SheddingEntry struct {
+	NodeID        primitive.ObjectID `json:"node_id" bson:"node_id"`
+	Name          string             `json:"name" bson:"name"`
+	PriorityClass string             `json:"priority_class" bson:"priority_class"`
+	Interruptible bool               `json:"interruptible" bson:"interruptible"`
+	Demand        float64            `json:"demand" bson:"demand"` // in MW
+	Served        float64            `json:"served" bson:"served"` // in MW
+	Shed          float64            `json:"shed" bson:"shed"`     // in MW
+	Rule          string             `json:"rule" bson:"rule"`     // one of the Rule* constants
+	Reason        string             `json:"reason" bson:"reason"`
+}
+
+// SheddingHistory accumulates how much load a node has lost across runs.
+// The rolling policy sheds the nodes with the least history first.
+type SheddingHistory struct {
+	GridID     primitive.ObjectID `json:"grid_id" bson:"grid_id"`
+	NodeID     primitive.ObjectID `json:"node_id" bson:"node_id"`
+	TotalShed  float64            `json:"total_shed" bson:"total_shed"` // MW summed over runs
+	Events     int                `json:"events" bson:"events"`
+	LastShedAt time.Time          `json:"last_shed_at" bson:"last_shed_at"`
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StorageAsset is a battery or other store connected at one of a grid's
+// nodes. Dispatch is the power it was last told to take (positive,
+// charging) or give (negative, discharging); StateOfCharge is as of
+// SoCUpdatedAt and moves with Dispatch from then on.
+type StorageAsset struct {
+	ID                  primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name                string             `json:"name" bson:"name"`
+	NodeID              primitive.ObjectID `json:"node_id" bson:"node_id"`
+	CapacityMWh         float64            `json:"capacity_mwh" bson:"capacity_mwh"`
+	StateOfCharge       float64            `json:"state_of_charge" bson:"state_of_charge"` // in MWh
+	MaxChargeMW         float64            `json:"max_charge_mw" bson:"max_charge_mw"`
+	MaxDischargeMW      float64            `json:"max_discharge_mw" bson:"max_discharge_mw"`
+	RoundTripEfficiency float64            `json:"round_trip_efficiency" bson:"round_trip_efficiency"` // 0 to 1
+	Dispatch            float64            `json:"dispatch" bson:"dispatch"`                           // in MW
+	SoCUpdatedAt        time.Time          `json:"soc_updated_at" bson:"soc_updated_at"`
+}
+
+// StorageAssetRequest is the body for creating or replacing a storage
+// asset. StateOfCharge is only read on create; afterwards it is driven by
+// balancing.
+type StorageAssetRequest struct {
+	Name                string             `json:"name"`
+	NodeID              primitive.ObjectID `json:"node_id"`
+	CapacityMWh         float64            `json:"capacity_mwh"`
+	StateOfCharge       float64            `json:"state_of_charge"`
+	MaxChargeMW         float64            `json:"max_charge_mw"`
+	MaxDischargeMW      float64            `json:"max_discharge_mw"`
+	RoundTripEfficiency float64            `json:"round_trip_efficiency"`
+}
+
+// StorageDispatch is what a balance asks of one storage asset.
+type StorageDispatch struct {
+	StorageID primitive.ObjectID `json:"storage_id" bson:"storage_id"`
+	NodeID    primitive.ObjectID `json:"node_id" bson:"node_id"`
+	Mode      string             `json:"mode" bson:"mode"`             // one of the Storage* constants
+	Power     float64            `json:"power" bson:"power"`           // in MW, positive charging
+	SoCBefore float64            `json:"soc_before" bson:"soc_before"` // in MWh
+	SoCAfter  float64            `json:"soc_after" bson:"soc_after"`   // in MWh, expected once the dispatch has run its horizon
+}
+
+const (
+	StorageCharge    = "charge"
+	StorageDischarge = "discharge"
+	StorageIdle      = "idle"
+)
+
+// StorageSoCRecord is one point of a storage asset's state of charge
+// history, written whenever a balance changes its dispatch.
+type StorageSoCRecord struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	GridID        primitive.ObjectID `json:"grid_id" bson:"grid_id"`
+	StorageID     primitive.ObjectID `json:"storage_id" bson:"storage_id"`
+	PlanID        primitive.ObjectID `json:"plan_id" bson:"plan_id"`
+	StateOfCharge float64            `json:"state_of_charge" bson:"state_of_charge"` // in MWh, at RecordedAt
+	Mode          string             `json:"mode" bson:"mode"`
+	Dispatch      float64            `json:"dispatch" bson:"dispatch"` // in MW from RecordedAt on
+	RecordedAt    time.Time          `json:"recorded_at" bson:"recorded_at"`
+}
@@ -8,12 +8,27 @@ import (
 )
 
 type User struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty"`
-	Name       string             `bson:"name"`
-	Email      string             `bson:"email"`
-	Password   string             `bson:"password"`
-	Role       string             `json:"role"`
-	Enterprise string             `json:"enterprise,omitempty"`
+	ID                primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Username          string             `json:"username" bson:"username"`
+	Name              string             `json:"name" bson:"name"`
+	Email             string             `json:"email" bson:"email"`
+	Password          string             `json:"password,omitempty" bson:"password"` // bcrypt hash once stored
+	Role              string             `json:"role" bson:"role"`
+	Enterprise        string             `json:"enterprise,omitempty" bson:"enterprise"`
+	Disabled          bool               `json:"disabled" bson:"disabled"`
+	PasswordChangedAt time.Time          `json:"password_changed_at" bson:"password_changed_at"`
+	CreatedAt         time.Time          `json:"created_at" bson:"created_at"`
+
+	MFAEnabled       bool     `json:"mfa_enabled" bson:"mfa_enabled"`
+	MFASecret        string   `json:"-" bson:"mfa_secret,omitempty"`
+	MFAPendingSecret string   `json:"-" bson:"mfa_pending_secret,omitempty"`
+	MFARecoveryCodes []string `json:"-" bson:"mfa_recovery_codes,omitempty"` // SHA-256 hashes
+	MFALastStep      int64    `json:"-" bson:"mfa_last_step"`                // last TOTP step accepted
+
+	// Set for accounts provisioned from the OIDC identity provider. Such
+	// accounts have no local password.
+	OIDCIssuer  string `json:"oidc_issuer,omitempty" bson:"oidc_issuer,omitempty"`
+	OIDCSubject string `json:"oidc_subject,omitempty" bson:"oidc_subject,omitempty"`
 }
 
 type Credentials struct {
@@ -21,16 +36,48 @@ type Credentials struct {
 	Password string `json:"password"`
 }
 
+type MFALogin struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFACode struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+	Password     string `json:"password,omitempty"`
+}
+
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type PasswordReset struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// PasswordResetToken is a single-use reset grant. Only the SHA-256 of the
+// token handed to the user is stored.
+type PasswordResetToken struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	TokenHash string             `json:"-" bson:"token_hash"`
+	ExpiresAt time.Time          `json:"expires_at" bson:"expires_at"`
+	UsedAt    *time.Time         `json:"used_at,omitempty" bson:"used_at"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Claims is the single token shape issued at login and accepted by
+// WithJWTAuth. The user ID travels in the registered "sub" claim and "sid"
+// ties the token to a revocable session. MFA is set when the session was
+// opened with a second factor.
 type Claims struct {
 	Username   string `json:"username"`
 	Role       string `json:"role"`
 	Enterprise string `json:"enterprise,omitempty"`
+	SessionID  string `json:"sid"`
+	MFA        bool   `json:"mfa,omitempty"`
 	jwt.RegisteredClaims
 }
-
-func (c *Claims) Valid() error {
-	if c.ExpiresAt.Time.Before(time.Now()) {
-		return jwt.ErrTokenExpired
-	}
-	return nil
-}
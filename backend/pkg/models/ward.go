@@ -1,9 +1,10 @@
 package models
 
 type Ward struct {
-	Name         string  `json:"name"`
-	Location     Location  `json:"location"`
-	Power_Demand float64 `json:"ps"`
-	Power_Supply float64 `json:"pd"`
-	Renewable    float64 `json:"r"`
+	Name         string   `json:"name"`
+	Location     Location `json:"location"`
+	Power_Demand float64  `json:"ps"`
+	Power_Supply float64  `json:"pd"`
+	Renewable    float64  `json:"r"`
+	Enterprise   string   `json:"enterprise,omitempty" bson:"enterprise,omitempty"`
 }
@@ -0,0 +1,12 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Zone maps the zone_id used by demand, forecast, outage and consumption
+// records to the enterprise that owns it.
+type Zone struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ZoneID     string             `json:"zone_id" bson:"zone_id"`
+	Name       string             `json:"name" bson:"name"`
+	Enterprise string             `json:"enterprise" bson:"enterprise"`
+}
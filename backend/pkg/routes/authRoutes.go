@@ -1,17 +1,57 @@
 package routes
 
 import (
+	"time"
+
 	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/controllers"
 	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
 	"github.com/gofiber/fiber/v2"
 )
 
 func AuthRoutes(app *fiber.App) {
 	api := app.Group("/api")
 
-	api.Post("/register", controllers.Register)
-	api.Post("/login", controllers.Login)
+	api.Post("/register",
+		middleware.RateLimit("register-ip", "REGISTER_IP_RATE_LIMIT", 5, time.Hour, middleware.ByIP),
+		controllers.Register)
+	api.Post("/login",
+		middleware.RateLimit("login-ip", "LOGIN_IP_RATE_LIMIT", 20, time.Minute, middleware.ByIP),
+		middleware.RateLimit("login-user", "LOGIN_USER_RATE_LIMIT", 10, time.Minute, middleware.ByUsername),
+		controllers.Login)
+	api.Post("/login/mfa",
+		middleware.RateLimit("login-ip", "LOGIN_IP_RATE_LIMIT", 20, time.Minute, middleware.ByIP),
+		controllers.LoginMFA)
+	api.Get("/oidc/login",
+		middleware.RateLimit("login-ip", "LOGIN_IP_RATE_LIMIT", 20, time.Minute, middleware.ByIP),
+		controllers.OIDCLogin)
+	api.Get("/oidc/callback", controllers.OIDCCallback)
+	api.Post("/refresh", controllers.Refresh)
+	api.Post("/logout", middleware.WithJWTAuth(), controllers.Logout)
+	api.Post("/logout/all", middleware.WithJWTAuth(), controllers.LogoutAll)
+	api.Post("/password/reset", controllers.ResetPassword)
+	api.Post("/password/change", middleware.WithJWTAuth(), controllers.ChangePassword)
+
+	mfa := api.Group("/mfa", middleware.WithJWTAuth())
+	mfa.Post("/enroll", controllers.EnrollMFA)
+	mfa.Post("/verify", controllers.VerifyMFA)
+	mfa.Post("/disable", controllers.DisableMFA)
+	mfa.Post("/recovery-codes", controllers.RegenerateRecoveryCodes)
 
-	protected := api.Group("/admin", middleware.WithJWTAuth())
+	protected := api.Group("/admin", middleware.WithJWTAuth(), middleware.RequirePermission(models.PermUserManage))
 	protected.Patch("/user/:id/role", controllers.UpdateRole)
+	protected.Get("/roles", controllers.GetRoles)
+	protected.Put("/roles/:name", controllers.SaveRole)
+	protected.Patch("/permissions/:name/mfa", controllers.SetPermissionMFA)
+
+	protected.Post("/service-accounts", controllers.CreateServiceAccount)
+	protected.Get("/service-accounts", controllers.GetServiceAccounts)
+	protected.Post("/service-accounts/:id/keys", controllers.CreateAPIKey)
+	protected.Get("/service-accounts/:id/keys", controllers.GetAPIKeys)
+	protected.Post("/api-keys/:keyId/rotate", controllers.RotateAPIKey)
+	protected.Delete("/api-keys/:keyId", controllers.RevokeAPIKey)
+
+	protected.Get("/audit", controllers.GetAuditEvents)
+	protected.Post("/user/:id/password-reset", controllers.IssuePasswordReset)
+	protected.Patch("/user/:id/disabled", controllers.SetUserDisabled)
 }
@@ -2,19 +2,16 @@ package routes
 
 import (
 	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/controllers"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
 	"github.com/gofiber/fiber/v2"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func SetupRoutes(app *fiber.App) {
+	// Auth is attached per route: middleware on the bare /api group would also
+	// run in front of the public login and registration endpoints.
 	api := app.Group("/api")
-	api.Get("/grids", controllers.GetGrids)
-	api.Patch("/grid/:id", controllers.UpdateGrid)
-}
-
-func SetupGridRoutes(app *fiber.App, db *mongo.Database) {
-	handler := controllers.NewGridDistributionHandler(db)
-
-	grid := app.Group("/api/grid")
-	grid.Post("/balance/:gridId", handler.BalanceGridEnergy)
+	api.Get("/grids", middleware.WithJWTAuth(), middleware.RequirePermission(models.PermGridRead), controllers.GetGrids)
+	api.Patch("/grid/:id", middleware.WithJWTAuth(), middleware.RequirePermission(models.PermGridWrite), controllers.UpdateGrid)
+	api.Get("/meters", middleware.WithJWTAuth(), middleware.RequirePermission(models.PermMeterRead), controllers.GetMeters)
 }
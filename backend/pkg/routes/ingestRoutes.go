@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/controllers"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetupIngestRoutes registers the machine-to-machine write endpoints, used
+// by meters, SCADA gateways and the ml_model service with API keys.
+func SetupIngestRoutes(app *fiber.App) {
+	ingest := app.Group("/api/ingest", middleware.WithJWTAuth())
+	ingest.Post("/meter-readings", middleware.RequirePermission(models.PermMeterWrite), controllers.IngestMeterReadings)
+	ingest.Post("/forecasts", middleware.RequirePermission(models.PermForecastWrite), controllers.IngestForecasts)
+}
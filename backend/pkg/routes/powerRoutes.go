@@ -2,6 +2,8 @@ package routes
 
 import (
 	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/controllers"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -9,17 +11,17 @@ import (
 func SetupPowerRoutes(app *fiber.App, db *mongo.Database) {
 	handler := controllers.NewPowerHandler(db)
 
-	power := app.Group("/api/power")
+	power := app.Group("/api/power", middleware.WithJWTAuth(), middleware.RequirePermission(models.PermPowerRead))
 	power.Get("/generation", handler.GetRealTimePowerGeneration)
 	power.Get("/demand", handler.GetZoneDemandSupply)
 	power.Get("/forecast", handler.GetPowerForecast)
 
-	outages := app.Group("/api/outages")
+	outages := app.Group("/api/outages", middleware.WithJWTAuth(), middleware.RequirePermission(models.PermPowerRead))
 	outages.Get("/active", handler.GetActiveOutages)
 
-	incidents := app.Group("/api/incidents")
+	incidents := app.Group("/api/incidents", middleware.WithJWTAuth(), middleware.RequirePermission(models.PermIncidentRead))
 	incidents.Get("/", handler.GetIncidents)
 
-	ai := app.Group("/api/ai")
+	ai := app.Group("/api/ai", middleware.WithJWTAuth(), middleware.RequirePermission(models.PermIncidentRead))
 	ai.Get("/recommendations", handler.GetAIRecommendations)
 }
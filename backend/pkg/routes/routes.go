@@ -1,14 +1,79 @@
 package routes
 
 import (
+	"time"
+
 	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/controllers"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func SetupGridDistributionRoutes(app *fiber.App, db *mongo.Database) {
 	handler := controllers.NewGridDistributionHandler(db)
-	
-	grid := app.Group("/api/grid-distribution")
-	grid.Post("/balance/:gridId", handler.BalanceGridEnergy)
-} 
\ No newline at end of file
+
+	grid := app.Group("/api/grid-distribution", middleware.WithJWTAuth())
+	grid.Get("/", middleware.RequirePermission(models.PermGridRead), handler.ListGridNetworks)
+	grid.Post("/", middleware.RequirePermission(models.PermGridWrite), handler.CreateGridNetwork)
+	grid.Get("/loss-models", middleware.RequirePermission(models.PermGridRead), handler.GetLossModels)
+	grid.Get("/:gridId", middleware.RequirePermission(models.PermGridRead), handler.GetGridNetwork)
+	grid.Put("/:gridId", middleware.RequirePermission(models.PermGridWrite), handler.UpdateGridNetwork)
+	grid.Delete("/:gridId", middleware.RequirePermission(models.PermGridWrite), handler.DeleteGridNetwork)
+
+	grid.Post("/balance/:gridId",
+		middleware.RequirePermission(models.PermGridBalance),
+		middleware.RateLimit("balance", "BALANCE_RATE_LIMIT", 6, time.Minute, middleware.ByPrincipal),
+		handler.BalanceGridEnergy)
+
+	grid.Post("/:gridId/plans", middleware.RequirePermission(models.PermGridRead), handler.SimulateBalance)
+	grid.Get("/:gridId/plans/:planId", middleware.RequirePermission(models.PermGridRead), handler.GetBalancePlan)
+	grid.Post("/:gridId/plans/:planId/commit",
+		middleware.RequirePermission(models.PermGridBalance),
+		middleware.RateLimit("balance", "BALANCE_RATE_LIMIT", 6, time.Minute, middleware.ByPrincipal),
+		handler.CommitBalancePlan)
+
+	grid.Get("/:gridId/schedule", middleware.RequirePermission(models.PermGridRead), handler.GetBalanceSchedule)
+	grid.Put("/:gridId/schedule", middleware.RequirePermission(models.PermGridWrite), handler.UpdateBalanceSchedule)
+	grid.Post("/:gridId/schedule/pause", middleware.RequirePermission(models.PermGridWrite), handler.PauseBalanceSchedule)
+	grid.Post("/:gridId/schedule/resume", middleware.RequirePermission(models.PermGridWrite), handler.ResumeBalanceSchedule)
+	grid.Get("/:gridId/runs", middleware.RequirePermission(models.PermGridRead), handler.GetBalanceRuns)
+
+	grid.Get("/:gridId/transfers", middleware.RequirePermission(models.PermGridRead), handler.GetEnergyTransfers)
+	grid.Get("/:gridId/transfers/summary", middleware.RequirePermission(models.PermGridRead), handler.GetEnergyTransferSummary)
+	grid.Get("/:gridId/transfers/reconcile", middleware.RequirePermission(models.PermGridRead), handler.ReconcileLedger)
+
+	grid.Get("/:gridId/snapshots", middleware.RequirePermission(models.PermGridRead), handler.GetGridSnapshots)
+	grid.Get("/:gridId/snapshots/diff", middleware.RequirePermission(models.PermGridRead), handler.DiffGridSnapshots)
+	grid.Get("/:gridId/snapshots/:version", middleware.RequirePermission(models.PermGridRead), handler.GetGridSnapshot)
+	grid.Get("/:gridId/state", middleware.RequirePermission(models.PermGridRead), handler.GetGridStateAt)
+
+	grid.Post("/:gridId/contingency",
+		middleware.RequirePermission(models.PermGridBalance),
+		middleware.RateLimit("contingency", "CONTINGENCY_RATE_LIMIT", 6, time.Minute, middleware.ByPrincipal),
+		handler.AnalyseContingencies)
+	grid.Get("/:gridId/contingency/:jobId", middleware.RequirePermission(models.PermGridRead), handler.GetContingencyJob)
+
+	grid.Get("/:gridId/tree", middleware.RequirePermission(models.PermGridRead), handler.GetGridTree)
+	grid.Get("/:gridId/metrics", middleware.RequirePermission(models.PermGridRead), handler.GetGridMetrics)
+
+	grid.Get("/:gridId/nodes", middleware.RequirePermission(models.PermGridRead), handler.ListChildNodes)
+	grid.Post("/:gridId/nodes", middleware.RequirePermission(models.PermGridWrite), handler.CreateChildNode)
+	grid.Get("/:gridId/nodes/:nodeId", middleware.RequirePermission(models.PermGridRead), handler.GetChildNode)
+	grid.Put("/:gridId/nodes/:nodeId", middleware.RequirePermission(models.PermGridWrite), handler.UpdateChildNode)
+	grid.Delete("/:gridId/nodes/:nodeId", middleware.RequirePermission(models.PermGridWrite), handler.DeleteChildNode)
+	grid.Put("/:gridId/nodes/:nodeId/priority", middleware.RequirePermission(models.PermGridWrite), handler.UpdateNodePriority)
+
+	grid.Get("/:gridId/lines", middleware.RequirePermission(models.PermGridRead), handler.GetGridLines)
+	grid.Post("/:gridId/lines", middleware.RequirePermission(models.PermGridWrite), handler.CreateGridLine)
+	grid.Put("/:gridId/lines/:lineId", middleware.RequirePermission(models.PermGridWrite), handler.UpdateGridLine)
+	grid.Delete("/:gridId/lines/:lineId", middleware.RequirePermission(models.PermGridWrite), handler.DeleteGridLine)
+
+	grid.Get("/:gridId/storage", middleware.RequirePermission(models.PermGridRead), handler.GetStorageAssets)
+	grid.Post("/:gridId/storage", middleware.RequirePermission(models.PermGridWrite), handler.CreateStorageAsset)
+	grid.Put("/:gridId/storage/:storageId", middleware.RequirePermission(models.PermGridWrite), handler.UpdateStorageAsset)
+	grid.Delete("/:gridId/storage/:storageId", middleware.RequirePermission(models.PermGridWrite), handler.DeleteStorageAsset)
+	grid.Get("/:gridId/storage/:storageId/history", middleware.RequirePermission(models.PermGridRead), handler.GetStorageHistory)
+
+	grid.Get("/:gridId/curtailments", middleware.RequirePermission(models.PermGridRead), handler.GetCurtailments)
+}
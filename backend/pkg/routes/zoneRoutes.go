@@ -2,13 +2,15 @@ package routes
 
 import (
 	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/controllers"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/middleware"
+	"github.com/ABHINAVGARG05/DJANGO_UNCHAINED/pkg/models"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func SetupHistoryRoutes(app *fiber.App, db *mongo.Database) {
-    handler := controllers.NewHistoryHandler(db)
-    
-    history := app.Group("/api/history")
-    history.Get("/zone/:zoneId", handler.GetZoneHistory)
-}
\ No newline at end of file
+	handler := controllers.NewHistoryHandler(db)
+
+	history := app.Group("/api/history", middleware.WithJWTAuth(), middleware.RequirePermission(models.PermHistoryRead))
+	history.Get("/zone/:zoneId", handler.GetZoneHistory)
+}